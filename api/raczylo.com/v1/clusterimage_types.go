@@ -41,6 +41,44 @@ type ClusterImageSpec struct {
 	JobAnnotations map[string]string `json:"jobAnnotations,omitempty"`
 	// +kubebuilder:validation:Optional
 	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// Name of the export which manifest is checked before exporting the image
+	// +kubebuilder:validation:Optional
+	Baseline string `json:"baseline,omitempty"`
+	// Platforms of the image to export, all for the whole manifest list, empty for the platform of the node
+	// +kubebuilder:validation:Optional
+	Platforms []string `json:"platforms,omitempty"`
+	// Export the referrers of the image digest next to the image
+	// +kubebuilder:validation:Optional
+	Referrers bool `json:"referrers,omitempty"`
+}
+
+// ClusterImageReferrer is the artifact attached to the image digest: signature, attestation, SBOM or other OCI referrer
+type ClusterImageReferrer struct {
+	// signature, attestation, sbom or referrer
+	Kind string `json:"kind"`
+	// Digest of the referrer manifest
+	Digest string `json:"digest"`
+	// Artifact type reported by the referrers API
+	ArtifactType string `json:"artifactType,omitempty"`
+	// Tag the cosign artifact is stored under, empty for the referrers found by the referrers API
+	Tag string `json:"tag,omitempty"`
+	// Location of the exported referrer
+	Artifact string `json:"artifact,omitempty"`
+}
+
+// ClusterImageAttempt records a single run of the export job
+type ClusterImageAttempt struct {
+	JobName        string       `json:"jobName"`
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Progress the attempt ended with
+	Result string `json:"result"`
+	// Exit code of the failed container
+	ExitCode int32 `json:"exitCode,omitempty"`
+	// Termination message of the failed container, the tail of its output
+	Message string `json:"message,omitempty"`
+	// Class of the failure the retry decision was based on
+	FailureClass string `json:"failureClass,omitempty"`
 }
 
 // ClusterImageStatus defines the observed state of ClusterImage
@@ -49,6 +87,34 @@ type ClusterImageStatus struct {
 	// default value is 0
 	// +kubebuilder:default:=0
 	RetryCount int `json:"retryCount,omitempty"`
+	// Location of the stored image, points to the baseline export if image was already present there
+	Artifact string `json:"artifact,omitempty"`
+	// Size of the stored image tarball in bytes
+	Size int64 `json:"size,omitempty"`
+	// Checksum (sha256) of the stored image tarball
+	Sha256 string `json:"sha256,omitempty"`
+	// Media type of the image manifest, set only for the images stored in the OCI layout
+	MediaType string `json:"mediaType,omitempty"`
+	// Name of the last export job, kept after the job is removed
+	JobName string `json:"jobName,omitempty"`
+	// Start of the last export job
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// Time the last export job finished
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Exit code of the last failed container
+	ExitCode int32 `json:"exitCode,omitempty"`
+	// Termination message of the last failed container, the tail of its output
+	LastError string `json:"lastError,omitempty"`
+	// Class of the last failure: RateLimited, Unauthorized, NotFound or Other
+	FailureClass string `json:"failureClass,omitempty"`
+	// Time the next attempt of the RETRYING image is started at
+	NextRetryTime *metav1.Time `json:"nextRetryTime,omitempty"`
+	// Referrers of the image exported next to it, or stored by the baseline export for the PRESENT image
+	// +listType=atomic
+	Referrers []ClusterImageReferrer `json:"referrers,omitempty"`
+	// Finished attempts of the export, the oldest are dropped once the history is full
+	// +listType=atomic
+	Attempts []ClusterImageAttempt `json:"attempts,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -62,6 +128,8 @@ type ClusterImageStatus struct {
 // +kubebuilder:printcolumn:name="Path",type="string",JSONPath=".spec.exportPath"
 // +kubebuilder:printcolumn:name="Progress",type="string",JSONPath=".status.progress"
 // +kubebuilder:printcolumn:name="Retries",type="integer",JSONPath=".status.retryCount"
+// +kubebuilder:printcolumn:name="Job",type="string",JSONPath=".status.jobName",priority=1
+// +kubebuilder:printcolumn:name="Error",type="string",JSONPath=".status.lastError",priority=1
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 type ClusterImage struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -34,15 +34,137 @@ type ClusterImageStorageS3 struct {
 	// Defines the endpoint for the S3 storage
 	// If none specified - default AWS endpoint will be used
 	Endpoint string `json:"endpoint,omitempty"`
-	// Defines the secret name for credentials
+	// Defines the secret name for credentials, the secret has to contain AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+	// Takes precedence over the accessKey and secretKey
 	SecretName string `json:"secretName,omitempty"`
 }
 
+// ClusterImageStorageFile defines the volume the images are stored in by the file target.
+// Exactly one of the volume sources has to be set.
+type ClusterImageStorageFile struct {
+	// +kubebuilder:validation:Optional
+	PersistentVolumeClaim *corev1.PersistentVolumeClaimVolumeSource `json:"persistentVolumeClaim,omitempty"`
+	// +kubebuilder:validation:Optional
+	NFS *corev1.NFSVolumeSource `json:"nfs,omitempty"`
+	// Host path has to be available on every node the jobs can be scheduled on
+	// +kubebuilder:validation:Optional
+	HostPath *corev1.HostPathVolumeSource `json:"hostPath,omitempty"`
+}
+
+// ClusterImageStorageRegistry defines the registry the images are mirrored to
+type ClusterImageStorageRegistry struct {
+	// Registry host, e.g. staging.registry.internal:5000
+	// +kubebuilder:validation:MinLength=1
+	Registry string `json:"registry"`
+	// Template of the repository path in the registry. Available fields are .Repository (e.g. library/nginx),
+	// .Name (e.g. nginx), .SourceRegistry (e.g. docker.io), .Namespace and .ExportName.
+	// Defaults to {{ .Repository }}
+	// +kubebuilder:validation:Optional
+	RepositoryTemplate string `json:"repositoryTemplate,omitempty"`
+	// Name of the docker config secret with the credentials of the registry
+	// +kubebuilder:validation:Optional
+	SecretName string `json:"secretName,omitempty"`
+	// Skip the TLS verification of the registry
+	// +kubebuilder:validation:Optional
+	Insecure bool `json:"insecure,omitempty"`
+}
+
 // ClusterImageStorageSpec defines the desired state of ClusterImageStorage
 type ClusterImageStorageSpec struct {
-	// +kubebuilder:validation:Enum=file;S3
+	// +kubebuilder:validation:Enum=file;S3;registry
 	StorageTarget string                `json:"target"`
 	S3            ClusterImageStorageS3 `json:"s3,omitempty"`
+	// Volume of the file target, mounted into every job reading or writing the export
+	// +kubebuilder:validation:Optional
+	File ClusterImageStorageFile `json:"file,omitempty"`
+	// Destination of the registry target, images are copied directly with all the platforms and signatures
+	// +kubebuilder:validation:Optional
+	Registry ClusterImageStorageRegistry `json:"registry,omitempty"`
+}
+
+// CustomWorkload describes the custom resource holding pod templates, e.g. Argo Rollouts
+type CustomWorkload struct {
+	// API version of the resource, e.g. argoproj.io/v1alpha1
+	// +kubebuilder:validation:MinLength=1
+	APIVersion string `json:"apiVersion"`
+	// +kubebuilder:validation:MinLength=1
+	Kind string `json:"kind"`
+	// JSONPath expressions pointing to the pod specs within the resource.
+	// Defaults to {.spec.template.spec}
+	// +kubebuilder:validation:Optional
+	PodSpecPaths []string `json:"podSpecPaths,omitempty"`
+}
+
+// RetryPolicy defines how the failed image export jobs are retried
+type RetryPolicy struct {
+	// Number of the retries of the failed image. Defaults to 3
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MaxRetries *int `json:"maxRetries,omitempty"`
+	// Delay before the first retry, doubled with every next one. Defaults to 30s
+	// +kubebuilder:validation:Optional
+	InitialBackoff *metav1.Duration `json:"initialBackoff,omitempty"`
+	// Upper limit of the delay, rate limited images always wait this long. Defaults to 10m
+	// +kubebuilder:validation:Optional
+	MaxBackoff *metav1.Duration `json:"maxBackoff,omitempty"`
+	// Classes of the failures which are retried. Defaults to RateLimited and Other,
+	// as the Unauthorized and NotFound failures usually need the credentials or the spec fixed first
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:items:Enum=RateLimited;Unauthorized;NotFound;Other
+	RetryOn []string `json:"retryOn,omitempty"`
+}
+
+// ImageFilter matches a single field of the image reference
+type ImageFilter struct {
+	// Field of the image reference the value is matched against, e.g. for ghcr.io/acme/api:1.2.0@sha256:abc
+	// registry is ghcr.io, repository is acme/api, tag is 1.2.0, digest is sha256:abc and fullName is the whole reference.
+	// Images from Docker Hub have the docker.io registry and the library/ prefix of the official images.
+	// Defaults to repository
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=registry;repository;tag;digest;fullName
+	// +kubebuilder:default=repository
+	Field string `json:"field,omitempty"`
+	// How the value is matched. substring matches case-insensitively anywhere in the field, glob matches
+	// the whole field with * and ? wildcards, regex matches anywhere in the field unless anchored with ^ and $,
+	// semver matches the tags within the range, e.g. ">=1.25.0 <2.0.0". Defaults to glob
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=substring;glob;regex;semver
+	// +kubebuilder:default=glob
+	Match string `json:"match,omitempty"`
+	// +kubebuilder:validation:MinLength=1
+	Value string `json:"value"`
+}
+
+// ImageFilters select the exported images, on top of the includes and excludes
+type ImageFilters struct {
+	// Only the images matching any of these rules are exported
+	// +kubebuilder:validation:Optional
+	Include []ImageFilter `json:"include,omitempty"`
+	// Images matching any of these rules are not exported
+	// +kubebuilder:validation:Optional
+	Exclude []ImageFilter `json:"exclude,omitempty"`
+	// Rule which wins when the image matches both. With exclude (default) the excluded images are never exported.
+	// With include the include rules only make exceptions from the exclude rules, so the images matching
+	// neither of them are exported too
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=exclude;include
+	// +kubebuilder:default=exclude
+	Precedence string `json:"precedence,omitempty"`
+}
+
+// FailurePolicy defines how the failed images affect the whole export
+type FailurePolicy struct {
+	// FailFast fails the export with the first failed image. Continue exports the remaining images and completes
+	// the export as COMPLETED_WITH_ERRORS. Threshold behaves like Continue until the failed images exceed the threshold
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=FailFast;Continue;Threshold
+	// +kubebuilder:default=FailFast
+	Type string `json:"type,omitempty"`
+	// Percentage of the images of the export which can fail with the Threshold policy
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	Threshold int `json:"threshold,omitempty"`
 }
 
 // ClusterImageExportSpec defines the desired state of ClusterImageExport
@@ -50,14 +172,28 @@ type ClusterImageStorageSpec struct {
 // +kubebuilder:printcolumn:name="Storage",type="string",JSONPath=".spec.storage.target"
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 type ClusterImageExportSpec struct {
-	Name      string      `json:"name"`
+	// +kubebuilder:validation:Optional
+	Name      string      `json:"name,omitempty"`
 	CreatedAt metav1.Time `json:"createdAt,omitempty"`
 	// Exclude images which contain these strings
 	Excludes []string `json:"excludes,omitempty"`
 	// Include only images which contain these strings
-	Includes           []string `json:"includes,omitempty"`
-	Namespaces         []string `json:"namespaces,omitempty"`
-	ExcludedNamespaces []string `json:"excludedNamespaces,omitempty"`
+	Includes []string `json:"includes,omitempty"`
+	// Rules matching the registry, repository, tag or digest of the images, applied after the includes and excludes
+	// +kubebuilder:validation:Optional
+	Filters            ImageFilters `json:"filters,omitempty"`
+	Namespaces         []string     `json:"namespaces,omitempty"`
+	ExcludedNamespaces []string     `json:"excludedNamespaces,omitempty"`
+	// Export the images of the namespaces with matching labels too, together with the namespaces list
+	// +kubebuilder:validation:Optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Skip the images of the namespaces with matching labels
+	// +kubebuilder:validation:Optional
+	ExcludedNamespaceSelector *metav1.LabelSelector `json:"excludedNamespaceSelector,omitempty"`
+	// Discover the images only from the workloads with matching labels. Pods and replica sets are
+	// matched by their own labels too, so they don't bring back the images of the skipped workloads.
+	// +kubebuilder:validation:Optional
+	WorkloadSelector *metav1.LabelSelector `json:"workloadSelector,omitempty"`
 	// Base path for the export - both file and S3
 	// +kubebuilder:validation:MinLength=1
 	// +kubebuilder:validation:MaxLength=255
@@ -71,11 +207,104 @@ type ClusterImageExportSpec struct {
 	// +kubebuilder:validation.Maximum=100
 	MaxConcurrentJobs int      `json:"maxConcurrentJobs"`
 	AdditionalImages  []string `json:"additionalImages,omitempty"`
+	// Where the images are discovered from. "spec" reads the image strings of the workloads,
+	// "running" reads the digests reported in the status of the running pods
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=spec;running
+	// +kubebuilder:default=spec
+	Source string `json:"source,omitempty"`
+	// Custom resources to discover the images from, on top of the built-in workloads.
+	// The operator needs the list permission for these resources.
+	// +kubebuilder:validation:Optional
+	CustomWorkloads []CustomWorkload `json:"customWorkloads,omitempty"`
+	// Name of the previous export to compare against or "latest" for the most recent completed one.
+	// Images listed in the baseline export manifest are marked as PRESENT instead of being exported again.
+	// +kubebuilder:validation:Optional
+	Baseline string `json:"baseline,omitempty"`
+	// Resolve the tags to the manifest digests during the discovery, so the export records exactly what was pulled
+	// +kubebuilder:validation:Optional
+	ResolveDigests bool `json:"resolveDigests,omitempty"`
+	// Format of the stored images. docker-archive and oci-archive store every image in its own tarball,
+	// oci-layout stores all the images in the single OCI layout, so the layers shared between images are stored once
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=docker-archive;oci-archive;oci-layout
+	// +kubebuilder:default=docker-archive
+	Format string `json:"format,omitempty"`
+	// Platforms of the multi-platform images to export, e.g. linux/amd64 and linux/arm64, or all for the whole
+	// manifest list. More than one platform needs the oci-archive or oci-layout format, docker-archive holds
+	// a single image. Defaults to the platform of the node the job runs on
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:items:Pattern=`^(all|[a-z0-9]+/[a-z0-9_]+(/[a-z0-9]+)?)$`
+	Platforms []string `json:"platforms,omitempty"`
+	// Discover the OCI referrers and the cosign signatures, attestations and SBOMs of the image digest and export
+	// them next to the image. Outside the registry target the image has to be stored with its original digest
+	// for them to stay valid, which needs the oci-archive or oci-layout format with all the platforms
+	// +kubebuilder:validation:Optional
+	Referrers bool `json:"referrers,omitempty"`
+	// Write manifest.yaml next to the manifest.json in the export root
+	// +kubebuilder:validation:Optional
+	ManifestYAML bool `json:"manifestYAML,omitempty"`
+	// How the failed image export jobs are retried
+	// +kubebuilder:validation:Optional
+	RetryPolicy RetryPolicy `json:"retryPolicy,omitempty"`
+	// How the failed images affect the whole export, the export fails with the first failed image by default
+	// +kubebuilder:validation:Optional
+	FailurePolicy FailurePolicy `json:"failurePolicy,omitempty"`
+	// Only plan the export: the discovered images and whether they would be exported or are already present
+	// are written into the plan ConfigMap, no ClusterImages or jobs are created. Turning the dry run off
+	// runs the planned export.
+	// +kubebuilder:validation:Optional
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// ClusterImageExportPlan summarises the plan of the dry run export
+type ClusterImageExportPlan struct {
+	// Name of the ConfigMap with the planned images and the decisions made for them
+	ConfigMap string `json:"configMap,omitempty"`
+	// Number of the images which would be exported
+	Export int `json:"export,omitempty"`
+	// Number of the images already exported by another ClusterImage or stored by the baseline export
+	Present int `json:"present,omitempty"`
+	// Estimated size of the images to export in bytes, taken from the earlier exports or the compressed
+	// size reported by the registry. Images of unknown size are not included.
+	EstimatedSize int64 `json:"estimatedSize,omitempty"`
+	// Number of the images to export the size could not be estimated for
+	UnknownSize int `json:"unknownSize,omitempty"`
 }
 
 // ClusterImageExportStatus defines the observed state of ClusterImageExport
 type ClusterImageExportStatus struct {
 	Progress string `json:"progress,omitempty"`
+	// Name of the export used as the baseline, resolved when the export starts
+	Baseline string `json:"baseline,omitempty"`
+	// Generation of the spec the status was reported for
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Discovered, Exporting, Ready and Degraded conditions of the export
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+	// Number of the images discovered for the export
+	Total int `json:"total,omitempty"`
+	// Number of the images waiting for the export job
+	Pending int `json:"pending,omitempty"`
+	// Number of the images with the export job running
+	Running int `json:"running,omitempty"`
+	// Number of the images exported by this export
+	Succeeded int `json:"succeeded,omitempty"`
+	// Number of the images already stored by the baseline export
+	Present int `json:"present,omitempty"`
+	// Number of the images which failed to export, after all the retries
+	Failed int `json:"failed,omitempty"`
+	// Full names of the images which failed to export
+	FailedImages []string     `json:"failedImages,omitempty"`
+	StartTime    *metav1.Time `json:"startTime,omitempty"`
+	// Time the export completed or failed
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Value of the raczylo.com/retry-failed annotation the failed images were last retried for
+	LastRetry string `json:"lastRetry,omitempty"`
+	// Plan of the dry run export
+	Plan *ClusterImageExportPlan `json:"plan,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -85,6 +314,9 @@ type ClusterImageExportStatus struct {
 // +kubebuilder:printcolumn:name="BasePath",type="string",JSONPath=".spec.basePath"
 // +kubebuilder:printcolumn:name="Storage",type="string",JSONPath=".spec.storage.target"
 // +kubebuilder:printcolumn:name="Progress",type="string",JSONPath=".status.progress"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Images",type="integer",JSONPath=".status.total"
+// +kubebuilder:printcolumn:name="Failed",type="integer",JSONPath=".status.failed",priority=1
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 type ClusterImageExport struct {
 	metav1.TypeMeta   `json:",inline"`
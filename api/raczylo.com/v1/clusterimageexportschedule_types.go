@@ -0,0 +1,115 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConcurrencyPolicy describes how the scheduled exports will be handled.
+// Only one of the following concurrent policies may be specified.
+// If none of the following policies is specified, the default one
+// is AllowConcurrent.
+// +kubebuilder:validation:Enum=Allow;Forbid;Replace
+type ConcurrencyPolicy string
+
+const (
+	// AllowConcurrent allows exports to run concurrently.
+	AllowConcurrent ConcurrencyPolicy = "Allow"
+
+	// ForbidConcurrent forbids concurrent runs, skipping next run if previous
+	// hasn't finished yet.
+	ForbidConcurrent ConcurrencyPolicy = "Forbid"
+
+	// ReplaceConcurrent cancels currently running export and replaces it with a new one.
+	ReplaceConcurrent ConcurrencyPolicy = "Replace"
+)
+
+// ClusterImageExportScheduleSpec defines the desired state of ClusterImageExportSchedule
+type ClusterImageExportScheduleSpec struct {
+	// Schedule in the standard cron format, e.g. "0 2 * * *"
+	// +kubebuilder:validation:MinLength=1
+	Schedule string `json:"schedule"`
+	// Optional deadline in seconds for starting the export if it misses scheduled
+	// time for any reason. Missed exports will be counted as failed ones.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Optional
+	StartingDeadlineSeconds *int64 `json:"startingDeadlineSeconds,omitempty"`
+	// Specifies how to treat concurrent exports - Allow, Forbid or Replace
+	// +kubebuilder:default:=Allow
+	// +kubebuilder:validation:Optional
+	ConcurrencyPolicy ConcurrencyPolicy `json:"concurrencyPolicy,omitempty"`
+	// Suspends subsequent exports, does not apply to already started ones
+	// +kubebuilder:validation:Optional
+	Suspend *bool `json:"suspend,omitempty"`
+	// Number of successful exports to keep. Older ones are deleted together with their stored images.
+	// Planned dry runs are not counted as successful, the same number of them is kept on their own.
+	// +kubebuilder:default:=3
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Optional
+	SuccessfulExportsHistoryLimit *int32 `json:"successfulExportsHistoryLimit,omitempty"`
+	// Number of failed exports to keep. Older ones are deleted together with their stored images.
+	// +kubebuilder:default:=1
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Optional
+	FailedExportsHistoryLimit *int32 `json:"failedExportsHistoryLimit,omitempty"`
+	// Template of the ClusterImageExport created on every run
+	ExportTemplate ClusterImageExportSpec `json:"exportTemplate"`
+}
+
+// ClusterImageExportScheduleStatus defines the observed state of ClusterImageExportSchedule
+type ClusterImageExportScheduleStatus struct {
+	// Exports which are currently running
+	// +kubebuilder:validation:Optional
+	Active []corev1.ObjectReference `json:"active,omitempty"`
+	// Last time the export was successfully scheduled
+	// +kubebuilder:validation:Optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+	// Scheduled time of the most recent successful export
+	// +kubebuilder:validation:Optional
+	LastSuccessfulTime *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// ClusterImageExportSchedule is the Schema for the clusterimageexportschedules API
+// +kubebuilder:printcolumn:name="Schedule",type="string",JSONPath=".spec.schedule"
+// +kubebuilder:printcolumn:name="Suspend",type="boolean",JSONPath=".spec.suspend"
+// +kubebuilder:printcolumn:name="Last Schedule",type="date",JSONPath=".status.lastScheduleTime"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type ClusterImageExportSchedule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterImageExportScheduleSpec   `json:"spec,omitempty"`
+	Status ClusterImageExportScheduleStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterImageExportScheduleList contains a list of ClusterImageExportSchedule
+type ClusterImageExportScheduleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterImageExportSchedule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterImageExportSchedule{}, &ClusterImageExportScheduleList{})
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterImageImportDestination defines the registry the images are pushed to
+type ClusterImageImportDestination struct {
+	// Registry host, e.g. registry.internal:5000
+	// +kubebuilder:validation:MinLength=1
+	Registry string `json:"registry"`
+	// Prefix prepended to the repository of every image,
+	// e.g. with "mirror" docker.io/library/nginx is pushed as <registry>/mirror/library/nginx
+	// +kubebuilder:validation:Optional
+	RepositoryPrefix string `json:"repositoryPrefix,omitempty"`
+	// Skip the TLS verification of the registry
+	// +kubebuilder:validation:Optional
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+// ClusterImageImportSpec defines the desired state of ClusterImageImport
+type ClusterImageImportSpec struct {
+	// Name of the export to import, the manifest is read from <basePath>/<exportName>/manifest.json
+	// +kubebuilder:validation:MinLength=1
+	ExportName string `json:"exportName"`
+	// Base path of the export - both file and S3
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=255
+	BasePath    string                        `json:"basePath"`
+	Storage     ClusterImageStorageSpec       `json:"storage"`
+	Destination ClusterImageImportDestination `json:"destination"`
+	// +kubebuilder:validation:Optional
+	JobAnnotations map[string]string `json:"jobAnnotations,omitempty"`
+	// Secrets with the credentials of the destination registry
+	// +kubebuilder:validation:Optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// +kubebuilder:validation.Minimum=1
+	// +kubebuilder:validation.Maximum=100
+	MaxConcurrentJobs int `json:"maxConcurrentJobs"`
+}
+
+// ClusterImageImportImage defines the import progress of the single image, kept after its job is removed
+type ClusterImageImportImage struct {
+	FullName string `json:"fullName"`
+	// Image reference in the destination registry
+	Destination string `json:"destination"`
+	Progress    string `json:"progress,omitempty"`
+	// Name of the import job of the image
+	JobName string `json:"jobName,omitempty"`
+	// Termination message of the failed import job container, the tail of its output
+	LastError string `json:"lastError,omitempty"`
+}
+
+// ClusterImageImportStatus defines the observed state of ClusterImageImport.
+// Every image is imported by its own job, its progress is recorded in the status.
+type ClusterImageImportStatus struct {
+	Progress string `json:"progress,omitempty"`
+	// Format of the imported export, read from its manifest
+	Format string `json:"format,omitempty"`
+	// ConfigMap holding the manifest of the imported export
+	Manifest string `json:"manifest,omitempty"`
+	// Number of the images listed in the manifest
+	Total int `json:"total,omitempty"`
+	// Number of the images waiting for the import job
+	Pending int `json:"pending,omitempty"`
+	// Number of the images with the import job running
+	Running int `json:"running,omitempty"`
+	// Number of the images pushed into the destination registry
+	Succeeded int `json:"succeeded,omitempty"`
+	// Number of the images which failed to import
+	Failed int `json:"failed,omitempty"`
+	// Full names of the images which failed to import
+	FailedImages []string `json:"failedImages,omitempty"`
+	// Progress of every image listed in the manifest
+	// +listType=atomic
+	Images []ClusterImageImportImage `json:"images,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// ClusterImageImport is the Schema for the clusterimageimports API
+// +kubebuilder:printcolumn:name="Export",type="string",JSONPath=".spec.exportName"
+// +kubebuilder:printcolumn:name="Registry",type="string",JSONPath=".spec.destination.registry"
+// +kubebuilder:printcolumn:name="Progress",type="string",JSONPath=".status.progress"
+// +kubebuilder:printcolumn:name="Images",type="integer",JSONPath=".status.total"
+// +kubebuilder:printcolumn:name="Failed",type="integer",JSONPath=".status.failed",priority=1
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type ClusterImageImport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterImageImportSpec   `json:"spec,omitempty"`
+	Status ClusterImageImportStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterImageImportList contains a list of ClusterImageImport
+type ClusterImageImportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterImageImport `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterImageImport{}, &ClusterImageImportList{})
+}
@@ -38,6 +38,7 @@ import (
 	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
 	raczylocomcontroller "github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/controller/raczylo.com"
 	"github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
+	webhookraczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/webhook/raczylo.com/v1"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -59,6 +60,9 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var forbidInlineCredentials bool
+	var maxConcurrentJobs int
+	var workerImage string
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -70,6 +74,12 @@ func main() {
 		"If set, the metrics endpoint is served securely via HTTPS. Use --metrics-secure=false to use HTTP instead.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.BoolVar(&forbidInlineCredentials, "forbid-inline-credentials", false,
+		"If set, resources with the S3 access and secret keys set inline are rejected, secretName or useRole has to be used instead")
+	flag.IntVar(&maxConcurrentJobs, "max-concurrent-jobs", 0,
+		"Maximum number of the image export jobs running in the whole cluster, across all the exports. 0 means no limit")
+	flag.StringVar(&workerImage, "worker-image", "",
+		"Image of the worker running the export and import jobs. Defaults to the worker of the same version as the manager")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -78,6 +88,10 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if workerImage != "" {
+		shared.BACKUP_JOB_IMAGE = workerImage
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -151,19 +165,47 @@ func main() {
 	}
 
 	if err = (&raczylocomcontroller.ClusterImageExportReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		ForbidInlineCredentials: forbidInlineCredentials,
+		Recorder:                mgr.GetEventRecorderFor("clusterimageexport-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ClusterImageExport")
 		os.Exit(1)
 	}
 	if err = (&raczylocomcontroller.ClusterImageReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		Recorder:          mgr.GetEventRecorderFor("clusterimage-controller"),
+		MaxConcurrentJobs: maxConcurrentJobs,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ClusterImage")
 		os.Exit(1)
 	}
+	if err = (&raczylocomcontroller.ClusterImageExportScheduleReconciler{
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		ForbidInlineCredentials: forbidInlineCredentials,
+		Recorder:                mgr.GetEventRecorderFor("clusterimageexportschedule-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterImageExportSchedule")
+		os.Exit(1)
+	}
+	if err = (&raczylocomcontroller.ClusterImageImportReconciler{
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		ForbidInlineCredentials: forbidInlineCredentials,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterImageImport")
+		os.Exit(1)
+	}
+	// nolint:goconst
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = webhookraczylocomv1.SetupClusterImageExportWebhookWithManager(mgr, forbidInlineCredentials); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ClusterImageExport")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
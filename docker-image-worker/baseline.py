@@ -0,0 +1,89 @@
+#!/usr/bin/env python3
+import os
+import sys
+import json
+import argparse
+from botocore.exceptions import ClientError
+
+sys.path.append(os.path.dirname(os.path.abspath(__file__)))
+from s3_utils import get_s3_client, parse_s3_path, add_common_arguments, validate_args, write_termination_message
+from platform_utils import add_platform_arguments, selected_platforms
+
+def load_manifest(manifest, use_role=False, role_name=None, aws_access_key_id=None, aws_secret_access_key=None, endpoint_url=None, region=None):
+    """
+    Load the export manifest from either a local path or an S3 bucket
+    """
+    if manifest.startswith('s3://'):
+        s3_client = get_s3_client(use_role, role_name, aws_access_key_id, aws_secret_access_key, endpoint_url, region)
+        bucket, s3_key = parse_s3_path(manifest)
+        try:
+            response = s3_client.get_object(Bucket=bucket, Key=s3_key)
+            return json.loads(response['Body'].read())
+        except ClientError as e:
+            print(f"Error reading manifest: {str(e)}")
+            return None
+    try:
+        with open(manifest) as f:
+            return json.load(f)
+    except (IOError, ValueError) as e:
+        print(f"Error reading manifest: {str(e)}")
+        return None
+
+def find_image(manifest, full_name, sha=None, platforms=None, referrers=False):
+    """
+    Find the image stored with the same platforms in the manifest, matching the digest as well if it is known.
+    Image exported with the referrers has to be stored with them too.
+    """
+    for image in manifest.get('images', []):
+        if image.get('fullName') != full_name:
+            continue
+        if sha and image.get('sha') and image.get('sha') != sha:
+            continue
+        if sorted(image.get('platforms', [])) != (platforms or []):
+            continue
+        if not image.get('artifact'):
+            continue
+        if referrers and not image.get('referrers'):
+            continue
+        return image
+    return None
+
+if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Check if the image is already stored by the baseline export. Exits with 0 if it is, 3 otherwise.")
+    parser.add_argument("destination", help="The baseline manifest path (local) or S3 path (e.g., 's3://bucket/key')")
+    parser.add_argument("image", help="Full name of the image to look for")
+    parser.add_argument("--sha", help="Digest of the image to look for")
+    parser.add_argument("--referrers", action="store_true", help="Look for the image stored with its referrers")
+    add_platform_arguments(parser)
+    add_common_arguments(parser)
+
+    args = parser.parse_args()
+    validate_args(args, parser)
+
+    manifest = load_manifest(
+        args.destination,
+        args.use_role,
+        args.role_name,
+        args.aws_access_key_id,
+        args.aws_secret_access_key,
+        args.endpoint_url,
+        args.region
+    )
+    if manifest is None:
+        print("Baseline manifest not available, image will be exported.")
+        exit(3)
+
+    image = find_image(manifest, args.image, args.sha, selected_platforms(args), args.referrers)
+    if image is None:
+        print(f"Image {args.image} not found in the baseline, image will be exported.")
+        exit(3)
+
+    write_termination_message({
+        "present": True,
+        "artifact": image.get('artifact'),
+        "size": image.get('size', 0),
+        "sha256": image.get('sha256', ''),
+        "mediaType": image.get('mediaType', ''),
+        "referrers": image.get('referrers', []),
+    })
+    print(f"Image {args.image} already stored in {image.get('artifact')}, skipping.")
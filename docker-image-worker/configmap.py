@@ -0,0 +1,65 @@
+#!/usr/bin/env python3
+import os
+import json
+import ssl
+import argparse
+import urllib.error
+import urllib.request
+
+SERVICE_ACCOUNT_PATH = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+def read_service_account(name):
+    with open(os.path.join(SERVICE_ACCOUNT_PATH, name)) as f:
+        return f.read().strip()
+
+def store_file(source, configmap, key):
+    """
+    Store the contents of the local file under the key of the ConfigMap in the namespace of the job
+    """
+    try:
+        with open(source) as f:
+            data = f.read()
+        token = read_service_account("token")
+        namespace = read_service_account("namespace")
+    except IOError as e:
+        print(f"Error reading file: {str(e)}")
+        return False
+
+    host = os.environ.get("KUBERNETES_SERVICE_HOST", "kubernetes.default.svc")
+    if ":" in host:
+        host = f"[{host}]"
+    port = os.environ.get("KUBERNETES_SERVICE_PORT", "443")
+    request = urllib.request.Request(
+        f"https://{host}:{port}/api/v1/namespaces/{namespace}/configmaps/{configmap}",
+        data=json.dumps({"data": {key: data}}).encode(),
+        method="PATCH",
+        headers={
+            "Authorization": f"Bearer {token}",
+            "Content-Type": "application/merge-patch+json",
+        },
+    )
+    context = ssl.create_default_context(cafile=os.path.join(SERVICE_ACCOUNT_PATH, "ca.crt"))
+    try:
+        with urllib.request.urlopen(request, context=context, timeout=30):
+            print(f"File {source} stored successfully in ConfigMap {configmap}")
+    except urllib.error.HTTPError as e:
+        print(f"Error storing file in ConfigMap {configmap}: {e.code} {e.read().decode(errors='replace')}")
+        return False
+    except urllib.error.URLError as e:
+        print(f"Error storing file in ConfigMap {configmap}: {str(e.reason)}")
+        return False
+    return True
+
+if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Store the local file in the existing ConfigMap in the namespace of the job.")
+    parser.add_argument("source", help="The local file path")
+    parser.add_argument("configmap", help="Name of the ConfigMap")
+    parser.add_argument("key", help="Key of the ConfigMap to store the file under")
+
+    args = parser.parse_args()
+
+    if store_file(args.source, args.configmap, args.key):
+        print("Transfer completed successfully.")
+    else:
+        print("Transfer failed.")
+        exit(1)
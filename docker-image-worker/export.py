@@ -2,10 +2,21 @@
 import os
 import sys
 import argparse
+import hashlib
 from botocore.exceptions import ClientError
 
 sys.path.append(os.path.dirname(os.path.abspath(__file__)))
-from s3_utils import get_s3_client, parse_s3_path, add_common_arguments, validate_args
+from s3_utils import get_s3_client, parse_s3_path, add_common_arguments, validate_args, write_termination_message
+
+def file_sha256(path):
+    """
+    Calculate the sha256 checksum of the file
+    """
+    digest = hashlib.sha256()
+    with open(path, 'rb') as f:
+        for chunk in iter(lambda: f.read(1024 * 1024), b''):
+            digest.update(chunk)
+    return digest.hexdigest()
 
 def transfer_file(source, destination, use_role=False, role_name=None, aws_access_key_id=None, aws_secret_access_key=None, endpoint_url=None, region=None):
     """
@@ -59,6 +70,11 @@ if __name__ == "__main__":
     )
 
     if success:
+        write_termination_message({
+            "artifact": args.destination,
+            "size": os.path.getsize(args.source),
+            "sha256": file_sha256(args.source),
+        })
         print("Transfer completed successfully.")
     else:
         print("Transfer failed.")
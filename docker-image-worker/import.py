@@ -0,0 +1,64 @@
+#!/usr/bin/env python3
+import os
+import sys
+import argparse
+from botocore.exceptions import ClientError
+
+sys.path.append(os.path.dirname(os.path.abspath(__file__)))
+from s3_utils import get_s3_client, parse_s3_path, add_common_arguments, validate_args
+
+def fetch_file(source, destination, use_role=False, role_name=None, aws_access_key_id=None, aws_secret_access_key=None, endpoint_url=None, region=None):
+    """
+    Fetch a file from either a local source or an S3 bucket into the local destination
+    """
+    os.makedirs(os.path.dirname(destination) or '.', exist_ok=True)
+
+    if source.startswith('s3://'):
+        # Downloading from S3
+        s3_client = get_s3_client(use_role, role_name, aws_access_key_id, aws_secret_access_key, endpoint_url, region)
+        bucket, s3_key = parse_s3_path(source)
+        try:
+            s3_client.download_file(bucket, s3_key, destination)
+            print(f"File {source} downloaded successfully to {destination}")
+        except ClientError as e:
+            print(f"Error downloading file: {str(e)}")
+            return False
+    else:
+        # Copying from local source
+        if not os.path.isfile(source):
+            print(f"Error: Source file '{source}' does not exist or is not a file.")
+            return False
+        try:
+            import shutil
+            shutil.copy2(source, destination)
+            print(f"File {source} copied successfully to {destination}")
+        except IOError as e:
+            print(f"Error copying file: {str(e)}")
+            return False
+    return True
+
+if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Fetch a file from either a local source or an S3 bucket into the local destination.")
+    parser.add_argument("source", help="The source file path (local) or S3 path (e.g., 's3://bucket/key')")
+    parser.add_argument("destination", help="The local destination file path")
+    add_common_arguments(parser)
+
+    args = parser.parse_args()
+    validate_args(args, parser, args.source)
+
+    success = fetch_file(
+        args.source,
+        args.destination,
+        args.use_role,
+        args.role_name,
+        args.aws_access_key_id,
+        args.aws_secret_access_key,
+        args.endpoint_url,
+        args.region
+    )
+
+    if success:
+        print("Transfer completed successfully.")
+    else:
+        print("Transfer failed.")
+        exit(1)
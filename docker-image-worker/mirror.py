@@ -0,0 +1,99 @@
+#!/usr/bin/env python3
+import os
+import sys
+import hashlib
+import argparse
+import subprocess
+
+sys.path.append(os.path.dirname(os.path.abspath(__file__)))
+from s3_utils import write_termination_message
+from platform_utils import add_platform_arguments, copy_platforms
+
+# Tags used by cosign to attach the signatures, attestations and SBOMs to the image
+SIGSTORE_SUFFIXES = ['sig', 'att', 'sbom']
+
+def raw_manifest(reference, tls_verify=True):
+    """
+    Return the raw manifest of the image or None if it does not exist
+    """
+    result = subprocess.run(
+        ['skopeo', 'inspect', '--raw', f'--tls-verify={str(tls_verify).lower()}', f'docker://{reference}'],
+        capture_output=True
+    )
+    if result.returncode != 0:
+        return None
+    return result.stdout
+
+def copy_image(source, destination, dest_tls_verify=True):
+    """
+    Copy the image with all the platforms, keeping the digests intact
+    """
+    result = subprocess.run([
+        'skopeo', 'copy', '--all', '--preserve-digests', '--retry-times=3',
+        f'--dest-tls-verify={str(dest_tls_verify).lower()}',
+        f'docker://{source}', f'docker://{destination}'
+    ])
+    return result.returncode == 0
+
+def repository(reference):
+    """
+    Strip the tag and digest from the image reference
+    """
+    name = reference.split('@')[0]
+    if ':' in name.rsplit('/', 1)[-1]:
+        name = name.rsplit(':', 1)[0]
+    return name
+
+if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Copy the image with all its platforms and signatures, or only the selected platforms, into the destination registry.")
+    parser.add_argument("source", help="The source image reference")
+    parser.add_argument("destination", help="The destination image reference")
+    parser.add_argument("--insecure", action="store_true", help="Skip the TLS verification of the destination registry")
+    parser.add_argument("--referrer", action="append", default=[], help="Digest of the referrer found by the referrers API to copy, can be repeated")
+    add_platform_arguments(parser)
+    args = parser.parse_args()
+
+    manifest = raw_manifest(args.source)
+    if manifest is None:
+        print(f"Error: Image {args.source} not found.")
+        exit(1)
+    digest = "sha256:" + hashlib.sha256(manifest).hexdigest()
+
+    if args.platform and not args.all_platforms:
+        # Manifest list of the selected platforms gets a new digest, the signatures of the source don't apply to it
+        if not copy_platforms(args.source, f'docker://{args.destination}', args.platform, not args.insecure):
+            print(f"Error copying image {args.source} to {args.destination}.")
+            exit(1)
+        manifest = raw_manifest(args.destination, not args.insecure)
+        if manifest is None:
+            print(f"Error: Image {args.destination} not found after the copy.")
+            exit(1)
+        digest = "sha256:" + hashlib.sha256(manifest).hexdigest()
+        print(f"Copied platforms {', '.join(args.platform)} of the image {args.source}, signatures are not copied.")
+    else:
+        if not copy_image(args.source, args.destination, not args.insecure):
+            print(f"Error copying image {args.source} to {args.destination}.")
+            exit(1)
+
+        # Signatures are stored under the tags derived from the image digest
+        for suffix in SIGSTORE_SUFFIXES:
+            tag = digest.replace(':', '-') + '.' + suffix
+            source = repository(args.source) + ':' + tag
+            if raw_manifest(source) is None:
+                continue
+            if not copy_image(source, repository(args.destination) + ':' + tag, not args.insecure):
+                print(f"Error copying {suffix} of the image {args.source}.")
+                exit(1)
+            print(f"Copied {suffix} of the image {args.source}.")
+
+        # Referrers without the cosign tag get the tag derived from their digest, the registry indexes them by the subject
+        for referrer in args.referrer:
+            if not copy_image(repository(args.source) + '@' + referrer, repository(args.destination) + ':' + referrer.replace(':', '-'), not args.insecure):
+                print(f"Error copying referrer {referrer} of the image {args.source}.")
+                exit(1)
+            print(f"Copied referrer {referrer} of the image {args.source}.")
+
+    write_termination_message({
+        "artifact": repository(args.destination) + '@' + digest,
+    })
+    print(f"Image {args.source} mirrored successfully to {args.destination}.")
@@ -0,0 +1,153 @@
+#!/usr/bin/env python3
+import os
+import sys
+import json
+import shutil
+import argparse
+import subprocess
+from botocore.exceptions import ClientError
+
+sys.path.append(os.path.dirname(os.path.abspath(__file__)))
+from s3_utils import get_s3_client, parse_s3_path, add_common_arguments, validate_args, write_termination_message
+from platform_utils import add_platform_arguments, copy_platforms
+
+INDEX_MEDIA_TYPES = [
+    'application/vnd.oci.image.index.v1+json',
+    'application/vnd.docker.distribution.manifest.list.v2+json',
+]
+
+class BlobStore:
+    """
+    Content-addressed blob store of the OCI layout, either local or in an S3 bucket
+    """
+    def __init__(self, root, s3_client=None):
+        self.root = root.rstrip('/')
+        self.s3_client = s3_client
+
+    def path(self, digest):
+        algorithm, encoded = digest.split(':', 1)
+        return f"{self.root}/blobs/{algorithm}/{encoded}"
+
+    def exists(self, digest):
+        if self.s3_client:
+            bucket, key = parse_s3_path(self.path(digest))
+            try:
+                self.s3_client.head_object(Bucket=bucket, Key=key)
+                return True
+            except ClientError:
+                return False
+        return os.path.isfile(self.path(digest))
+
+    def put(self, digest, source):
+        if self.s3_client:
+            bucket, key = parse_s3_path(self.path(digest))
+            self.s3_client.upload_file(source, bucket, key)
+        else:
+            os.makedirs(os.path.dirname(self.path(digest)), exist_ok=True)
+            shutil.copy2(source, self.path(digest))
+
+    def get(self, digest, destination):
+        os.makedirs(os.path.dirname(destination), exist_ok=True)
+        if self.s3_client:
+            bucket, key = parse_s3_path(self.path(digest))
+            self.s3_client.download_file(bucket, key, destination)
+        else:
+            shutil.copy2(self.path(digest), destination)
+
+def push(source, store, workdir, platforms=None, all_platforms=False):
+    """
+    Copy the image into the local layout and upload the blobs missing in the store.
+    Without platforms only the image of the node platform is copied, all copies the whole manifest list.
+    """
+    if platforms and not all_platforms:
+        copied = copy_platforms(source, f'oci:{workdir}:image', platforms)
+    else:
+        command = ['skopeo', 'copy', '--retry-times=3']
+        if all_platforms:
+            command += ['--all', '--preserve-digests']
+        copied = subprocess.run(command + [f'docker://{source}', f'oci:{workdir}:image']).returncode == 0
+    if not copied:
+        print(f"Error copying image {source}.")
+        return None
+
+    with open(os.path.join(workdir, 'index.json')) as f:
+        descriptor = json.load(f)['manifests'][0]
+
+    uploaded, skipped = 0, 0
+    blobs = os.path.join(workdir, 'blobs')
+    for algorithm in os.listdir(blobs):
+        for encoded in os.listdir(os.path.join(blobs, algorithm)):
+            digest = f"{algorithm}:{encoded}"
+            if store.exists(digest):
+                skipped += 1
+                continue
+            store.put(digest, os.path.join(blobs, algorithm, encoded))
+            uploaded += 1
+    print(f"Image {source} stored: {uploaded} blobs uploaded, {skipped} already present.")
+    return descriptor
+
+def fetch(store, digest, workdir):
+    """
+    Download the manifest with all the blobs it references into the local layout
+    """
+    def fetch_blob(blob_digest):
+        destination = os.path.join(workdir, 'blobs', *blob_digest.split(':', 1))
+        if not os.path.isfile(destination):
+            store.get(blob_digest, destination)
+        return destination
+
+    def fetch_manifest(manifest_digest):
+        with open(fetch_blob(manifest_digest)) as f:
+            manifest = json.load(f)
+        if manifest.get('mediaType') in INDEX_MEDIA_TYPES or 'manifests' in manifest:
+            for child in manifest.get('manifests', []):
+                fetch_manifest(child['digest'])
+        else:
+            fetch_blob(manifest['config']['digest'])
+            for layer in manifest.get('layers', []):
+                fetch_blob(layer['digest'])
+        return manifest
+
+    manifest = fetch_manifest(digest)
+    descriptor = {
+        'mediaType': manifest.get('mediaType', 'application/vnd.oci.image.manifest.v1+json'),
+        'digest': digest,
+        'size': os.path.getsize(fetch_blob(digest)),
+    }
+    with open(os.path.join(workdir, 'index.json'), 'w') as f:
+        json.dump({'schemaVersion': 2, 'manifests': [descriptor]}, f)
+    with open(os.path.join(workdir, 'oci-layout'), 'w') as f:
+        json.dump({'imageLayoutVersion': '1.0.0'}, f)
+    print(f"Image {digest} fetched into {workdir}.")
+
+if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Store images in, or fetch them from the OCI layout shared by all the images of the export.")
+    parser.add_argument("action", choices=["push", "fetch"], help="push the image into the layout or fetch it from there")
+    parser.add_argument("layout", help="The root of the OCI layout (local) or S3 path (e.g., 's3://bucket/prefix')")
+    parser.add_argument("reference", help="Image to push or the manifest digest to fetch")
+    parser.add_argument("--workdir", default="/tmp/oci-layout", help="Local directory of the layout")
+    add_platform_arguments(parser)
+    add_common_arguments(parser)
+
+    args = parser.parse_args()
+    validate_args(args, parser, args.layout)
+
+    s3_client = None
+    if args.layout.startswith('s3://'):
+        s3_client = get_s3_client(args.use_role, args.role_name, args.aws_access_key_id, args.aws_secret_access_key, args.endpoint_url, args.region)
+    store = BlobStore(args.layout, s3_client)
+
+    if args.action == "fetch":
+        fetch(store, args.reference, args.workdir)
+        exit(0)
+
+    descriptor = push(args.reference, store, args.workdir, args.platform, args.all_platforms)
+    if descriptor is None:
+        exit(1)
+    write_termination_message({
+        "artifact": store.path(descriptor['digest']),
+        "size": descriptor['size'],
+        "sha256": descriptor['digest'].split(':', 1)[1],
+        "mediaType": descriptor['mediaType'],
+    })
+    shutil.rmtree(args.workdir, ignore_errors=True)
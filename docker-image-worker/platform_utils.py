@@ -0,0 +1,45 @@
+import subprocess
+
+def add_platform_arguments(parser):
+    """
+    Add the arguments selecting the platforms of multi-platform images
+    """
+    parser.add_argument("--platform", action="append", default=[], help="Platform of the image to copy as os/arch[/variant], can be repeated")
+    parser.add_argument("--all-platforms", action="store_true", help="Copy all the platforms of the image")
+
+def selected_platforms(args):
+    """
+    Return the platforms the same way the export manifest lists them
+    """
+    if args.all_platforms:
+        return ['all']
+    return sorted(args.platform)
+
+def platform_flags(platform):
+    """
+    Return the podman manifest add flags selecting the os/arch[/variant] platform
+    """
+    parts = platform.split('/')
+    flags = ['--os', parts[0], '--arch', parts[1]]
+    if len(parts) == 3:
+        flags += ['--variant', parts[2]]
+    return flags
+
+def copy_platforms(source, destination, platforms, tls_verify=True):
+    """
+    Copy the selected platforms of the image as a new manifest list, the destination includes the transport.
+    The manifest list is rebuilt from the selected images, so its digest differs from the source one.
+    """
+    name = 'platforms-' + destination.replace('/', '-').replace(':', '-')
+    subprocess.run(['podman', 'manifest', 'rm', name], capture_output=True)
+    if subprocess.run(['podman', 'manifest', 'create', name]).returncode != 0:
+        return False
+    for platform in platforms:
+        result = subprocess.run(['podman', 'manifest', 'add'] + platform_flags(platform) + [name, f'docker://{source}'])
+        if result.returncode != 0:
+            print(f"Error adding platform {platform} of the image {source}.")
+            return False
+    result = subprocess.run([
+        'podman', 'manifest', 'push', '--all', '--quiet', f'--tls-verify={str(tls_verify).lower()}', name, destination
+    ])
+    return result.returncode == 0
@@ -1,3 +1,5 @@
+import os
+import json
 import boto3
 from botocore.exceptions import ClientError
 
@@ -47,16 +49,16 @@ def add_common_arguments(parser):
     """
     parser.add_argument("--use_role", action="store_true", help="Use IAM role for authentication")
     parser.add_argument("--role_name", help="The name of the IAM role to assume")
-    parser.add_argument("--aws_access_key_id", help="AWS access key ID")
-    parser.add_argument("--aws_secret_access_key", help="AWS secret access key")
+    parser.add_argument("--aws_access_key_id", default=os.environ.get("AWS_ACCESS_KEY_ID"), help="AWS access key ID, defaults to AWS_ACCESS_KEY_ID env")
+    parser.add_argument("--aws_secret_access_key", default=os.environ.get("AWS_SECRET_ACCESS_KEY"), help="AWS secret access key, defaults to AWS_SECRET_ACCESS_KEY env")
     parser.add_argument("--endpoint_url", help="S3-compatible endpoint URL")
     parser.add_argument("--region", help="AWS region (ignored if endpoint_url is specified)")
 
-def validate_args(args, parser):
+def validate_args(args, parser, path=None):
     """
-    Validate command-line arguments
+    Validate command-line arguments, the S3 path defaults to the destination
     """
-    if args.destination.startswith('s3://'):
+    if (path or args.destination).startswith('s3://'):
         if args.use_role and (args.aws_access_key_id or args.aws_secret_access_key or args.endpoint_url):
             parser.error("When using IAM role (--use_role), access key, secret, and endpoint URL should not be specified.")
 
@@ -67,4 +69,14 @@ def validate_args(args, parser):
             parser.error("Either --use_role or both --aws_access_key_id and --aws_secret_access_key must be provided for S3 operations.")
 
         if args.use_role and args.role_name and (args.aws_access_key_id or args.aws_secret_access_key):
-            parser.error("When using a specific role (--role_name), access key and secret should not be specified.")
\ No newline at end of file
+            parser.error("When using a specific role (--role_name), access key and secret should not be specified.")
+
+def write_termination_message(result, path='/dev/termination-log'):
+    """
+    Write the job result where the operator picks it up from the container status
+    """
+    try:
+        with open(path, 'w') as f:
+            json.dump(result, f)
+    except IOError as e:
+        print(f"Error writing termination message: {str(e)}")
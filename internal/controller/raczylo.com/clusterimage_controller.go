@@ -2,6 +2,7 @@ package raczylocom
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -15,10 +16,16 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
 	"github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
@@ -26,10 +33,12 @@ import (
 
 type ClusterImageReconciler struct {
 	client.Client
-	Scheme          *runtime.Scheme
-	MaxParallelJobs int
-	ActiveJobs      int
-	KubeClient      *kubernetes.Clientset
+	Scheme     *runtime.Scheme
+	KubeClient *kubernetes.Clientset
+	Recorder   record.EventRecorder
+	// Limit of the export jobs running in the whole cluster, 0 means no limit
+	MaxConcurrentJobs int
+	jobs              jobQueue
 }
 
 // +kubebuilder:rbac:groups=raczylo.com,resources=*,verbs=get;list;watch;create;update;patch;delete
@@ -40,6 +49,7 @@ type ClusterImageReconciler struct {
 // +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
 // add access to secrets
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 func (r *ClusterImageReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	l := log.FromContext(ctx)
 
@@ -58,8 +68,6 @@ func (r *ClusterImageReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		return ctrl.Result{}, err
 	}
 
-	r.MaxParallelJobs = clusterImageExport.Spec.MaxConcurrentJobs
-
 	// If the ClusterImage is new, set its status to PENDING
 	if clusterImage.Status.Progress == "" {
 		clusterImage.Status.Progress = shared.STATUS_PENDING
@@ -70,9 +78,21 @@ func (r *ClusterImageReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		return ctrl.Result{Requeue: true}, nil
 	}
 
-	// If we've reached the maximum number of parallel jobs, requeue
-	if r.ActiveJobs >= r.MaxParallelJobs && clusterImage.Status.Progress == shared.STATUS_PENDING {
-		return ctrl.Result{RequeueAfter: time.Second * 30}, nil
+	// Images of the failed export are not started
+	if clusterImage.Status.Progress == shared.STATUS_PENDING && clusterImageExport.Status.Progress == shared.STATUS_FAILED {
+		return ctrl.Result{}, nil
+	}
+
+	// Wait until the image gets a free job slot of its export and of the whole cluster
+	if clusterImage.Status.Progress == shared.STATUS_PENDING {
+		admitted, err := r.jobs.admit(ctx, r.Client, clusterImage, r.MaxConcurrentJobs)
+		if err != nil {
+			l.Error(err, "unable to count the running jobs")
+			return ctrl.Result{}, err
+		}
+		if !admitted {
+			return ctrl.Result{RequeueAfter: time.Second * 30}, nil
+		}
 	}
 
 	// Process the ClusterImage based on its current status
@@ -80,7 +100,7 @@ func (r *ClusterImageReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	case shared.STATUS_PENDING:
 		return r.handlePendingClusterImage(ctx, clusterImage, l)
 	case shared.STATUS_RUNNING, shared.STATUS_RETRYING:
-		return r.handleRunningClusterImage(ctx, clusterImage, l)
+		return r.handleRunningClusterImage(ctx, clusterImage, clusterImageExport, l)
 	case shared.STATUS_SUCCESS, shared.STATUS_FAILED, shared.STATUS_PRESENT:
 		return ctrl.Result{}, nil // No further action needed
 	default:
@@ -102,6 +122,7 @@ func (r *ClusterImageReconciler) handlePendingClusterImage(ctx context.Context,
 			l.Error(err, "unable to update ClusterImage status")
 			return ctrl.Result{}, err
 		}
+		r.Recorder.Event(clusterImage, v1.EventTypeNormal, shared.EVENT_PRESENT, "Image is already exported by another ClusterImage, skipped")
 		return ctrl.Result{}, nil
 	}
 
@@ -114,6 +135,17 @@ func (r *ClusterImageReconciler) handlePendingClusterImage(ctx context.Context,
 
 	// Create the backup job
 	if err := r.createBackupJob(ctx, clusterImage, clusterImageExport, l); err != nil {
+		if _, ok := err.(invalidImageSpecError); ok {
+			// Job can't be built for the image, waiting in PENDING wouldn't change that
+			clusterImage.Status.Progress = shared.STATUS_FAILED
+			clusterImage.Status.LastError = err.Error()
+			if err := r.Status().Update(ctx, clusterImage); err != nil {
+				l.Error(err, "unable to update ClusterImage status to FAILED")
+				return ctrl.Result{}, err
+			}
+			r.Recorder.Eventf(clusterImage, v1.EventTypeWarning, shared.EVENT_FAILED, "Unable to export the image: %s", err)
+			return ctrl.Result{}, nil
+		}
 		l.Error(err, "unable to create backup job")
 		return ctrl.Result{}, err
 	}
@@ -125,13 +157,10 @@ func (r *ClusterImageReconciler) handlePendingClusterImage(ctx context.Context,
 		return ctrl.Result{}, err
 	}
 
-	// Increment the active jobs count
-	r.ActiveJobs++
-
 	return ctrl.Result{Requeue: true}, nil
 }
 
-func (r *ClusterImageReconciler) handleRunningClusterImage(ctx context.Context, clusterImage *raczylocomv1.ClusterImage, l logr.Logger) (ctrl.Result, error) {
+func (r *ClusterImageReconciler) handleRunningClusterImage(ctx context.Context, clusterImage *raczylocomv1.ClusterImage, clusterImageExport *raczylocomv1.ClusterImageExport, l logr.Logger) (ctrl.Result, error) {
 	// Check for existing job for this ClusterImage
 	existingJob := &v1batch.Job{}
 	jobName := fmt.Sprintf("img-export-%s", clusterImage.Name)
@@ -139,8 +168,15 @@ func (r *ClusterImageReconciler) handleRunningClusterImage(ctx context.Context,
 
 	if err != nil {
 		if errors.IsNotFound(err) {
+			// Failed attempt waits for its backoff before the image is queued again
+			if nextRetryTime := clusterImage.Status.NextRetryTime; clusterImage.Status.Progress == shared.STATUS_RETRYING && nextRetryTime != nil {
+				if wait := time.Until(nextRetryTime.Time); wait > 0 {
+					return ctrl.Result{RequeueAfter: wait}, nil
+				}
+			}
 			// Job doesn't exist, set status back to PENDING
 			clusterImage.Status.Progress = shared.STATUS_PENDING
+			clusterImage.Status.NextRetryTime = nil
 			if err := r.Status().Update(ctx, clusterImage); err != nil {
 				l.Error(err, "unable to update ClusterImage status back to PENDING")
 				return ctrl.Result{}, err
@@ -154,39 +190,68 @@ func (r *ClusterImageReconciler) handleRunningClusterImage(ctx context.Context,
 	// Check job status and update ClusterImage accordingly
 	if existingJob.Status.Succeeded > 0 {
 		clusterImage.Status.Progress = shared.STATUS_SUCCESS
-		r.ActiveJobs--
+		result, err := r.getJobResult(ctx, existingJob)
+		if err != nil {
+			l.Error(err, "unable to read the job result")
+		}
+		if result != nil {
+			if result.Present {
+				clusterImage.Status.Progress = shared.STATUS_PRESENT
+				clusterImage.Status.Referrers = result.Referrers
+			}
+			if result.Artifact != "" {
+				clusterImage.Status.Artifact = result.Artifact
+			}
+			clusterImage.Status.Size = result.Size
+			clusterImage.Status.Sha256 = result.Sha256
+			clusterImage.Status.MediaType = result.MediaType
+		}
+		recordAttempt(&clusterImage.Status, clusterImage.Status.Progress, 0, "")
+		recordUploadedBytes(clusterImage)
 		// Update the status before cleaning up the job
 		if err := r.Status().Update(ctx, clusterImage); err != nil {
 			l.Error(err, "unable to update ClusterImage status to SUCCESS")
 			return ctrl.Result{}, err
 		}
+		if clusterImage.Status.Progress == shared.STATUS_PRESENT {
+			r.Recorder.Eventf(clusterImage, v1.EventTypeNormal, shared.EVENT_PRESENT, "Image is already stored by the baseline export in %s", clusterImage.Status.Artifact)
+		} else {
+			r.Recorder.Eventf(clusterImage, v1.EventTypeNormal, shared.EVENT_EXPORTED, "Image exported to %s", clusterImage.Status.Artifact)
+		}
 		if err := r.cleanupJobAndPods(ctx, existingJob); err != nil {
 			l.Error(err, "unable to cleanup job and pods")
 			return ctrl.Result{}, err
 		}
 	} else if existingJob.Status.Failed > 0 {
-		if clusterImage.Status.RetryCount < 3 {
+		if err := r.recordJobFailure(ctx, existingJob, clusterImage); err != nil {
+			l.Error(err, "unable to read the job failure")
+		}
+		backoff, retry := shared.RetryBackoff(clusterImageExport.Spec.RetryPolicy, clusterImage.Status.FailureClass, clusterImage.Status.RetryCount)
+		if retry {
+			nextRetryTime := metav1.NewTime(time.Now().Add(backoff))
 			clusterImage.Status.Progress = shared.STATUS_RETRYING
 			clusterImage.Status.RetryCount++
+			clusterImage.Status.NextRetryTime = &nextRetryTime
+			jobRetries.WithLabelValues(clusterImage.Namespace).Inc()
 			// Update the status before cleaning up the job
 			if err := r.Status().Update(ctx, clusterImage); err != nil {
 				l.Error(err, "unable to update ClusterImage status for retry")
 				return ctrl.Result{}, err
 			}
+			r.failureEvent(clusterImage)
 			if err := r.cleanupJobAndPods(ctx, existingJob); err != nil {
 				l.Error(err, "unable to cleanup failed job and pods for retry")
 				return ctrl.Result{}, err
 			}
-			r.ActiveJobs--
-			return ctrl.Result{Requeue: true}, nil
+			return ctrl.Result{RequeueAfter: backoff}, nil
 		} else {
 			clusterImage.Status.Progress = shared.STATUS_FAILED
-			r.ActiveJobs--
 			// Update the status before cleaning up the job
 			if err := r.Status().Update(ctx, clusterImage); err != nil {
 				l.Error(err, "unable to update ClusterImage status to FAILED")
 				return ctrl.Result{}, err
 			}
+			r.failureEvent(clusterImage)
 			if err := r.cleanupJobAndPods(ctx, existingJob); err != nil {
 				l.Error(err, "unable to cleanup failed job and pods")
 				return ctrl.Result{}, err
@@ -194,19 +259,124 @@ func (r *ClusterImageReconciler) handleRunningClusterImage(ctx context.Context,
 		}
 	}
 
-	if err := r.handleJobRestarts(ctx, existingJob, clusterImage); err != nil {
-		l.Error(err, "unable to handle job restarts")
-		return ctrl.Result{}, err
-	}
-
 	// Update ClusterImage status
 	if err := r.Status().Update(ctx, clusterImage); err != nil {
 		l.Error(err, "unable to update ClusterImage status")
 		return ctrl.Result{}, err
 	}
 
-	return r.updateClusterImageExportStatus(ctx, clusterImage)
+	// Export controller owns the ClusterImages, the status update enqueues it to count the images and finish the export
+	return ctrl.Result{}, nil
+}
+
+// recordJobFailure stores the exit code and the termination message of the failed container with the attempt
+func (r *ClusterImageReconciler) recordJobFailure(ctx context.Context, job *v1batch.Job, clusterImage *raczylocomv1.ClusterImage) error {
+	podList := &v1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(job.Namespace), client.MatchingLabels(job.Spec.Selector.MatchLabels)); err != nil {
+		return err
+	}
+	exitCode, message := jobFailure(podList.Items)
+	recordAttempt(&clusterImage.Status, shared.STATUS_FAILED, exitCode, message)
+	return nil
+}
+
+// jobFailure returns the exit code and the termination message of the last failed container of the job pods
+func jobFailure(pods []v1.Pod) (int32, string) {
+	var failure *v1.ContainerStateTerminated
+	for _, pod := range pods {
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			// Restarted containers keep the failure in the last state
+			for _, terminated := range []*v1.ContainerStateTerminated{containerStatus.LastTerminationState.Terminated, containerStatus.State.Terminated} {
+				if terminated == nil || terminated.ExitCode == 0 {
+					continue
+				}
+				if failure == nil || failure.FinishedAt.Before(&terminated.FinishedAt) {
+					failure = terminated
+				}
+			}
+		}
+	}
+	if failure == nil {
+		return 0, ""
+	}
+	message := strings.TrimSpace(failure.Message)
+	if message == "" {
+		message = failure.Reason
+	}
+	return failure.ExitCode, message
+}
+
+// failureEvent reports the failed job, the image is either retried or failed for good
+func (r *ClusterImageReconciler) failureEvent(clusterImage *raczylocomv1.ClusterImage) {
+	status := clusterImage.Status
+	if status.Progress == shared.STATUS_FAILED {
+		r.Recorder.Eventf(clusterImage, v1.EventTypeWarning, shared.EVENT_FAILED, "Job %s failed with exit code %d (%s) after %d retries: %s",
+			status.JobName, status.ExitCode, status.FailureClass, status.RetryCount, lastLine(status.LastError))
+		return
+	}
+	var backoff time.Duration
+	if status.NextRetryTime != nil {
+		backoff = time.Until(status.NextRetryTime.Time).Round(time.Second)
+	}
+	r.Recorder.Eventf(clusterImage, v1.EventTypeWarning, shared.EVENT_RETRYING, "Job %s failed with exit code %d (%s), retry %d in %s: %s",
+		status.JobName, status.ExitCode, status.FailureClass, status.RetryCount, backoff, lastLine(status.LastError))
+}
+
+// lastLine returns the last line of the termination message, usually the error itself
+func lastLine(message string) string {
+	message = strings.TrimSpace(message)
+	return message[strings.LastIndex(message, "\n")+1:]
 }
+
+// recordAttempt finishes the current attempt of the export job and appends it to the bounded history
+func recordAttempt(status *raczylocomv1.ClusterImageStatus, result string, exitCode int32, message string) {
+	completionTime := metav1.Now()
+	status.CompletionTime = &completionTime
+	failureClass := ""
+	if result == shared.STATUS_FAILED {
+		failureClass = shared.ClassifyFailure(message)
+		status.ExitCode = exitCode
+		status.LastError = message
+		status.FailureClass = failureClass
+	}
+	status.Attempts = append(status.Attempts, raczylocomv1.ClusterImageAttempt{
+		JobName:        status.JobName,
+		StartTime:      status.StartTime,
+		CompletionTime: &completionTime,
+		Result:         result,
+		ExitCode:       exitCode,
+		Message:        message,
+		FailureClass:   failureClass,
+	})
+	if len(status.Attempts) > shared.MAX_ATTEMPTS_HISTORY {
+		status.Attempts = status.Attempts[len(status.Attempts)-shared.MAX_ATTEMPTS_HISTORY:]
+	}
+}
+
+// getJobResult reads the result reported by the worker in the termination message of the succeeded container
+func (r *ClusterImageReconciler) getJobResult(ctx context.Context, job *v1batch.Job) (*shared.JobResult, error) {
+	podList := &v1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(job.Namespace), client.MatchingLabels(job.Spec.Selector.MatchLabels)); err != nil {
+		return nil, err
+	}
+
+	for _, pod := range podList.Items {
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			terminated := containerStatus.State.Terminated
+			if terminated == nil || terminated.ExitCode != 0 || terminated.Message == "" {
+				continue
+			}
+			result := &shared.JobResult{}
+			if err := json.Unmarshal([]byte(terminated.Message), result); err != nil {
+				return nil, fmt.Errorf("invalid job result %q: %w", terminated.Message, err)
+			}
+			return result, nil
+		}
+	}
+
+	return nil, nil
+}
+
 func (r *ClusterImageReconciler) cleanupJobAndPods(ctx context.Context, job *v1batch.Job) error {
 	// Add a short delay to allow status updates to propagate
 	time.Sleep(2 * time.Second)
@@ -231,36 +401,208 @@ func (r *ClusterImageReconciler) cleanupJobAndPods(ctx context.Context, job *v1b
 	return nil
 }
 
+// baselineCommand returns the command of the worker checking whether the image is stored by the
+// baseline export, it exits with 0 when it is
+func baselineCommand(clusterImage *raczylocomv1.ClusterImage, storage raczylocomv1.ClusterImageStorageSpec) string {
+	baselineManifest := shared.StorageLocation(storage, clusterImage.Spec.ExportPath, clusterImage.Spec.Baseline) + "/" + shared.MANIFEST_FILE
+	command := "./baseline.py "
+	if clusterImage.Spec.Storage == shared.STORAGE_S3 {
+		command += strings.Join(shared.SetupS3Params(storage.S3), " ") + " "
+	}
+	command += "'" + baselineManifest + "' '" + clusterImage.Spec.FullName + "'"
+	if clusterImage.Spec.Sha != "" {
+		command += " --sha='" + clusterImage.Spec.Sha + "'"
+	}
+	if clusterImage.Spec.Referrers {
+		command += " --referrers"
+	}
+	return command + shared.PlatformArgs(clusterImage.Spec.Platforms)
+}
+
+// invalidImageSpecError is returned for the image the export job can't be built for, retrying doesn't help
+type invalidImageSpecError struct {
+	error
+}
+
 func (r *ClusterImageReconciler) createBackupJob(ctx context.Context, clusterImage *raczylocomv1.ClusterImage, clusterImageExport *raczylocomv1.ClusterImageExport, l logr.Logger) error {
-	normalisedImageName := shared.NormalizeImageName(clusterImage.Spec.FullName)
+	if clusterImage.Spec.Storage == shared.STORAGE_REGISTRY {
+		return r.createMirrorJob(ctx, clusterImage, clusterImageExport)
+	}
+
+	normalisedImageName := shared.ArtifactName(clusterImage.Spec.FullName, clusterImage.Spec.Sha)
+	artifact := shared.StorageLocation(clusterImageExport.Spec.Storage, clusterImage.Spec.ExportPath, clusterImage.Spec.ExportName) + "/" + normalisedImageName + ".tar"
+
+	defaultCommands := []string{}
+
+	if clusterImage.Spec.Baseline != "" {
+		// Finish early when the image is already stored by the baseline export
+		defaultCommands = append(defaultCommands, "if "+baselineCommand(clusterImage, clusterImageExport.Spec.Storage)+"; then exit 0; fi")
+	}
+
+	if clusterImage.Spec.Referrers {
+		referrers := r.imageReferrers(ctx, clusterImage)
+		exportParams := ""
+		if clusterImage.Spec.Storage == shared.STORAGE_S3 {
+			exportParams = strings.Join(shared.SetupS3Params(clusterImageExport.Spec.Storage.S3), " ") + " "
+		}
+		referrersDir := shared.StorageLocation(clusterImageExport.Spec.Storage, clusterImage.Spec.ExportPath, clusterImage.Spec.ExportName) + "/" + normalisedImageName + shared.REFERRERS_DIR_SUFFIX
+		defaultCommands = append(defaultCommands, referrerCommands(referrers, clusterImage.Spec.Image, referrersDir, exportParams)...)
+		clusterImage.Status.Referrers = referrers
+	}
+
+	pullReference := shared.PullReference(shared.Container{Image: clusterImage.Spec.Image, Sha: clusterImage.Spec.Sha, FullName: clusterImage.Spec.FullName})
 
-	defaultCommands := []string{
-		"podman pull " + clusterImage.Spec.FullName,
-		"podman save --quiet -o /tmp/" + normalisedImageName + ".tar " + clusterImage.Spec.FullName,
+	if clusterImageExport.Spec.Format == shared.FORMAT_OCI_LAYOUT {
+		// Blobs are stored in the layout shared by the whole export, the artifact is updated with the manifest blob once done
+		layoutRoot := shared.StorageLocation(clusterImageExport.Spec.Storage, clusterImage.Spec.ExportPath, clusterImage.Spec.ExportName) + "/" + shared.OCI_LAYOUT_DIR
+		layoutCommand := "./oci_layout.py push "
+		if clusterImage.Spec.Storage == shared.STORAGE_S3 {
+			layoutCommand += strings.Join(shared.SetupS3Params(clusterImageExport.Spec.Storage.S3), " ") + " "
+		}
+		layoutCommand += "'" + layoutRoot + "' '" + pullReference + "'" + shared.PlatformArgs(clusterImage.Spec.Platforms)
+		defaultCommands = append(defaultCommands, layoutCommand)
+		return r.startExportJob(ctx, clusterImage, shared.JobParams{Commands: defaultCommands, EnvVars: shared.StorageEnvVars(clusterImageExport.Spec.Storage), Storage: &clusterImageExport.Spec.Storage}, layoutRoot)
+	}
+
+	commands, err := archiveCommands(pullReference, clusterImage.Spec.FullName, "/tmp/"+normalisedImageName+".tar", clusterImageExport.Spec.Format, clusterImage.Spec.Platforms, clusterImage.Spec.Referrers)
+	if err != nil {
+		return invalidImageSpecError{err}
 	}
+	defaultCommands = append(defaultCommands, commands...)
 
 	if clusterImage.Spec.Storage == shared.STORAGE_S3 {
 		s3Params := shared.SetupS3Params(clusterImageExport.Spec.Storage.S3)
 		additionalCommands := []string{
-			"./export.py " + strings.Join(s3Params, " ") + " '/tmp/" + normalisedImageName + ".tar' " + "'s3://" + clusterImageExport.Spec.Storage.S3.Bucket + clusterImage.Spec.ExportPath + "/" + clusterImage.Spec.ExportName + "/" + normalisedImageName + ".tar'",
+			"./export.py " + strings.Join(s3Params, " ") + " '/tmp/" + normalisedImageName + ".tar' " + "'" + artifact + "'",
 		}
 		defaultCommands = append(defaultCommands, additionalCommands...)
 	} else if clusterImage.Spec.Storage == shared.STORAGE_FILE {
 		additionalCommands := []string{
-			"./export.py /tmp/" + normalisedImageName + ".tar" + " " + clusterImage.Spec.ExportPath + "/" + clusterImage.Spec.ExportName + "/" + normalisedImageName + ".tar",
+			"./export.py '/tmp/" + normalisedImageName + ".tar' '" + artifact + "'",
 		}
 		defaultCommands = append(defaultCommands, additionalCommands...)
 	}
 	defaultCommands = append(defaultCommands, "rm -f /tmp/"+normalisedImageName+".tar")
 
+	return r.startExportJob(ctx, clusterImage, shared.JobParams{Commands: defaultCommands, EnvVars: shared.StorageEnvVars(clusterImageExport.Spec.Storage), Storage: &clusterImageExport.Spec.Storage}, artifact)
+}
+
+// archiveCommands pulls the image and saves it into the archive. Single platform is pulled and saved as the image,
+// more platforms are collected into the local manifest list, pushed into the archive with all its images.
+// Image with the referrers is copied as is, the referrers are valid only for its original digest.
+func archiveCommands(pullReference string, fullName string, archive string, format string, platforms []string, preserveDigests bool) ([]string, error) {
+	if preserveDigests {
+		if format != shared.FORMAT_OCI_ARCHIVE || !shared.AllPlatforms(platforms) {
+			return nil, fmt.Errorf("image keeps its digest only in the %s format with %s platforms", shared.FORMAT_OCI_ARCHIVE, shared.PLATFORM_ALL)
+		}
+		return []string{"skopeo copy --all --preserve-digests --retry-times=3 docker://" + pullReference + " " + shared.FORMAT_OCI_ARCHIVE + ":" + archive + ":" + fullName}, nil
+	}
+	if shared.MultiPlatform(platforms) {
+		if format != shared.FORMAT_OCI_ARCHIVE {
+			return nil, fmt.Errorf("%s format holds a single platform, use %s to export more", format, shared.FORMAT_OCI_ARCHIVE)
+		}
+		commands := []string{"podman manifest create " + fullName}
+		if shared.AllPlatforms(platforms) {
+			commands = append(commands, "podman manifest add --all "+fullName+" docker://"+pullReference)
+		} else {
+			for _, platform := range platforms {
+				parsed, err := shared.ParsePlatform(platform)
+				if err != nil {
+					return nil, err
+				}
+				addCommand := "podman manifest add --os " + parsed.OS + " --arch " + parsed.Architecture
+				if parsed.Variant != "" {
+					addCommand += " --variant " + parsed.Variant
+				}
+				commands = append(commands, addCommand+" "+fullName+" docker://"+pullReference)
+			}
+		}
+		// Archive keeps the original tag, the images themselves are pinned to the resolved digest
+		return append(commands, "podman manifest push --all --quiet "+fullName+" "+shared.FORMAT_OCI_ARCHIVE+":"+archive+":"+fullName), nil
+	}
+
+	pullCommand := "podman pull "
+	if len(platforms) == 1 {
+		pullCommand += "--platform " + platforms[0] + " "
+	}
+	commands := []string{pullCommand + pullReference}
+	if pullReference != fullName {
+		// Keep the original tag in the archive, the image itself is pinned to the resolved digest
+		commands = append(commands, "podman tag "+pullReference+" "+fullName)
+	}
+	saveFormat := ""
+	if format == shared.FORMAT_OCI_ARCHIVE {
+		saveFormat = "--format " + shared.FORMAT_OCI_ARCHIVE + " "
+	}
+	return append(commands, "podman save --quiet "+saveFormat+"-o "+archive+" "+fullName), nil
+}
+
+// createMirrorJob copies the image directly into the registry of the registry storage target
+func (r *ClusterImageReconciler) createMirrorJob(ctx context.Context, clusterImage *raczylocomv1.ClusterImage, clusterImageExport *raczylocomv1.ClusterImageExport) error {
+	registry := clusterImageExport.Spec.Storage.Registry
+	container := shared.Container{
+		Image:          clusterImage.Spec.Image,
+		Tag:            clusterImage.Spec.Tag,
+		Sha:            clusterImage.Spec.Sha,
+		FullName:       clusterImage.Spec.FullName,
+		ImageNamespace: clusterImage.Spec.ImageNamespace,
+	}
+	destination, err := shared.MirrorDestination(registry, container, clusterImage.Spec.ExportName)
+	if err != nil {
+		return err
+	}
+
+	mirrorCommand := "./mirror.py "
+	if registry.Insecure {
+		mirrorCommand += "--insecure "
+	}
+	mirrorCommand += "'" + shared.PullReference(container) + "' '" + destination + "'" + shared.PlatformArgs(clusterImage.Spec.Platforms)
+	if clusterImage.Spec.Referrers {
+		referrers := r.imageReferrers(ctx, clusterImage)
+		mirrorCommand += mirrorReferrerArgs(referrers, destination)
+		clusterImage.Status.Referrers = referrers
+	}
+
+	jobParams := shared.JobParams{Commands: []string{mirrorCommand}}
+	if registry.SecretName != "" {
+		// Mounted next to the image pull secrets, so the worker logs into the destination registry as well
+		jobParams.Volumes = []v1.Volume{
+			{
+				Name: "registry-secret",
+				VolumeSource: v1.VolumeSource{
+					Secret: &v1.SecretVolumeSource{SecretName: registry.SecretName},
+				},
+			},
+		}
+		jobParams.VolumeMounts = []v1.VolumeMount{
+			{
+				Name:      "registry-secret",
+				MountPath: "/home/runner/.docker-secret-registry",
+				ReadOnly:  true,
+			},
+		}
+	}
+
+	return r.startExportJob(ctx, clusterImage, jobParams, "docker://"+destination)
+}
+
+// startExportJob creates the job running the commands and marks the image as RUNNING
+func (r *ClusterImageReconciler) startExportJob(ctx context.Context, clusterImage *raczylocomv1.ClusterImage, params shared.JobParams, artifact string) error {
 	jobParams := shared.JobParams{
 		Name:             fmt.Sprintf("img-export-%s", clusterImage.Name),
 		Namespace:        clusterImage.Namespace,
 		Image:            shared.BACKUP_JOB_IMAGE,
 		Annotations:      clusterImage.Spec.JobAnnotations,
-		Commands:         defaultCommands,
+		Labels:           map[string]string{},
+		Commands:         params.Commands,
 		ServiceAccount:   os.Getenv("POD_SERVICE_ACCOUNT"),
 		ImagePullSecrets: clusterImage.Spec.ImagePullSecrets,
+		EnvVars:          params.EnvVars,
+		Storage:          params.Storage,
+		Volumes:          params.Volumes,
+		VolumeMounts:     params.VolumeMounts,
+		// Failed attempts are retried by the controller, following the retry policy of the export
+		SingleAttempt: true,
 		OwnerReferences: []metav1.OwnerReference{
 			{
 				APIVersion:         clusterImage.APIVersion,
@@ -273,106 +615,29 @@ func (r *ClusterImageReconciler) createBackupJob(ctx context.Context, clusterIma
 		},
 	}
 
+	// Running jobs are counted against the limits of the export by this label
+	if owner := metav1.GetControllerOf(clusterImage); owner != nil {
+		jobParams.Labels[shared.EXPORT_UID_LABEL] = string(owner.UID)
+	}
+
 	backupJob := shared.CreateJob(jobParams, func(raczylocomv1.ClusterImageExport) []string { return nil })
 
 	if err := r.Create(ctx, backupJob); err != nil {
 		return err
 	}
+	r.jobs.expect(backupJob)
 
+	startTime := metav1.Now()
 	clusterImage.Status.Progress = shared.STATUS_RUNNING
-	return r.Status().Update(ctx, clusterImage)
-}
-
-func (r *ClusterImageReconciler) updateClusterImageExportStatus(ctx context.Context, clusterImage *raczylocomv1.ClusterImage) (ctrl.Result, error) {
-	l := log.FromContext(ctx)
-
-	clusterImageExport := &raczylocomv1.ClusterImageExport{}
-	if err := r.Get(ctx, types.NamespacedName{Name: clusterImage.Spec.ExportName, Namespace: clusterImage.Namespace}, clusterImageExport); err != nil {
-		l.Error(err, "unable to fetch ClusterImageExport")
-		return ctrl.Result{}, err
-	}
-
-	clusterImageList := &raczylocomv1.ClusterImageList{}
-	if err := r.List(ctx, clusterImageList, client.InNamespace(clusterImage.Namespace), client.MatchingFields{"spec.exportName": clusterImage.Spec.ExportName}); err != nil {
-		l.Error(err, "unable to list ClusterImages")
-		return ctrl.Result{}, err
-	}
-
-	allCompleted := true
-	anyFailed := false
-	anyRunning := false
-
-	for _, ci := range clusterImageList.Items {
-		switch ci.Status.Progress {
-		case shared.STATUS_SUCCESS, shared.STATUS_PRESENT:
-			// These statuses are considered completed
-		case shared.STATUS_FAILED:
-			anyFailed = true
-			allCompleted = false
-		case shared.STATUS_RUNNING, shared.STATUS_RETRYING:
-			allCompleted = false
-			anyRunning = true
-		case shared.STATUS_PENDING:
-			allCompleted = false
-		}
-	}
-
-	var newStatus string
-	if allCompleted {
-		newStatus = shared.STATUS_SUCCESS
-	} else if anyFailed {
-		newStatus = shared.STATUS_FAILED
-	} else if anyRunning {
-		newStatus = shared.STATUS_RUNNING
-	} else {
-		newStatus = shared.STATUS_PENDING
-	}
-
-	if clusterImageExport.Status.Progress != newStatus {
-		clusterImageExport.Status.Progress = newStatus
-		if err := r.Status().Update(ctx, clusterImageExport); err != nil {
-			l.Error(err, "unable to update ClusterImageExport status")
-			return ctrl.Result{}, err
-		}
-		l.Info("Updated ClusterImageExport status", "ExportName", clusterImageExport.Name, "NewStatus", newStatus)
-	}
-
-	// If there are still pending or running images, requeue
-	if !allCompleted {
-		return ctrl.Result{Requeue: true}, nil
-	}
-
-	return ctrl.Result{}, nil
-}
-
-func (r *ClusterImageReconciler) handleJobRestarts(ctx context.Context, job *v1batch.Job, clusterImage *raczylocomv1.ClusterImage) error {
-	podList := &v1.PodList{}
-	if err := r.List(ctx, podList, client.InNamespace(job.Namespace), client.MatchingLabels(job.Spec.Selector.MatchLabels)); err != nil {
+	clusterImage.Status.Artifact = artifact
+	clusterImage.Status.JobName = backupJob.Name
+	clusterImage.Status.StartTime = &startTime
+	clusterImage.Status.CompletionTime = nil
+	clusterImage.Status.NextRetryTime = nil
+	if err := r.Status().Update(ctx, clusterImage); err != nil {
 		return err
 	}
-
-	for _, pod := range podList.Items {
-		for _, containerStatus := range pod.Status.ContainerStatuses {
-			if containerStatus.RestartCount > 0 {
-				clusterImage.Status.RetryCount += int(containerStatus.RestartCount)
-				if clusterImage.Status.RetryCount >= 3 {
-					clusterImage.Status.Progress = shared.STATUS_FAILED
-					if err := r.Status().Update(ctx, clusterImage); err != nil {
-						return err
-					}
-					return r.removeAllJobsAndContainers(ctx, clusterImage.Namespace)
-				} else {
-					clusterImage.Status.Progress = shared.STATUS_RETRYING
-				}
-
-				if err := r.Status().Update(ctx, clusterImage); err != nil {
-					return err
-				}
-				return nil
-			}
-		}
-	}
-
+	r.Recorder.Eventf(clusterImage, v1.EventTypeNormal, shared.EVENT_JOB_STARTED, "Started job %s", backupJob.Name)
 	return nil
 }
 
@@ -389,21 +654,33 @@ func (r *ClusterImageReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&raczylocomv1.ClusterImage{}).
 		Owns(&v1batch.Job{}).
+		// Pending images of the failed export are picked up again once the export is resumed
+		Watches(&raczylocomv1.ClusterImageExport{}, handler.EnqueueRequestsFromMapFunc(r.clusterImagesOfExport),
+			builder.WithPredicates(predicate.Funcs{
+				CreateFunc:  func(event.CreateEvent) bool { return false },
+				DeleteFunc:  func(event.DeleteEvent) bool { return false },
+				GenericFunc: func(event.GenericEvent) bool { return false },
+				UpdateFunc: func(e event.UpdateEvent) bool {
+					oldExport, oldOk := e.ObjectOld.(*raczylocomv1.ClusterImageExport)
+					newExport, newOk := e.ObjectNew.(*raczylocomv1.ClusterImageExport)
+					return oldOk && newOk && oldExport.Status.Progress == shared.STATUS_FAILED && newExport.Status.Progress != shared.STATUS_FAILED
+				},
+			})).
 		Complete(r)
 }
-func (r *ClusterImageReconciler) removeAllJobsAndContainers(ctx context.Context, namespace string) error {
-	jobList := &v1batch.JobList{}
-	if err := r.List(ctx, jobList, client.InNamespace(namespace), client.MatchingLabels{"app": "image-export"}); err != nil {
-		return err
-	}
 
-	for _, job := range jobList.Items {
-		if err := r.Delete(ctx, &job, client.PropagationPolicy(metav1.DeletePropagationForeground)); err != nil && !errors.IsNotFound(err) {
-			return err
-		}
+// clusterImagesOfExport returns the requests of all the ClusterImages of the export
+func (r *ClusterImageReconciler) clusterImagesOfExport(ctx context.Context, obj client.Object) []reconcile.Request {
+	clusterImageList := &raczylocomv1.ClusterImageList{}
+	if err := r.List(ctx, clusterImageList, client.InNamespace(obj.GetNamespace()), client.MatchingFields{"spec.exportName": obj.GetName()}); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list ClusterImages of the export", "export", obj.GetName())
+		return nil
 	}
-
-	return nil
+	requests := make([]reconcile.Request, 0, len(clusterImageList.Items))
+	for _, ci := range clusterImageList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&ci)})
+	}
+	return requests
 }
 
 func (r *ClusterImageReconciler) checkImageExists(ctx context.Context, clusterImage *raczylocomv1.ClusterImage) (bool, error) {
@@ -411,12 +688,18 @@ func (r *ClusterImageReconciler) checkImageExists(ctx context.Context, clusterIm
 	if err := r.List(ctx, clusterImageList); err != nil {
 		return false, err
 	}
+	storages, err := exportStorages(ctx, r.Client)
+	if err != nil {
+		return false, err
+	}
+	storage := imageStorageKey(storages, clusterImage)
 
-	for _, ci := range clusterImageList.Items {
-		if ci.Spec.FullName == clusterImage.Spec.FullName && ci.Name != clusterImage.Name {
-			if ci.Status.Progress == shared.STATUS_SUCCESS || ci.Status.Progress == shared.STATUS_PRESENT || ci.Status.Progress == shared.STATUS_RUNNING {
-				return true, nil
-			}
+	for i := range clusterImageList.Items {
+		if clusterImageList.Items[i].Name == clusterImage.Name {
+			continue
+		}
+		if exportsSameImage(&clusterImageList.Items[i], storages, storage, clusterImage.Spec.Image, clusterImage.Spec.FullName, clusterImage.Spec.Sha, clusterImage.Spec.Platforms, clusterImage.Spec.Referrers) {
+			return true, nil
 		}
 	}
 
@@ -427,3 +710,39 @@ func (r *ClusterImageReconciler) checkImageExists(ctx context.Context, clusterIm
 
 	return false, nil
 }
+
+// exportsSameImage returns true when the ClusterImage exported the same platforms of the image into the same storage
+// or is exporting them right now. Images with known digest are the same only if digests match, tags may have moved
+// in the meantime. Image exported without its referrers doesn't cover the one which needs them.
+func exportsSameImage(ci *raczylocomv1.ClusterImage, storages map[types.NamespacedName]raczylocomv1.ClusterImageStorageSpec, storage string, image string, fullName string, sha string, platforms []string, referrers bool) bool {
+	if storage == "" || imageStorageKey(storages, ci) != storage {
+		return false
+	}
+	sameImage := ci.Spec.FullName == fullName && ci.Spec.Sha == sha
+	if sha != "" && ci.Spec.Image == image && ci.Spec.Sha == sha {
+		sameImage = true
+	}
+	return sameImage && shared.SamePlatforms(ci.Spec.Platforms, platforms) && (ci.Spec.Referrers || !referrers) && (ci.Status.Progress == shared.STATUS_SUCCESS || ci.Status.Progress == shared.STATUS_PRESENT || ci.Status.Progress == shared.STATUS_RUNNING)
+}
+
+// exportStorages returns the storage of every export, keyed by the namespace and name of the export
+func exportStorages(ctx context.Context, c client.Reader, opts ...client.ListOption) (map[types.NamespacedName]raczylocomv1.ClusterImageStorageSpec, error) {
+	exportsList := &raczylocomv1.ClusterImageExportList{}
+	if err := c.List(ctx, exportsList, opts...); err != nil {
+		return nil, err
+	}
+	storages := make(map[types.NamespacedName]raczylocomv1.ClusterImageStorageSpec, len(exportsList.Items))
+	for _, export := range exportsList.Items {
+		storages[client.ObjectKeyFromObject(&export)] = export.Spec.Storage
+	}
+	return storages, nil
+}
+
+// imageStorageKey returns the storage key of the ClusterImage, empty when its export is gone
+func imageStorageKey(storages map[types.NamespacedName]raczylocomv1.ClusterImageStorageSpec, ci *raczylocomv1.ClusterImage) string {
+	storage, ok := storages[types.NamespacedName{Namespace: ci.Namespace, Name: ci.Spec.ExportName}]
+	if !ok {
+		return ""
+	}
+	return storageKey(storage, ci.Spec.ExportPath)
+}
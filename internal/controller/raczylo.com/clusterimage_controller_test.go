@@ -18,16 +18,21 @@ package raczylocom
 
 import (
 	"context"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+	"github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
 )
 
 var _ = Describe("ClusterImage Controller", func() {
@@ -69,8 +74,9 @@ var _ = Describe("ClusterImage Controller", func() {
 		It("should successfully reconcile the resource", func() {
 			By("Reconciling the created resource")
 			controllerReconciler := &ClusterImageReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
 			}
 
 			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
@@ -81,4 +87,194 @@ var _ = Describe("ClusterImage Controller", func() {
 			// Example: If you expect a certain status condition after reconciliation, verify it here.
 		})
 	})
+
+	Context("When recording the export attempts", func() {
+		It("should report the last failure of the job pods", func() {
+			earlier := metav1.NewTime(time.Now().Add(-time.Minute))
+			pods := []corev1.Pod{{
+				Status: corev1.PodStatus{
+					ContainerStatuses: []corev1.ContainerStatus{{
+						LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+							ExitCode: 125, Message: "Error: initializing source docker://nginx:1.27: unauthorized\n", FinishedAt: metav1.Now(),
+						}},
+						State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+							ExitCode: 1, Reason: "Error", FinishedAt: earlier,
+						}},
+					}},
+				},
+			}}
+
+			exitCode, message := jobFailure(pods)
+			Expect(exitCode).To(Equal(int32(125)))
+			Expect(message).To(Equal("Error: initializing source docker://nginx:1.27: unauthorized"))
+
+			exitCode, message = jobFailure(nil)
+			Expect(exitCode).To(BeZero())
+			Expect(message).To(BeEmpty())
+		})
+
+		It("should keep the bounded history of attempts", func() {
+			status := &raczylocomv1.ClusterImageStatus{JobName: "img-export-abc"}
+			for i := 0; i < shared.MAX_ATTEMPTS_HISTORY; i++ {
+				recordAttempt(status, shared.STATUS_FAILED, int32(i+1), "failed")
+			}
+			recordAttempt(status, shared.STATUS_SUCCESS, 0, "")
+
+			Expect(status.Attempts).To(HaveLen(shared.MAX_ATTEMPTS_HISTORY))
+			Expect(status.Attempts[0].ExitCode).To(Equal(int32(2)))
+			Expect(status.Attempts[len(status.Attempts)-1].Result).To(Equal(shared.STATUS_SUCCESS))
+			Expect(status.Attempts[len(status.Attempts)-1].JobName).To(Equal("img-export-abc"))
+			Expect(status.LastError).To(Equal("failed"))
+			Expect(status.FailureClass).To(Equal(shared.FAILURE_OTHER))
+			Expect(status.ExitCode).To(Equal(int32(shared.MAX_ATTEMPTS_HISTORY)))
+			Expect(status.CompletionTime).NotTo(BeNil())
+		})
+
+		It("should report the failed job with the last line of its output", func() {
+			recorder := record.NewFakeRecorder(10)
+			controllerReconciler := &ClusterImageReconciler{Recorder: recorder}
+			nextRetryTime := metav1.NewTime(time.Now().Add(time.Minute))
+			clusterImage := &raczylocomv1.ClusterImage{Status: raczylocomv1.ClusterImageStatus{
+				Progress:      shared.STATUS_RETRYING,
+				RetryCount:    1,
+				JobName:       "img-export-abc",
+				ExitCode:      125,
+				LastError:     "Trying to pull docker.io/library/nginx:1.27...\nError: unauthorized\n",
+				FailureClass:  shared.FAILURE_UNAUTHORIZED,
+				NextRetryTime: &nextRetryTime,
+			}}
+			controllerReconciler.failureEvent(clusterImage)
+			clusterImage.Status.Progress = shared.STATUS_FAILED
+			controllerReconciler.failureEvent(clusterImage)
+
+			Expect(recorder.Events).To(Receive(Equal("Warning Retrying Job img-export-abc failed with exit code 125 (Unauthorized), retry 1 in 1m0s: Error: unauthorized")))
+			Expect(recorder.Events).To(Receive(Equal("Warning Failed Job img-export-abc failed with exit code 125 (Unauthorized) after 1 retries: Error: unauthorized")))
+		})
+	})
+
+	Context("When building the archive commands", func() {
+		It("should pull the single platform", func() {
+			commands, err := archiveCommands("nginx@sha256:1111", "nginx:1.27", "/tmp/nginx.tar", shared.FORMAT_DOCKER_ARCHIVE, []string{"linux/arm64"}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commands).To(Equal([]string{
+				"podman pull --platform linux/arm64 nginx@sha256:1111",
+				"podman tag nginx@sha256:1111 nginx:1.27",
+				"podman save --quiet -o /tmp/nginx.tar nginx:1.27",
+			}))
+		})
+
+		It("should push the manifest list of the requested platforms into the archive", func() {
+			commands, err := archiveCommands("nginx:1.27", "nginx:1.27", "/tmp/nginx.tar", shared.FORMAT_OCI_ARCHIVE, []string{"linux/amd64", "linux/arm/v7"}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commands).To(Equal([]string{
+				"podman manifest create nginx:1.27",
+				"podman manifest add --os linux --arch amd64 nginx:1.27 docker://nginx:1.27",
+				"podman manifest add --os linux --arch arm --variant v7 nginx:1.27 docker://nginx:1.27",
+				"podman manifest push --all --quiet nginx:1.27 oci-archive:/tmp/nginx.tar:nginx:1.27",
+			}))
+
+			commands, err = archiveCommands("nginx:1.27", "nginx:1.27", "/tmp/nginx.tar", shared.FORMAT_OCI_ARCHIVE, []string{shared.PLATFORM_ALL}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commands).To(ContainElement("podman manifest add --all nginx:1.27 docker://nginx:1.27"))
+		})
+
+		It("should refuse more platforms in the docker archive", func() {
+			_, err := archiveCommands("nginx:1.27", "nginx:1.27", "/tmp/nginx.tar", shared.FORMAT_DOCKER_ARCHIVE, []string{shared.PLATFORM_ALL}, false)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should copy the image with the referrers unchanged", func() {
+			commands, err := archiveCommands("nginx@sha256:1111", "nginx:1.27", "/tmp/nginx.tar", shared.FORMAT_OCI_ARCHIVE, []string{shared.PLATFORM_ALL}, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commands).To(Equal([]string{
+				"skopeo copy --all --preserve-digests --retry-times=3 docker://nginx@sha256:1111 oci-archive:/tmp/nginx.tar:nginx:1.27",
+			}))
+
+			_, err = archiveCommands("nginx@sha256:1111", "nginx:1.27", "/tmp/nginx.tar", shared.FORMAT_OCI_ARCHIVE, []string{"linux/amd64"}, true)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("When checking the baseline export", func() {
+		s3Storage := raczylocomv1.ClusterImageStorageSpec{
+			StorageTarget: shared.STORAGE_S3,
+			S3:            raczylocomv1.ClusterImageStorageS3{Bucket: "backup", UseRole: true},
+		}
+		fileStorage := raczylocomv1.ClusterImageStorageSpec{StorageTarget: shared.STORAGE_FILE}
+		baselineImage := func(storage string, sha string, platforms []string) *raczylocomv1.ClusterImage {
+			return &raczylocomv1.ClusterImage{Spec: raczylocomv1.ClusterImageSpec{
+				FullName:   "nginx:1.27",
+				Sha:        sha,
+				Storage:    storage,
+				ExportPath: "/nightly",
+				Baseline:   "backup-1",
+				Platforms:  platforms,
+			}}
+		}
+
+		withReferrers := func(clusterImage *raczylocomv1.ClusterImage) *raczylocomv1.ClusterImage {
+			clusterImage.Spec.Referrers = true
+			return clusterImage
+		}
+
+		DescribeTable("should look the image up in the manifest of the baseline",
+			func(clusterImage *raczylocomv1.ClusterImage, storage raczylocomv1.ClusterImageStorageSpec, expected string) {
+				Expect(baselineCommand(clusterImage, storage)).To(Equal(expected))
+			},
+			Entry("in the S3 bucket",
+				baselineImage(shared.STORAGE_S3, "", nil), s3Storage,
+				"./baseline.py --use_role 's3://backup/nightly/backup-1/"+shared.MANIFEST_FILE+"' 'nginx:1.27'"),
+			Entry("in the file storage with the digest",
+				baselineImage(shared.STORAGE_FILE, "sha256:1111", nil), fileStorage,
+				"./baseline.py '"+shared.FILE_STORAGE_PATH+"/nightly/backup-1/"+shared.MANIFEST_FILE+"' 'nginx:1.27' --sha='sha256:1111'"),
+			Entry("with the exported platforms",
+				baselineImage(shared.STORAGE_S3, "sha256:1111", []string{"linux/amd64", "linux/arm64"}), s3Storage,
+				"./baseline.py --use_role 's3://backup/nightly/backup-1/"+shared.MANIFEST_FILE+"' 'nginx:1.27' --sha='sha256:1111' --platform='linux/amd64' --platform='linux/arm64'"),
+			Entry("with all the platforms",
+				baselineImage(shared.STORAGE_S3, "", []string{shared.PLATFORM_ALL}), s3Storage,
+				"./baseline.py --use_role 's3://backup/nightly/backup-1/"+shared.MANIFEST_FILE+"' 'nginx:1.27' --all-platforms"),
+			Entry("with the referrers",
+				withReferrers(baselineImage(shared.STORAGE_S3, "sha256:1111", []string{shared.PLATFORM_ALL})), s3Storage,
+				"./baseline.py --use_role 's3://backup/nightly/backup-1/"+shared.MANIFEST_FILE+"' 'nginx:1.27' --sha='sha256:1111' --referrers --all-platforms"),
+		)
+	})
+
+	Context("When exporting the referrers", func() {
+		It("should store every referrer in its own archive next to the image", func() {
+			referrers := []raczylocomv1.ClusterImageReferrer{{Kind: shared.REFERRER_SIGNATURE, Digest: "sha256:2222", Tag: "sha256-1111.sig"}}
+			commands := referrerCommands(referrers, "ghcr.io/acme/app", "s3://backup/nightly/ghcr.io-acme-app.referrers", "--use-role ")
+			Expect(commands).To(Equal([]string{
+				"skopeo copy --preserve-digests --retry-times=3 'docker://ghcr.io/acme/app@sha256:2222' 'oci-archive:/tmp/sha256-2222.tar'",
+				"./export.py --use-role '/tmp/sha256-2222.tar' 's3://backup/nightly/ghcr.io-acme-app.referrers/sha256-2222.tar'",
+				"rm -f /tmp/sha256-2222.tar",
+			}))
+			Expect(referrers[0].Artifact).To(Equal("s3://backup/nightly/ghcr.io-acme-app.referrers/sha256-2222.tar"))
+		})
+
+		It("should export the image without the referrers which can't be discovered", func() {
+			recorder := record.NewFakeRecorder(10)
+			controllerReconciler := &ClusterImageReconciler{Client: fake.NewClientBuilder().Build(), Recorder: recorder}
+			clusterImage := &raczylocomv1.ClusterImage{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+				Spec: raczylocomv1.ClusterImageSpec{
+					Image:            "ghcr.io/acme/app",
+					Sha:              "sha256:1111",
+					Referrers:        true,
+					ImagePullSecrets: []corev1.LocalObjectReference{{Name: "missing"}},
+				},
+			}
+
+			Expect(controllerReconciler.imageReferrers(context.Background(), clusterImage)).To(BeEmpty())
+			Expect(recorder.Events).To(Receive(HavePrefix("Warning ReferrersUnavailable Unable to discover the referrers, the image is exported without them")))
+		})
+
+		It("should pass the referrers without the cosign tag to the mirror", func() {
+			referrers := []raczylocomv1.ClusterImageReferrer{
+				{Kind: shared.REFERRER_SIGNATURE, Digest: "sha256:2222", Tag: "sha256-1111.sig"},
+				{Kind: shared.REFERRER_SBOM, Digest: "sha256:3333"},
+			}
+			Expect(mirrorReferrerArgs(referrers, "registry.internal:5000/acme/app:1.0")).To(Equal(" --referrer='sha256:3333'"))
+			Expect(referrers[0].Artifact).To(Equal("docker://registry.internal:5000/acme/app@sha256:2222"))
+		})
+	})
 })
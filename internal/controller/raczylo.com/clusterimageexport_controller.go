@@ -1,25 +1,35 @@
 package raczylocom
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
+	"encoding/json"
 	"fmt"
 	"os"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	v1batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
 
 	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
 	shared "github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
@@ -29,6 +39,14 @@ import (
 type ClusterImageExportReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Reject the resources with the S3 keys set inline instead of the secret
+	ForbidInlineCredentials bool
+	Recorder                record.EventRecorder
+
+	// Registry clients of the running exports keyed by the export UID, so the digests and the registry
+	// tokens are reused between the reconciles
+	registryClientsMutex sync.Mutex
+	registryClients      map[types.UID]*shared.RegistryClient
 }
 
 // +kubebuilder:rbac:groups=raczylo.com,resources=*,verbs=get;list;watch;create;update;patch;delete
@@ -37,9 +55,15 @@ type ClusterImageExportReconciler struct {
 // additional RBAC rules
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
 // +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;watch
 // +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 const clusterImageExportFinalizer = "finalizer.clusterimageexport.raczylo.com"
 
@@ -65,15 +89,48 @@ func (r *ClusterImageExportReconciler) Reconcile(ctx context.Context, req ctrl.R
 		}
 	}
 
+	// Changed retry-failed annotation retries the failed images and resumes the finished export
+	if retry := clusterImageExport.Annotations[shared.RETRY_FAILED_ANNOTATION]; retry != "" && retry != clusterImageExport.Status.LastRetry {
+		if err := r.retryFailedImages(ctx, clusterImageExport, retry); err != nil {
+			l.Error(err, "unable to retry the failed images")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Planned export runs for real once the dry run is turned off
+	if clusterImageExport.Status.Progress == shared.STATUS_PLANNED && !clusterImageExport.Spec.DryRun {
+		clusterImageExport.Status.Progress = ""
+		clusterImageExport.Status.CompletionTime = nil
+	}
+
 	// Early return if the ClusterImageExport is already in a completed state
-	if clusterImageExport.Status.Progress == shared.STATUS_SUCCESS || clusterImageExport.Status.Progress == shared.STATUS_FAILED {
+	if shared.ExportFinished(clusterImageExport.Status.Progress) {
 		l.Info("ClusterImageExport is already in a completed state", "Status", clusterImageExport.Status.Progress)
 		return ctrl.Result{}, nil
 	}
 
 	// If the status is empty, set it to PENDING
 	if clusterImageExport.Status.Progress == "" {
+		// Same checks as the admission webhook, which may be disabled
+		if _, allErrs := shared.ValidateExport(clusterImageExport, r.ForbidInlineCredentials); len(allErrs) > 0 {
+			err := allErrs.ToAggregate()
+			l.Error(err, "ClusterImageExport rejected")
+			return ctrl.Result{}, r.finishExport(ctx, clusterImageExport, shared.STATUS_FAILED, shared.REASON_INVALID_SPEC, "Invalid export: "+err.Error())
+		}
+		if err := shared.CheckStorageSecret(ctx, r.Client, clusterImageExport.Namespace, clusterImageExport.Spec.Storage); err != nil {
+			l.Error(err, "storage credentials are not available")
+			return ctrl.Result{}, err
+		}
 		clusterImageExport.Status.Progress = shared.STATUS_PENDING
+		startTime := metav1.Now()
+		clusterImageExport.Status.StartTime = &startTime
+		setCondition(clusterImageExport, shared.CONDITION_READY, metav1.ConditionFalse, shared.REASON_PENDING, "Export has not completed yet")
+		baseline, err := r.resolveBaseline(ctx, clusterImageExport)
+		if err != nil {
+			l.Error(err, "unable to resolve baseline export")
+			return ctrl.Result{}, err
+		}
+		clusterImageExport.Status.Baseline = baseline
 		if err := r.Status().Update(ctx, clusterImageExport); err != nil {
 			l.Error(err, "unable to update ClusterImageExport status")
 			return ctrl.Result{}, err
@@ -84,6 +141,10 @@ func (r *ClusterImageExportReconciler) Reconcile(ctx context.Context, req ctrl.R
 	fullImagesList, err := r.listImagesInCluster(ctx, l, clusterImageExport)
 	if err != nil {
 		l.Error(err, "unable to list images in the cluster")
+		setCondition(clusterImageExport, shared.CONDITION_DISCOVERED, metav1.ConditionFalse, shared.REASON_DISCOVERY_FAILED, err.Error())
+		if err := r.Status().Update(ctx, clusterImageExport); err != nil {
+			l.Error(err, "unable to update ClusterImageExport status")
+		}
 		return ctrl.Result{}, err
 	}
 
@@ -98,7 +159,24 @@ func (r *ClusterImageExportReconciler) Reconcile(ctx context.Context, req ctrl.R
 		}
 	}
 
+	if clusterImageExport.Spec.ResolveDigests {
+		if err := r.resolveImageDigests(ctx, clusterImageExport, &fullImagesList); err != nil {
+			l.Error(err, "unable to resolve image digests")
+			return ctrl.Result{}, err
+		}
+	}
+
+	exportImagesDiscovered.WithLabelValues(clusterImageExport.Namespace, clusterImageExport.Name).Set(float64(len(fullImagesList.Containers)))
+	if !meta.IsStatusConditionTrue(clusterImageExport.Status.Conditions, shared.CONDITION_DISCOVERED) {
+		r.Recorder.Eventf(clusterImageExport, corev1.EventTypeNormal, shared.EVENT_DISCOVERED, "Discovered %d images", len(fullImagesList.Containers))
+	}
+	if clusterImageExport.Spec.DryRun {
+		return r.planExport(ctx, clusterImageExport, fullImagesList)
+	}
+
 	clusterImageExport.Status.Progress = shared.STATUS_RUNNING
+	setCondition(clusterImageExport, shared.CONDITION_DISCOVERED, metav1.ConditionTrue, shared.REASON_DISCOVERED, fmt.Sprintf("Discovered %d images", len(fullImagesList.Containers)))
+	setCondition(clusterImageExport, shared.CONDITION_EXPORTING, metav1.ConditionTrue, shared.REASON_EXPORTING, "Images are being exported")
 	if err := r.Status().Update(ctx, clusterImageExport); err != nil {
 		l.Error(err, "unable to update ClusterImageExport status to RUNNING")
 		return ctrl.Result{}, err
@@ -111,15 +189,7 @@ func (r *ClusterImageExportReconciler) Reconcile(ctx context.Context, req ctrl.R
 		clusterImage := &raczylocomv1.ClusterImage{}
 		err := r.Get(ctx, client.ObjectKey{Namespace: clusterImageExport.Namespace, Name: nameHash}, clusterImage)
 		if err == nil {
-			// ClusterImage exists, check its status
-			if clusterImage.Status.Progress == shared.STATUS_FAILED {
-				clusterImageExport.Status.Progress = shared.STATUS_FAILED
-				if err := r.Status().Update(ctx, clusterImageExport); err != nil {
-					l.Error(err, "unable to update ClusterImageExport status to FAILED")
-					return ctrl.Result{}, err
-				}
-				return ctrl.Result{}, nil
-			}
+			// ClusterImage exists
 			continue
 		} else if !errors.IsNotFound(err) {
 			l.Error(err, "unable to get ClusterImage")
@@ -152,6 +222,9 @@ func (r *ClusterImageExportReconciler) Reconcile(ctx context.Context, req ctrl.R
 				ExportPath:       clusterImageExport.Spec.BasePath,
 				JobAnnotations:   clusterImageExport.Spec.JobAnnotations,
 				ImagePullSecrets: clusterImageExport.Spec.ImagePullSecrets,
+				Baseline:         clusterImageExport.Status.Baseline,
+				Platforms:        clusterImageExport.Spec.Platforms,
+				Referrers:        clusterImageExport.Spec.Referrers,
 			},
 		}
 
@@ -159,39 +232,630 @@ func (r *ClusterImageExportReconciler) Reconcile(ctx context.Context, req ctrl.R
 			l.Error(err, "unable to create ClusterImage", "image", image)
 			return ctrl.Result{}, err
 		}
+		r.Recorder.Eventf(clusterImageExport, corev1.EventTypeNormal, shared.EVENT_IMAGE_CREATED, "Created ClusterImage %s for %s", newClusterImage.Name, image.FullName)
+		r.Recorder.Eventf(newClusterImage, corev1.EventTypeNormal, shared.EVENT_IMAGE_CREATED, "Created for %s by export %s", image.FullName, clusterImageExport.Name)
 	}
 
 	// Check if all ClusterImages are completed
-	allCompleted, err := r.checkAllClusterImagesCompleted(ctx, clusterImageExport)
+	allCompleted, err := r.updateImageCounters(ctx, clusterImageExport)
 	if err != nil {
 		l.Error(err, "unable to check ClusterImages status")
 		return ctrl.Result{}, err
 	}
 
+	if clusterImageExport.Status.Progress == shared.STATUS_FAILED {
+		return ctrl.Result{}, nil
+	}
+
 	if allCompleted {
-		clusterImageExport.Status.Progress = shared.STATUS_SUCCESS
-		if err := r.Status().Update(ctx, clusterImageExport); err != nil {
-			l.Error(err, "unable to update ClusterImageExport status to SUCCESS")
+		return r.writeManifest(ctx, clusterImageExport)
+	}
+
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// writeManifest stores the manifest of exported images in the export root and completes the export once it's written
+func (r *ClusterImageExportReconciler) writeManifest(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+	jobName := manifestName(clusterImageExport.Name)
+
+	if clusterImageExport.Spec.Storage.StorageTarget == shared.STORAGE_REGISTRY {
+		// Registry has no place for the manifest, it's kept in the ConfigMap of the export
+		if _, err := r.createManifestConfigMap(ctx, clusterImageExport, jobName); err != nil {
+			if _, ok := err.(manifestTooLargeError); !ok {
+				l.Error(err, "unable to store the manifest")
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{}, r.finishExport(ctx, clusterImageExport, shared.STATUS_FAILED, shared.REASON_MANIFEST_FAILED, "Unable to store the manifest: "+err.Error())
+		}
+		progress, reason, message := exportResult(clusterImageExport, "All images are mirrored into the registry")
+		if err := r.finishExport(ctx, clusterImageExport, progress, reason, message); err != nil {
+			l.Error(err, "unable to update ClusterImageExport status", "Status", progress)
 			return ctrl.Result{}, err
 		}
+		return ctrl.Result{}, nil
+	}
+
+	manifestJob := &v1batch.Job{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: clusterImageExport.Namespace, Name: jobName}, manifestJob)
+	if errors.IsNotFound(err) {
+		if err := r.createManifestJob(ctx, clusterImageExport, jobName); err != nil {
+			if _, ok := err.(manifestTooLargeError); ok {
+				return ctrl.Result{}, r.finishExport(ctx, clusterImageExport, shared.STATUS_FAILED, shared.REASON_MANIFEST_FAILED, "Unable to store the manifest: "+err.Error())
+			}
+			l.Error(err, "unable to create manifest job")
+			return ctrl.Result{}, err
+		}
+		l.Info("Created manifest job", "job", jobName)
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	} else if err != nil {
+		l.Error(err, "unable to get manifest job")
+		return ctrl.Result{}, err
+	}
+
+	if manifestJob.Status.Succeeded == 0 && manifestJob.Status.Failed == 0 {
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
 	}
 
-	return ctrl.Result{Requeue: !allCompleted}, nil
+	var progress, reason, message string
+	if manifestJob.Status.Succeeded > 0 {
+		progress, reason, message = exportResult(clusterImageExport, "All images are exported and the manifest is written")
+	} else {
+		l.Error(nil, "Manifest job failed", "job", jobName)
+		progress, reason, message = shared.STATUS_FAILED, shared.REASON_MANIFEST_FAILED, "Manifest job "+jobName+" failed"
+	}
+	if err := r.finishExport(ctx, clusterImageExport, progress, reason, message); err != nil {
+		l.Error(err, "unable to update ClusterImageExport status", "Status", progress)
+		return ctrl.Result{}, err
+	}
+
+	if err := r.Delete(ctx, manifestJob, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+		l.Error(err, "unable to delete manifest job", "job", jobName)
+	}
+	return ctrl.Result{}, nil
+}
+
+// manifestName returns the name of the manifest job and the ConfigMap of the export
+func manifestName(exportName string) string {
+	return "manifest-" + shared.NormalizeImageName(exportName)
 }
 
-func (r *ClusterImageExportReconciler) checkAllClusterImagesCompleted(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport) (bool, error) {
+func (r *ClusterImageExportReconciler) createManifestJob(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport, jobName string) error {
+	configMap, err := r.createManifestConfigMap(ctx, clusterImageExport, jobName)
+	if err != nil {
+		return err
+	}
+
+	exportRoot := shared.StorageLocation(clusterImageExport.Spec.Storage, clusterImageExport.Spec.BasePath, clusterImageExport.Name)
+	storageParams := ""
+	if clusterImageExport.Spec.Storage.StorageTarget == shared.STORAGE_S3 {
+		storageParams = strings.Join(shared.SetupS3Params(clusterImageExport.Spec.Storage.S3), " ") + " "
+	}
+	defaultCommands := []string{}
+	// OCI layout files live in the layout directory, ConfigMap keys can't contain slashes
+	destinations := map[string]string{
+		shared.OCI_INDEX_KEY:  shared.OCI_LAYOUT_DIR + "/index.json",
+		shared.OCI_LAYOUT_KEY: shared.OCI_LAYOUT_DIR + "/oci-layout",
+	}
+	for file := range configMap.Data {
+		destination, ok := destinations[file]
+		if !ok {
+			destination = file
+		}
+		defaultCommands = append(defaultCommands, "./export.py "+storageParams+"'/home/runner/manifest/"+file+"' '"+exportRoot+"/"+destination+"'")
+	}
+	sort.Strings(defaultCommands)
+
+	jobParams := shared.JobParams{
+		Name:           jobName,
+		Namespace:      clusterImageExport.Namespace,
+		Image:          shared.BACKUP_JOB_IMAGE,
+		Commands:       defaultCommands,
+		Annotations:    clusterImageExport.Spec.JobAnnotations,
+		EnvVars:        shared.StorageEnvVars(clusterImageExport.Spec.Storage),
+		Storage:        &clusterImageExport.Spec.Storage,
+		ServiceAccount: os.Getenv("POD_SERVICE_ACCOUNT"),
+		Volumes: []corev1.Volume{
+			{
+				Name: "manifest",
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: configMap.Name},
+					},
+				},
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "manifest",
+				MountPath: "/home/runner/manifest",
+				ReadOnly:  true,
+			},
+		},
+	}
+
+	manifestJob := shared.CreateJob(jobParams, func(raczylocomv1.ClusterImageExport) []string { return nil })
+	if err := controllerutil.SetControllerReference(clusterImageExport, manifestJob, r.Scheme); err != nil {
+		return err
+	}
+	return r.Create(ctx, manifestJob)
+}
+
+// createManifestConfigMap stores the manifest in the ConfigMap owned by the export
+func (r *ClusterImageExportReconciler) createManifestConfigMap(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport, name string) (*corev1.ConfigMap, error) {
+	manifest, err := r.buildManifest(ctx, clusterImageExport)
+	if err != nil {
+		return nil, err
+	}
+	data, err := manifestData(clusterImageExport, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: clusterImageExport.Namespace,
+		},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		configMap.Data = data
+		return controllerutil.SetControllerReference(clusterImageExport, configMap, r.Scheme)
+	}); err != nil {
+		return nil, err
+	}
+	return configMap, nil
+}
+
+// manifestTooLargeError is returned for the manifest which doesn't fit into the ConfigMap
+type manifestTooLargeError struct {
+	size int
+}
+
+func (e manifestTooLargeError) Error() string {
+	return fmt.Sprintf("manifest of %d bytes exceeds the %d bytes limit of the ConfigMap", e.size, shared.MANIFEST_CONFIGMAP_MAX_SIZE)
+}
+
+// manifestData returns the files of the manifest ConfigMap. Manifest too large for the ConfigMap is stored
+// without the indentation and the YAML copy, the error is returned when even that doesn't fit.
+func manifestData(clusterImageExport *raczylocomv1.ClusterImageExport, manifest *shared.ExportManifest) (map[string]string, error) {
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]string{shared.MANIFEST_FILE: string(manifestJSON)}
+	if clusterImageExport.Spec.Format == shared.FORMAT_OCI_LAYOUT {
+		layoutRoot := shared.StorageLocation(clusterImageExport.Spec.Storage, clusterImageExport.Spec.BasePath, clusterImageExport.Name) + "/" + shared.OCI_LAYOUT_DIR
+		index, err := json.MarshalIndent(shared.BuildOCIIndex(manifest, layoutRoot), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		data[shared.OCI_INDEX_KEY] = string(index)
+		data[shared.OCI_LAYOUT_KEY] = `{"imageLayoutVersion":"1.0.0"}`
+	}
+	if clusterImageExport.Spec.ManifestYAML {
+		manifestYAML, err := yaml.Marshal(manifest)
+		if err != nil {
+			return nil, err
+		}
+		data[shared.MANIFEST_YAML_FILE] = string(manifestYAML)
+	}
+	if dataSize(data) <= shared.MANIFEST_CONFIGMAP_MAX_SIZE {
+		return data, nil
+	}
+
+	delete(data, shared.MANIFEST_YAML_FILE)
+	for _, key := range []string{shared.MANIFEST_FILE, shared.OCI_INDEX_KEY} {
+		if value, ok := data[key]; ok {
+			compacted := &bytes.Buffer{}
+			if err := json.Compact(compacted, []byte(value)); err != nil {
+				return nil, err
+			}
+			data[key] = compacted.String()
+		}
+	}
+	if size := dataSize(data); size > shared.MANIFEST_CONFIGMAP_MAX_SIZE {
+		return nil, manifestTooLargeError{size: size}
+	}
+	return data, nil
+}
+
+// dataSize returns the size of the ConfigMap data
+func dataSize(data map[string]string) int {
+	size := 0
+	for key, value := range data {
+		size += len(key) + len(value)
+	}
+	return size
+}
+
+func (r *ClusterImageExportReconciler) buildManifest(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport) (*shared.ExportManifest, error) {
 	clusterImageList := &raczylocomv1.ClusterImageList{}
 	if err := r.List(ctx, clusterImageList, client.InNamespace(clusterImageExport.Namespace), client.MatchingFields{"spec.exportName": clusterImageExport.Name}); err != nil {
-		return false, err
+		return nil, err
 	}
 
+	// Images marked as PRESENT because of the other running export don't have their own artifact,
+	// the artifact of the same image stored in the same storage is referenced instead
+	storages, err := exportStorages(ctx, r.Client)
+	if err != nil {
+		return nil, err
+	}
+	stored := map[string]raczylocomv1.ClusterImageStatus{}
+	allImagesList := &raczylocomv1.ClusterImageList{}
+	if err := r.List(ctx, allImagesList); err != nil {
+		return nil, err
+	}
+	for _, ci := range allImagesList.Items {
+		key := imageStorageKey(storages, &ci)
+		if key != "" && ci.Status.Artifact != "" && ci.Status.Progress == shared.STATUS_SUCCESS {
+			stored[key+ci.Spec.FullName] = ci.Status
+		}
+	}
+
+	manifest := &shared.ExportManifest{
+		ExportName:  clusterImageExport.Name,
+		Format:      clusterImageExport.Spec.Format,
+		Baseline:    clusterImageExport.Status.Baseline,
+		GeneratedAt: time.Now().UTC(),
+		Images:      []shared.ManifestImage{},
+	}
 	for _, ci := range clusterImageList.Items {
+		// Failed images tolerated by the failure policy are left out
 		if ci.Status.Progress != shared.STATUS_SUCCESS && ci.Status.Progress != shared.STATUS_PRESENT {
-			return false, nil
+			continue
+		}
+		status := ci.Status
+		if status.Artifact == "" || (status.Progress == shared.STATUS_PRESENT && status.Size == 0) {
+			if storedStatus, ok := stored[storageKey(clusterImageExport.Spec.Storage, ci.Spec.ExportPath)+ci.Spec.FullName]; ok {
+				status = storedStatus
+			}
+		}
+		manifest.Images = append(manifest.Images, shared.ManifestImage{
+			FullName:       ci.Spec.FullName,
+			Image:          ci.Spec.Image,
+			Tag:            ci.Spec.Tag,
+			Sha:            ci.Spec.Sha,
+			ImageNamespace: ci.Spec.ImageNamespace,
+			Artifact:       status.Artifact,
+			Size:           status.Size,
+			Sha256:         status.Sha256,
+			MediaType:      status.MediaType,
+			Present:        ci.Status.Progress == shared.STATUS_PRESENT,
+			Platforms:      ci.Spec.Platforms,
+			Referrers:      status.Referrers,
+		})
+	}
+	sort.Slice(manifest.Images, func(i, j int) bool {
+		return manifest.Images[i].FullName < manifest.Images[j].FullName
+	})
+
+	return manifest, nil
+}
+
+// storageKey identifies the storage location of the images, artifacts of the other location can't be referenced
+func storageKey(storage raczylocomv1.ClusterImageStorageSpec, basePath string) string {
+	key := storage.StorageTarget + ":" + shared.StorageLocation(storage, basePath, "")
+	switch {
+	case storage.StorageTarget == shared.STORAGE_REGISTRY:
+		key += ":" + storage.Registry.Registry
+	case storage.File.PersistentVolumeClaim != nil:
+		key += ":pvc/" + storage.File.PersistentVolumeClaim.ClaimName
+	case storage.File.NFS != nil:
+		key += ":nfs/" + storage.File.NFS.Server + storage.File.NFS.Path
+	case storage.File.HostPath != nil:
+		key += ":hostPath" + storage.File.HostPath.Path
+	}
+	return key + "/"
+}
+
+// updateImageCounters records the progress of the ClusterImages in the export status and fails the export
+// when the failed images are not tolerated by its failure policy. Returns true once all the images are finished.
+func (r *ClusterImageExportReconciler) updateImageCounters(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport) (bool, error) {
+	clusterImageList := &raczylocomv1.ClusterImageList{}
+	if err := r.List(ctx, clusterImageList, client.InNamespace(clusterImageExport.Namespace), client.MatchingFields{"spec.exportName": clusterImageExport.Name}); err != nil {
+		return false, err
+	}
+
+	allCompleted := countImages(&clusterImageExport.Status, clusterImageList.Items)
+	recordImageCounters(clusterImageExport)
+	if failureToleranceExceeded(clusterImageExport.Spec.FailurePolicy, &clusterImageExport.Status) {
+		return false, r.finishExport(ctx, clusterImageExport, shared.STATUS_FAILED, shared.REASON_IMAGE_FAILED,
+			fmt.Sprintf("%d of %d images failed to export", clusterImageExport.Status.Failed, clusterImageExport.Status.Total))
+	}
+	if err := r.Status().Update(ctx, clusterImageExport); err != nil {
+		return false, err
+	}
+	return allCompleted, nil
+}
+
+// countImages fills the image counters of the export status, returns true when all the images are exported or failed
+func countImages(status *raczylocomv1.ClusterImageExportStatus, clusterImages []raczylocomv1.ClusterImage) bool {
+	status.Total = len(clusterImages)
+	status.Pending, status.Running, status.Succeeded, status.Present, status.Failed = 0, 0, 0, 0, 0
+	status.FailedImages = nil
+	for _, ci := range clusterImages {
+		switch ci.Status.Progress {
+		case shared.STATUS_SUCCESS:
+			status.Succeeded++
+		case shared.STATUS_PRESENT:
+			status.Present++
+		case shared.STATUS_FAILED:
+			status.Failed++
+			status.FailedImages = append(status.FailedImages, ci.Spec.FullName)
+		case shared.STATUS_RUNNING:
+			status.Running++
+		default:
+			status.Pending++
+		}
+	}
+	sort.Strings(status.FailedImages)
+	return status.Succeeded+status.Present+status.Failed == status.Total
+}
+
+// failureToleranceExceeded returns true when the failed images fail the whole export
+func failureToleranceExceeded(policy raczylocomv1.FailurePolicy, status *raczylocomv1.ClusterImageExportStatus) bool {
+	switch policy.Type {
+	case shared.FAILURE_POLICY_CONTINUE:
+		return false
+	case shared.FAILURE_POLICY_THRESHOLD:
+		return status.Failed*100 > policy.Threshold*status.Total
+	default:
+		return status.Failed > 0
+	}
+}
+
+// exportResult returns the final state of the export with all the images finished
+func exportResult(clusterImageExport *raczylocomv1.ClusterImageExport, message string) (string, string, string) {
+	status := clusterImageExport.Status
+	if status.Failed > 0 {
+		return shared.STATUS_COMPLETED_WITH_ERRORS, shared.REASON_COMPLETED_ERRORS,
+			fmt.Sprintf("%d of %d images failed to export: %s", status.Failed, status.Total, strings.Join(status.FailedImages, ", "))
+	}
+	return shared.STATUS_SUCCESS, shared.REASON_COMPLETED, message
+}
+
+// setCondition records the condition observed for the current generation of the export
+func setCondition(clusterImageExport *raczylocomv1.ClusterImageExport, conditionType string, status metav1.ConditionStatus, reason string, message string) {
+	clusterImageExport.Status.ObservedGeneration = clusterImageExport.Generation
+	meta.SetStatusCondition(&clusterImageExport.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: clusterImageExport.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// finishExport completes the export, the result is reported with the event and the metrics
+// once the status is stored, so the conflicting update doesn't report it twice
+func (r *ClusterImageExportReconciler) finishExport(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport, progress string, reason string, message string) error {
+	completeExport(clusterImageExport, progress, reason, message)
+	if err := r.Status().Update(ctx, clusterImageExport); err != nil {
+		return err
+	}
+	r.forgetRegistryClient(clusterImageExport)
+	recordExportFinished(clusterImageExport)
+	if progress == shared.STATUS_SUCCESS {
+		r.Recorder.Event(clusterImageExport, corev1.EventTypeNormal, reason, message)
+	} else {
+		r.Recorder.Event(clusterImageExport, corev1.EventTypeWarning, reason, message)
+	}
+	return nil
+}
+
+// completeExport moves the export into its final state, Ready on success and Degraded on failure.
+// Export completed with errors is both Ready and Degraded.
+func completeExport(clusterImageExport *raczylocomv1.ClusterImageExport, progress string, reason string, message string) {
+	completionTime := metav1.Now()
+	clusterImageExport.Status.Progress = progress
+	clusterImageExport.Status.CompletionTime = &completionTime
+	setCondition(clusterImageExport, shared.CONDITION_EXPORTING, metav1.ConditionFalse, reason, message)
+	if progress == shared.STATUS_FAILED {
+		setCondition(clusterImageExport, shared.CONDITION_READY, metav1.ConditionFalse, reason, message)
+	} else {
+		setCondition(clusterImageExport, shared.CONDITION_READY, metav1.ConditionTrue, reason, message)
+	}
+	if progress == shared.STATUS_SUCCESS {
+		setCondition(clusterImageExport, shared.CONDITION_DEGRADED, metav1.ConditionFalse, reason, message)
+	} else {
+		setCondition(clusterImageExport, shared.CONDITION_DEGRADED, metav1.ConditionTrue, reason, message)
+	}
+}
+
+// retryFailedImages queues the failed images of the export again and resumes the finished export.
+// Images which were already exported keep their artifacts, so only the failed ones are exported again.
+func (r *ClusterImageExportReconciler) retryFailedImages(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport, retry string) error {
+	clusterImageList := &raczylocomv1.ClusterImageList{}
+	if err := r.List(ctx, clusterImageList, client.InNamespace(clusterImageExport.Namespace), client.MatchingFields{"spec.exportName": clusterImageExport.Name}); err != nil {
+		return err
+	}
+
+	failed := 0
+	for i := range clusterImageList.Items {
+		clusterImage := &clusterImageList.Items[i]
+		if clusterImage.Status.Progress != shared.STATUS_FAILED {
+			continue
+		}
+		resetFailedImage(&clusterImage.Status)
+		if err := r.Status().Update(ctx, clusterImage); err != nil {
+			return err
+		}
+		failed++
+	}
+
+	clusterImageExport.Status.LastRetry = retry
+	if shared.ExportFinished(clusterImageExport.Status.Progress) && (failed > 0 || clusterImageExport.Status.Progress == shared.STATUS_FAILED) {
+		message := fmt.Sprintf("Retrying %d failed images", failed)
+		if failed == 0 {
+			message = "Restarting the failed export"
+		}
+		resumeExport(clusterImageExport, message)
+		r.Recorder.Event(clusterImageExport, corev1.EventTypeNormal, shared.EVENT_RESUMED, message)
+	}
+	return r.Status().Update(ctx, clusterImageExport)
+}
+
+// resetFailedImage queues the failed image again with the full retry budget, the history of its attempts is kept
+func resetFailedImage(status *raczylocomv1.ClusterImageStatus) {
+	status.Progress = shared.STATUS_PENDING
+	status.RetryCount = 0
+	status.NextRetryTime = nil
+	status.ExitCode = 0
+	status.LastError = ""
+	status.FailureClass = ""
+}
+
+// resumeExport moves the finished export back to RUNNING. Export which failed before its images were discovered
+// has nothing to resume, it starts over from the validation of its spec.
+func resumeExport(clusterImageExport *raczylocomv1.ClusterImageExport, message string) {
+	clusterImageExport.Status.Progress = shared.STATUS_RUNNING
+	if !meta.IsStatusConditionTrue(clusterImageExport.Status.Conditions, shared.CONDITION_DISCOVERED) {
+		clusterImageExport.Status.Progress = ""
+	}
+	startTime := metav1.Now()
+	clusterImageExport.Status.StartTime = &startTime
+	clusterImageExport.Status.CompletionTime = nil
+	setCondition(clusterImageExport, shared.CONDITION_EXPORTING, metav1.ConditionTrue, shared.REASON_RESUMED, message)
+	setCondition(clusterImageExport, shared.CONDITION_READY, metav1.ConditionFalse, shared.REASON_RESUMED, message)
+	setCondition(clusterImageExport, shared.CONDITION_DEGRADED, metav1.ConditionFalse, shared.REASON_RESUMED, message)
+}
+
+// resolveBaseline returns the name of the export to compare against. The images themselves are matched
+// against the manifest stored by the baseline export, so it works even when its ClusterImages are gone.
+func (r *ClusterImageExportReconciler) resolveBaseline(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport) (string, error) {
+	l := log.FromContext(ctx)
+
+	if clusterImageExport.Spec.Baseline != "" && clusterImageExport.Spec.Storage.StorageTarget == shared.STORAGE_REGISTRY {
+		l.Info("Baseline is not used with the registry target, images already present in the registry are not copied again")
+		return "", nil
+	}
+
+	if clusterImageExport.Spec.Baseline != shared.BASELINE_LATEST {
+		return clusterImageExport.Spec.Baseline, nil
+	}
+
+	exportsList := &raczylocomv1.ClusterImageExportList{}
+	if err := r.List(ctx, exportsList, client.InNamespace(clusterImageExport.Namespace)); err != nil {
+		return "", err
+	}
+
+	var latest *raczylocomv1.ClusterImageExport
+	for i := range exportsList.Items {
+		export := &exportsList.Items[i]
+		// Manifest of the export completed with errors lists only the exported images, so it's a valid baseline too
+		if export.Name == clusterImageExport.Name || !export.DeletionTimestamp.IsZero() || (export.Status.Progress != shared.STATUS_SUCCESS && export.Status.Progress != shared.STATUS_COMPLETED_WITH_ERRORS) {
+			continue
 		}
+		// Baseline has to live in the same storage to be of any use
+		if export.Spec.Storage.StorageTarget != clusterImageExport.Spec.Storage.StorageTarget ||
+			export.Spec.Storage.S3.Bucket != clusterImageExport.Spec.Storage.S3.Bucket ||
+			export.Spec.BasePath != clusterImageExport.Spec.BasePath {
+			continue
+		}
+		if latest == nil || latest.CreationTimestamp.Before(&export.CreationTimestamp) {
+			latest = export
+		}
+	}
+
+	if latest == nil {
+		l.Info("No completed export found to be used as the baseline, exporting all images")
+		return "", nil
+	}
+	return latest.Name, nil
+}
+
+// resolveImageDigests pins the images referenced by tag to the digest the tag points to at the time of discovery.
+// Digest is resolved once, before the ClusterImage is created. Images which already have the ClusterImage in this
+// export keep its digest, so the tags moving during the export do not create the duplicates.
+func (r *ClusterImageExportReconciler) resolveImageDigests(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport, imagesList *shared.ContainersList) error {
+	l := log.FromContext(ctx)
+
+	clusterImageList := &raczylocomv1.ClusterImageList{}
+	if err := r.List(ctx, clusterImageList, client.InNamespace(clusterImageExport.Namespace), client.MatchingFields{"spec.exportName": clusterImageExport.Name}); err != nil {
+		return err
 	}
+	// Images exported by the tag because their digest couldn't be resolved are not asked about again
+	knownDigests := map[string]string{}
+	for _, ci := range clusterImageList.Items {
+		knownDigests[ci.Spec.FullName] = ci.Spec.Sha
+	}
+
+	var registryClient *shared.RegistryClient
+	for i, container := range imagesList.Containers {
+		if container.Sha != "" {
+			continue
+		}
+		if sha, ok := knownDigests[container.FullName]; ok {
+			imagesList.Containers[i].Sha = sha
+			continue
+		}
 
-	return true, nil
+		if registryClient == nil {
+			var err error
+			if registryClient, err = r.exportRegistryClient(ctx, clusterImageExport); err != nil {
+				return err
+			}
+		}
+		sha, err := registryClient.ResolveDigest(ctx, container.Image, container.Tag)
+		if err != nil {
+			// Image is still exported by the tag, the digest will be recorded by the worker
+			l.Error(err, "unable to resolve image digest", "image", container.FullName)
+			continue
+		}
+		imagesList.Containers[i].Sha = sha
+	}
+	return nil
+}
+
+// exportRegistryClient returns the registry client of the export, created with the credentials
+// of its image pull secrets on the first use
+func (r *ClusterImageExportReconciler) exportRegistryClient(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport) (*shared.RegistryClient, error) {
+	r.registryClientsMutex.Lock()
+	defer r.registryClientsMutex.Unlock()
+	if registryClient, ok := r.registryClients[clusterImageExport.UID]; ok {
+		return registryClient, nil
+	}
+
+	auths, err := registryAuths(ctx, r.Client, clusterImageExport.Namespace, clusterImageExport.Spec.ImagePullSecrets)
+	if err != nil {
+		return nil, err
+	}
+	if r.registryClients == nil {
+		r.registryClients = map[types.UID]*shared.RegistryClient{}
+	}
+	r.registryClients[clusterImageExport.UID] = shared.NewRegistryClient(auths)
+	return r.registryClients[clusterImageExport.UID], nil
+}
+
+// forgetRegistryClient drops the registry client of the finished or deleted export
+func (r *ClusterImageExportReconciler) forgetRegistryClient(clusterImageExport *raczylocomv1.ClusterImageExport) {
+	r.registryClientsMutex.Lock()
+	defer r.registryClientsMutex.Unlock()
+	delete(r.registryClients, clusterImageExport.UID)
+}
+
+// registryAuths collects the registry credentials from the image pull secrets in the namespace
+func registryAuths(ctx context.Context, c client.Reader, namespace string, pullSecrets []corev1.LocalObjectReference) (map[string]shared.RegistryAuth, error) {
+	auths := map[string]shared.RegistryAuth{}
+	for _, pullSecret := range pullSecrets {
+		secret := &corev1.Secret{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: pullSecret.Name}, secret); err != nil {
+			return nil, err
+		}
+		data, ok := secret.Data[corev1.DockerConfigJsonKey]
+		if !ok {
+			continue
+		}
+		secretAuths, err := shared.ParseDockerConfig(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid docker config in secret %s: %w", pullSecret.Name, err)
+		}
+		for registry, auth := range secretAuths {
+			auths[registry] = auth
+		}
+	}
+	return auths, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -205,16 +869,15 @@ func (r *ClusterImageExportReconciler) SetupWithManager(mgr ctrl.Manager) error
 
 func (r *ClusterImageExportReconciler) listImagesInCluster(ctx context.Context, l logr.Logger, clusterImageExport *raczylocomv1.ClusterImageExport) (shared.ContainersList, error) {
 	containersList := shared.ContainersList{}
-	if err := shared.ListAndProcessResources[*shared.DeploymentWrapper](ctx, r.Client, &appsv1.DeploymentList{}, &containersList); err != nil {
-		return shared.ContainersList{}, err
-	}
-	if err := shared.ListAndProcessResources[*shared.JobWrapper](ctx, r.Client, &batchv1.JobList{}, &containersList); err != nil {
-		return shared.ContainersList{}, err
-	}
-	if err := shared.ListAndProcessResources[*shared.DaemonSetWrapper](ctx, r.Client, &appsv1.DaemonSetList{}, &containersList); err != nil {
-		return shared.ContainersList{}, err
+	opts, err := shared.SelectorListOptions(clusterImageExport.Spec.WorkloadSelector)
+	if err != nil {
+		return shared.ContainersList{}, fmt.Errorf("invalid workload selector: %w", err)
 	}
-	if err := shared.ListAndProcessResources[*shared.CronJobWrapper](ctx, r.Client, &batchv1.CronJobList{}, &containersList); err != nil {
+	if clusterImageExport.Spec.Source == shared.SOURCE_RUNNING {
+		if err := shared.ListRunningContainers(ctx, r.Client, &containersList, opts...); err != nil {
+			return shared.ContainersList{}, err
+		}
+	} else if err := r.listWorkloadImages(ctx, clusterImageExport, &containersList, opts...); err != nil {
 		return shared.ContainersList{}, err
 	}
 
@@ -226,30 +889,109 @@ func (r *ClusterImageExportReconciler) listImagesInCluster(ctx context.Context,
 		containersList = shared.RemoveExcludedImages(containersList, clusterImageExport.Spec.Excludes)
 	}
 
-	if len(clusterImageExport.Spec.Namespaces) > 0 {
-		containersList = shared.FilterOnlyFromNamespaces(containersList, clusterImageExport.Spec.Namespaces)
+	containersList, err = shared.FilterImages(containersList, clusterImageExport.Spec.Filters)
+	if err != nil {
+		return shared.ContainersList{}, fmt.Errorf("invalid image filters: %w", err)
+	}
+
+	// Listed namespaces and the namespaces matching the selector are exported together
+	if len(clusterImageExport.Spec.Namespaces) > 0 || clusterImageExport.Spec.NamespaceSelector != nil {
+		namespaces := append([]string{}, clusterImageExport.Spec.Namespaces...)
+		if selector := clusterImageExport.Spec.NamespaceSelector; selector != nil {
+			selected, err := shared.SelectNamespaces(ctx, r.Client, selector)
+			if err != nil {
+				return shared.ContainersList{}, err
+			}
+			for _, namespace := range selected {
+				if !slices.Contains(namespaces, namespace) {
+					namespaces = append(namespaces, namespace)
+				}
+			}
+		}
+		containersList = shared.FilterOnlyFromNamespaces(containersList, namespaces)
 	}
 
 	if len(clusterImageExport.Spec.ExcludedNamespaces) > 0 {
 		containersList = shared.FilterOutWholeNamespaces(containersList, clusterImageExport.Spec.ExcludedNamespaces)
 	}
 
+	if selector := clusterImageExport.Spec.ExcludedNamespaceSelector; selector != nil {
+		namespaces, err := shared.SelectNamespaces(ctx, r.Client, selector)
+		if err != nil {
+			return shared.ContainersList{}, err
+		}
+		containersList = shared.FilterOutWholeNamespaces(containersList, namespaces)
+	}
+
 	containersList = shared.RemoveDuplicates(containersList)
 	// l.Info("List of containers in the cluster", "containers", containersList)
 
 	return containersList, nil
 }
 
+// listWorkloadImages discovers the images from the pod specs of the workloads matching the list options
+func (r *ClusterImageExportReconciler) listWorkloadImages(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport, containersList *shared.ContainersList, opts ...client.ListOption) error {
+	if err := shared.ListAndProcessResources[*shared.DeploymentWrapper](ctx, r.Client, &appsv1.DeploymentList{}, containersList, opts...); err != nil {
+		return err
+	}
+	if err := shared.ListAndProcessResources[*shared.JobWrapper](ctx, r.Client, &batchv1.JobList{}, containersList, opts...); err != nil {
+		return err
+	}
+	if err := shared.ListAndProcessResources[*shared.DaemonSetWrapper](ctx, r.Client, &appsv1.DaemonSetList{}, containersList, opts...); err != nil {
+		return err
+	}
+	if err := shared.ListAndProcessResources[*shared.CronJobWrapper](ctx, r.Client, &batchv1.CronJobList{}, containersList, opts...); err != nil {
+		return err
+	}
+	if err := shared.ListAndProcessResources[*shared.StatefulSetWrapper](ctx, r.Client, &appsv1.StatefulSetList{}, containersList, opts...); err != nil {
+		return err
+	}
+	if err := shared.ListAndProcessResources[*shared.ReplicaSetWrapper](ctx, r.Client, &appsv1.ReplicaSetList{}, containersList, opts...); err != nil {
+		return err
+	}
+	// Bare pods and the pods of the workloads not known to the operator
+	if err := shared.ListAndProcessResources[*shared.PodWrapper](ctx, r.Client, &corev1.PodList{}, containersList, opts...); err != nil {
+		return err
+	}
+	// Broken custom workload is reported and skipped, so it doesn't stop the discovery of the rest
+	for _, workload := range clusterImageExport.Spec.CustomWorkloads {
+		if err := shared.ListAndProcessCustomResources(ctx, r.Client, workload, containersList, opts...); err != nil {
+			workloadErr, ok := err.(*shared.CustomWorkloadError)
+			if !ok {
+				return err
+			}
+			log.FromContext(ctx).Error(err, "skipping custom workload", "apiVersion", workload.APIVersion, "kind", workload.Kind)
+			r.Recorder.Eventf(clusterImageExport, corev1.EventTypeWarning, shared.EVENT_WORKLOAD_SKIPPED, "Skipped custom workload %s %s: %v", workload.APIVersion, workload.Kind, workloadErr.Err)
+		}
+	}
+	return nil
+}
+
 func (r *ClusterImageExportReconciler) handleDeletion(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport) (ctrl.Result, error) {
 	l := log.FromContext(ctx)
 
 	if controllerutil.ContainsFinalizer(clusterImageExport, clusterImageExportFinalizer) {
+		// Manifests of the exports using this one as the baseline point at its stored images, keep them until those exports are gone
+		users, err := baselineUsers(ctx, r.Client, clusterImageExport.Namespace)
+		if err != nil {
+			l.Error(err, "unable to list the exports using the baseline")
+			return ctrl.Result{}, err
+		}
+		if names := users[clusterImageExport.Name]; len(names) > 0 {
+			l.Info("Export is still used as the baseline, postponing the cleanup", "exports", names)
+			r.Recorder.Eventf(clusterImageExport, corev1.EventTypeWarning, shared.EVENT_BASELINE_IN_USE, "Cleanup postponed, export is the baseline of %s", strings.Join(names, ", "))
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+
 		// Run the cleanup job
 		if err := r.runCleanupJob(ctx, clusterImageExport); err != nil {
 			l.Error(err, "Failed to run cleanup job")
 			return ctrl.Result{}, err
 		}
 
+		forgetExportMetrics(clusterImageExport)
+		r.forgetRegistryClient(clusterImageExport)
+
 		// Remove the finalizer
 		controllerutil.RemoveFinalizer(clusterImageExport, clusterImageExportFinalizer)
 		if err := r.Update(ctx, clusterImageExport); err != nil {
@@ -260,9 +1002,37 @@ func (r *ClusterImageExportReconciler) handleDeletion(ctx context.Context, clust
 	return ctrl.Result{}, nil
 }
 
+// baselineUsers maps the name of every export used as the baseline in the namespace to the names of the exports using it,
+// including the exports being deleted, so the whole chain of baselines stays in place until the last export is removed.
+func baselineUsers(ctx context.Context, c client.Reader, namespace string) (map[string][]string, error) {
+	exportsList := &raczylocomv1.ClusterImageExportList{}
+	if err := c.List(ctx, exportsList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+
+	users := map[string][]string{}
+	for _, export := range exportsList.Items {
+		// Export which hasn't started yet resolves the named baseline later
+		baseline := export.Status.Baseline
+		if baseline == "" && export.Status.Progress == "" && export.Spec.Baseline != shared.BASELINE_LATEST {
+			baseline = export.Spec.Baseline
+		}
+		if baseline == "" || baseline == export.Name {
+			continue
+		}
+		users[baseline] = append(users[baseline], export.Name)
+	}
+	return users, nil
+}
+
 func (r *ClusterImageExportReconciler) runCleanupJob(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport) error {
 	l := log.FromContext(ctx)
 
+	if clusterImageExport.Spec.Storage.StorageTarget == shared.STORAGE_REGISTRY {
+		l.Info("Images mirrored into the registry are kept")
+		return nil
+	}
+
 	normalisedImageName := "cleanup-" + shared.NormalizeImageName(clusterImageExport.Name)
 
 	defaultCommands := []string{}
@@ -275,7 +1045,7 @@ func (r *ClusterImageExportReconciler) runCleanupJob(ctx context.Context, cluste
 		defaultCommands = append(defaultCommands, additionalCommands...)
 	} else if clusterImageExport.Spec.Storage.StorageTarget == shared.STORAGE_FILE {
 		additionalCommands := []string{
-			"./cleanup.py" + "'" + clusterImageExport.Spec.BasePath + "/" + clusterImageExport.ObjectMeta.Name + "/'",
+			"./cleanup.py '" + shared.StorageLocation(clusterImageExport.Spec.Storage, clusterImageExport.Spec.BasePath, clusterImageExport.Name) + "/'",
 		}
 		defaultCommands = append(defaultCommands, additionalCommands...)
 	}
@@ -286,6 +1056,8 @@ func (r *ClusterImageExportReconciler) runCleanupJob(ctx context.Context, cluste
 		Image:            shared.BACKUP_JOB_IMAGE,
 		Commands:         defaultCommands,
 		Annotations:      clusterImageExport.Spec.JobAnnotations,
+		EnvVars:          shared.StorageEnvVars(clusterImageExport.Spec.Storage),
+		Storage:          &clusterImageExport.Spec.Storage,
 		ServiceAccount:   os.Getenv("POD_SERVICE_ACCOUNT"),
 		ImagePullSecrets: clusterImageExport.Spec.ImagePullSecrets,
 	}
@@ -298,11 +1070,17 @@ func (r *ClusterImageExportReconciler) runCleanupJob(ctx context.Context, cluste
 	}
 
 	l.Info("Created cleanup job")
+	r.Recorder.Eventf(clusterImageExport, corev1.EventTypeNormal, shared.EVENT_CLEANUP_STARTED, "Removing the stored images with job %s", cleanupJob.Name)
 
+	// Export is gone once the finalizer is removed, the events refer to its copy
+	exportRef := clusterImageExport.DeepCopy()
 	go func() {
 		if err := r.waitForJobCompletionAndDelete(ctx, cleanupJob); err != nil {
 			l.Error(err, "Failed to wait for job completion and delete")
+			r.Recorder.Eventf(exportRef, corev1.EventTypeWarning, shared.EVENT_CLEANUP_FAILED, "Removing the stored images failed: %v", err)
+			return
 		}
+		r.Recorder.Eventf(exportRef, corev1.EventTypeNormal, shared.EVENT_CLEANUP_COMPLETED, "Stored images removed by job %s", cleanupJob.Name)
 	}()
 	return nil
 }
@@ -18,16 +18,32 @@ package raczylocom
 
 import (
 	"context"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+	shared "github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
 )
 
 var _ = Describe("ClusterImageExport Controller", func() {
@@ -69,8 +85,9 @@ var _ = Describe("ClusterImageExport Controller", func() {
 		It("should successfully reconcile the resource", func() {
 			By("Reconciling the created resource")
 			controllerReconciler := &ClusterImageExportReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
 			}
 
 			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
@@ -81,4 +98,500 @@ var _ = Describe("ClusterImageExport Controller", func() {
 			// Example: If you expect a certain status condition after reconciliation, verify it here.
 		})
 	})
+
+	Context("When summarising the ClusterImages", func() {
+		clusterImage := func(fullName string, progress string) raczylocomv1.ClusterImage {
+			return raczylocomv1.ClusterImage{
+				Spec:   raczylocomv1.ClusterImageSpec{FullName: fullName},
+				Status: raczylocomv1.ClusterImageStatus{Progress: progress},
+			}
+		}
+
+		It("should count the images by their progress", func() {
+			status := &raczylocomv1.ClusterImageExportStatus{Failed: 3}
+			Expect(countImages(status, []raczylocomv1.ClusterImage{
+				clusterImage("nginx:1.27", shared.STATUS_SUCCESS),
+				clusterImage("redis:7", shared.STATUS_FAILED),
+				clusterImage("busybox:1.36", shared.STATUS_PRESENT),
+				clusterImage("alpine:3.20", shared.STATUS_RUNNING),
+				clusterImage("postgres:16", ""),
+			})).To(BeFalse())
+			Expect(status.Total).To(Equal(5))
+			Expect([]int{status.Pending, status.Running, status.Succeeded, status.Present, status.Failed}).To(Equal([]int{1, 1, 1, 1, 1}))
+			Expect(status.FailedImages).To(Equal([]string{"redis:7"}))
+
+			Expect(countImages(status, []raczylocomv1.ClusterImage{
+				clusterImage("nginx:1.27", shared.STATUS_SUCCESS),
+				clusterImage("busybox:1.36", shared.STATUS_PRESENT),
+			})).To(BeTrue())
+			Expect(status.FailedImages).To(BeEmpty())
+		})
+
+		It("should set the conditions of the completed export", func() {
+			clusterImageExport := &raczylocomv1.ClusterImageExport{ObjectMeta: metav1.ObjectMeta{Generation: 2}}
+			setCondition(clusterImageExport, shared.CONDITION_EXPORTING, metav1.ConditionTrue, shared.REASON_EXPORTING, "")
+			completeExport(clusterImageExport, shared.STATUS_FAILED, shared.REASON_IMAGE_FAILED, "1 of 5 images failed to export")
+
+			Expect(clusterImageExport.Status.Progress).To(Equal(shared.STATUS_FAILED))
+			Expect(clusterImageExport.Status.ObservedGeneration).To(Equal(int64(2)))
+			Expect(clusterImageExport.Status.CompletionTime).NotTo(BeNil())
+			Expect(meta.IsStatusConditionTrue(clusterImageExport.Status.Conditions, shared.CONDITION_DEGRADED)).To(BeTrue())
+			Expect(meta.IsStatusConditionFalse(clusterImageExport.Status.Conditions, shared.CONDITION_READY)).To(BeTrue())
+			Expect(meta.IsStatusConditionFalse(clusterImageExport.Status.Conditions, shared.CONDITION_EXPORTING)).To(BeTrue())
+		})
+
+		It("should fail the export only beyond the tolerance of its failure policy", func() {
+			status := &raczylocomv1.ClusterImageExportStatus{Total: 10, Failed: 1}
+			Expect(failureToleranceExceeded(raczylocomv1.FailurePolicy{}, status)).To(BeTrue())
+			Expect(failureToleranceExceeded(raczylocomv1.FailurePolicy{Type: shared.FAILURE_POLICY_FAIL_FAST}, status)).To(BeTrue())
+			Expect(failureToleranceExceeded(raczylocomv1.FailurePolicy{Type: shared.FAILURE_POLICY_CONTINUE}, status)).To(BeFalse())
+
+			threshold := raczylocomv1.FailurePolicy{Type: shared.FAILURE_POLICY_THRESHOLD, Threshold: 20}
+			Expect(failureToleranceExceeded(threshold, status)).To(BeFalse())
+			status.Failed = 2
+			Expect(failureToleranceExceeded(threshold, status)).To(BeFalse())
+			status.Failed = 3
+			Expect(failureToleranceExceeded(threshold, status)).To(BeTrue())
+
+			Expect(failureToleranceExceeded(raczylocomv1.FailurePolicy{}, &raczylocomv1.ClusterImageExportStatus{Total: 10})).To(BeFalse())
+		})
+
+		It("should complete the export with the tolerated failures as ready and degraded", func() {
+			clusterImageExport := &raczylocomv1.ClusterImageExport{Status: raczylocomv1.ClusterImageExportStatus{
+				Total: 3, Succeeded: 2, Failed: 1, FailedImages: []string{"redis:7"},
+			}}
+			progress, reason, message := exportResult(clusterImageExport, "All images are exported and the manifest is written")
+			Expect(progress).To(Equal(shared.STATUS_COMPLETED_WITH_ERRORS))
+			Expect(reason).To(Equal(shared.REASON_COMPLETED_ERRORS))
+			Expect(message).To(Equal("1 of 3 images failed to export: redis:7"))
+
+			completeExport(clusterImageExport, progress, reason, message)
+			Expect(shared.ExportFinished(clusterImageExport.Status.Progress)).To(BeTrue())
+			Expect(meta.IsStatusConditionTrue(clusterImageExport.Status.Conditions, shared.CONDITION_READY)).To(BeTrue())
+			Expect(meta.IsStatusConditionTrue(clusterImageExport.Status.Conditions, shared.CONDITION_DEGRADED)).To(BeTrue())
+
+			clusterImageExport.Status.Failed = 0
+			progress, _, _ = exportResult(clusterImageExport, "All images are exported and the manifest is written")
+			Expect(progress).To(Equal(shared.STATUS_SUCCESS))
+		})
+
+		It("should resume the export with the failed images queued again", func() {
+			startTime := metav1.NewTime(time.Now().Add(-time.Hour))
+			clusterImageExport := &raczylocomv1.ClusterImageExport{Status: raczylocomv1.ClusterImageExportStatus{StartTime: &startTime}}
+			setCondition(clusterImageExport, shared.CONDITION_DISCOVERED, metav1.ConditionTrue, shared.REASON_DISCOVERED, "Discovered 5 images")
+			completeExport(clusterImageExport, shared.STATUS_FAILED, shared.REASON_IMAGE_FAILED, "1 of 5 images failed to export")
+			resumeExport(clusterImageExport, "Retrying 1 failed images")
+			Expect(clusterImageExport.Status.Progress).To(Equal(shared.STATUS_RUNNING))
+			Expect(clusterImageExport.Status.StartTime.After(startTime.Time)).To(BeTrue())
+			Expect(clusterImageExport.Status.CompletionTime).To(BeNil())
+			Expect(meta.IsStatusConditionFalse(clusterImageExport.Status.Conditions, shared.CONDITION_READY)).To(BeTrue())
+			Expect(meta.IsStatusConditionFalse(clusterImageExport.Status.Conditions, shared.CONDITION_DEGRADED)).To(BeTrue())
+
+			nextRetryTime := metav1.Now()
+			status := &raczylocomv1.ClusterImageStatus{
+				Progress:      shared.STATUS_FAILED,
+				RetryCount:    3,
+				NextRetryTime: &nextRetryTime,
+				LastError:     "Error: unauthorized",
+				FailureClass:  shared.FAILURE_UNAUTHORIZED,
+				Attempts:      []raczylocomv1.ClusterImageAttempt{{JobName: "img-export-abc", Result: shared.STATUS_FAILED}},
+			}
+			resetFailedImage(status)
+			Expect(status.Progress).To(Equal(shared.STATUS_PENDING))
+			Expect(status.RetryCount).To(BeZero())
+			Expect(status.NextRetryTime).To(BeNil())
+			Expect(status.LastError).To(BeEmpty())
+			Expect(status.Attempts).To(HaveLen(1))
+		})
+
+		It("should start over the export failed before its images were discovered", func() {
+			clusterImageExport := &raczylocomv1.ClusterImageExport{}
+			completeExport(clusterImageExport, shared.STATUS_FAILED, shared.REASON_INVALID_SPEC, "Invalid export: spec.storage.s3.bucket: Required value")
+			resumeExport(clusterImageExport, "Restarting the failed export")
+			Expect(clusterImageExport.Status.Progress).To(BeEmpty())
+			Expect(clusterImageExport.Status.StartTime).NotTo(BeNil())
+			Expect(clusterImageExport.Status.CompletionTime).To(BeNil())
+		})
+
+		It("should report the result of the export with the event", func() {
+			failedExport := &raczylocomv1.ClusterImageExport{ObjectMeta: metav1.ObjectMeta{Name: "backup-failed", Namespace: "default"}}
+			completedExport := &raczylocomv1.ClusterImageExport{ObjectMeta: metav1.ObjectMeta{Name: "backup-completed", Namespace: "default"}}
+			scheme := runtime.NewScheme()
+			Expect(raczylocomv1.AddToScheme(scheme)).To(Succeed())
+			recorder := record.NewFakeRecorder(10)
+			controllerReconciler := &ClusterImageExportReconciler{
+				Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(failedExport, completedExport).WithStatusSubresource(failedExport, completedExport).Build(),
+				Recorder: recorder,
+			}
+			Expect(controllerReconciler.finishExport(context.Background(), failedExport, shared.STATUS_FAILED, shared.REASON_MANIFEST_FAILED, "Manifest job manifest-backup failed")).To(Succeed())
+			Expect(controllerReconciler.finishExport(context.Background(), completedExport, shared.STATUS_SUCCESS, shared.REASON_COMPLETED, "All images are exported")).To(Succeed())
+
+			Expect(recorder.Events).To(Receive(Equal("Warning ManifestFailed Manifest job manifest-backup failed")))
+			Expect(recorder.Events).To(Receive(Equal("Normal ExportCompleted All images are exported")))
+			stored := &raczylocomv1.ClusterImageExport{}
+			Expect(controllerReconciler.Get(context.Background(), types.NamespacedName{Name: "backup-failed", Namespace: "default"}, stored)).To(Succeed())
+			Expect(stored.Status.Progress).To(Equal(shared.STATUS_FAILED))
+		})
+
+		It("should report the finished export only once its status is stored", func() {
+			scheme := runtime.NewScheme()
+			Expect(raczylocomv1.AddToScheme(scheme)).To(Succeed())
+			recorder := record.NewFakeRecorder(10)
+			controllerReconciler := &ClusterImageExportReconciler{
+				Client:   fake.NewClientBuilder().WithScheme(scheme).Build(),
+				Recorder: recorder,
+			}
+			clusterImageExport := &raczylocomv1.ClusterImageExport{
+				ObjectMeta: metav1.ObjectMeta{Name: "backup-missing", Namespace: "metrics"},
+				Spec:       raczylocomv1.ClusterImageExportSpec{Storage: raczylocomv1.ClusterImageStorageSpec{StorageTarget: shared.STORAGE_S3}},
+			}
+			finished := exportsFinished.WithLabelValues("metrics", shared.STORAGE_S3, shared.STATUS_SUCCESS)
+			before := testutil.ToFloat64(finished)
+
+			Expect(controllerReconciler.finishExport(context.Background(), clusterImageExport, shared.STATUS_SUCCESS, shared.REASON_COMPLETED, "All images are exported")).NotTo(Succeed())
+			Expect(testutil.ToFloat64(finished)).To(Equal(before))
+			Expect(recorder.Events).NotTo(Receive())
+		})
+	})
+
+	Context("When validating the export", func() {
+		It("should fail the export the admission webhook would reject", func() {
+			scheme := runtime.NewScheme()
+			Expect(raczylocomv1.AddToScheme(scheme)).To(Succeed())
+			clusterImageExport := &raczylocomv1.ClusterImageExport{
+				ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "default"},
+				Spec: raczylocomv1.ClusterImageExportSpec{
+					BasePath:          "/images",
+					MaxConcurrentJobs: 1,
+					Storage: raczylocomv1.ClusterImageStorageSpec{
+						StorageTarget: shared.STORAGE_S3,
+						S3:            raczylocomv1.ClusterImageStorageS3{Bucket: "images", AccessKey: "key", SecretKey: "secret"},
+					},
+				},
+			}
+			recorder := record.NewFakeRecorder(10)
+			k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(clusterImageExport).WithStatusSubresource(clusterImageExport).Build()
+			controllerReconciler := &ClusterImageExportReconciler{
+				Client:                  k8sClient,
+				ForbidInlineCredentials: true,
+				Recorder:                recorder,
+			}
+
+			_, err := controllerReconciler.Reconcile(context.Background(), reconcile.Request{NamespacedName: client.ObjectKeyFromObject(clusterImageExport)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(clusterImageExport), clusterImageExport)).To(Succeed())
+			Expect(clusterImageExport.Status.Progress).To(Equal(shared.STATUS_FAILED))
+			ready := meta.FindStatusCondition(clusterImageExport.Status.Conditions, shared.CONDITION_READY)
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Reason).To(Equal(shared.REASON_INVALID_SPEC))
+			Expect(ready.Message).To(ContainSubstring("inline S3 credentials are forbidden"))
+			Expect(<-recorder.Events).To(HavePrefix("Warning " + shared.REASON_INVALID_SPEC))
+		})
+	})
+
+	Context("When resolving the baseline", func() {
+		created := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+		storage := func(target string, bucket string) raczylocomv1.ClusterImageStorageSpec {
+			return raczylocomv1.ClusterImageStorageSpec{StorageTarget: target, S3: raczylocomv1.ClusterImageStorageS3{Bucket: bucket}}
+		}
+		previousExport := func(name string, age time.Duration, progress string, storage raczylocomv1.ClusterImageStorageSpec, basePath string) *raczylocomv1.ClusterImageExport {
+			return &raczylocomv1.ClusterImageExport{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              name,
+					Namespace:         "default",
+					CreationTimestamp: metav1.NewTime(created.Add(-age)),
+				},
+				Spec:   raczylocomv1.ClusterImageExportSpec{Storage: storage, BasePath: basePath},
+				Status: raczylocomv1.ClusterImageExportStatus{Progress: progress},
+			}
+		}
+		exportWithBaseline := func(baseline string, storage raczylocomv1.ClusterImageStorageSpec) *raczylocomv1.ClusterImageExport {
+			return &raczylocomv1.ClusterImageExport{
+				ObjectMeta: metav1.ObjectMeta{Name: "backup-new", Namespace: "default"},
+				Spec:       raczylocomv1.ClusterImageExportSpec{Storage: storage, BasePath: "/nightly", Baseline: baseline},
+			}
+		}
+
+		DescribeTable("should pick the baseline export",
+			func(clusterImageExport *raczylocomv1.ClusterImageExport, exports []client.Object, expected string) {
+				scheme := runtime.NewScheme()
+				Expect(raczylocomv1.AddToScheme(scheme)).To(Succeed())
+				controllerReconciler := &ClusterImageExportReconciler{
+					Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(exports...).Build(),
+				}
+
+				baseline, err := controllerReconciler.resolveBaseline(context.Background(), clusterImageExport)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(baseline).To(Equal(expected))
+			},
+			Entry("named in the spec",
+				exportWithBaseline("backup-1", storage(shared.STORAGE_S3, "backup")), nil, "backup-1"),
+			Entry("not used with the registry target",
+				exportWithBaseline(shared.BASELINE_LATEST, storage(shared.STORAGE_REGISTRY, "")),
+				[]client.Object{previousExport("backup-1", time.Hour, shared.STATUS_SUCCESS, storage(shared.STORAGE_REGISTRY, ""), "/nightly")}, ""),
+			Entry("as the latest completed export of the same storage",
+				exportWithBaseline(shared.BASELINE_LATEST, storage(shared.STORAGE_S3, "backup")),
+				[]client.Object{
+					previousExport("backup-1", 3*time.Hour, shared.STATUS_SUCCESS, storage(shared.STORAGE_S3, "backup"), "/nightly"),
+					previousExport("backup-2", 2*time.Hour, shared.STATUS_COMPLETED_WITH_ERRORS, storage(shared.STORAGE_S3, "backup"), "/nightly"),
+					previousExport("backup-3", time.Hour, shared.STATUS_FAILED, storage(shared.STORAGE_S3, "backup"), "/nightly"),
+					previousExport("backup-4", time.Hour, shared.STATUS_SUCCESS, storage(shared.STORAGE_S3, "archive"), "/nightly"),
+					previousExport("backup-5", time.Hour, shared.STATUS_SUCCESS, storage(shared.STORAGE_S3, "backup"), "/weekly"),
+					previousExport("backup-6", time.Hour, shared.STATUS_SUCCESS, storage(shared.STORAGE_FILE, "backup"), "/nightly"),
+				}, "backup-2"),
+			Entry("as none without the completed export",
+				exportWithBaseline(shared.BASELINE_LATEST, storage(shared.STORAGE_S3, "backup")),
+				[]client.Object{previousExport("backup-1", time.Hour, shared.STATUS_RUNNING, storage(shared.STORAGE_S3, "backup"), "/nightly")}, ""),
+		)
+	})
+
+	Context("When deleting the export", func() {
+		It("should keep the stored images while the export is used as the baseline", func() {
+			scheme := runtime.NewScheme()
+			Expect(raczylocomv1.AddToScheme(scheme)).To(Succeed())
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			deletionTime := metav1.Now()
+			baselineExport := &raczylocomv1.ClusterImageExport{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "backup-1",
+					Namespace:         "default",
+					Finalizers:        []string{clusterImageExportFinalizer},
+					DeletionTimestamp: &deletionTime,
+				},
+				Spec: raczylocomv1.ClusterImageExportSpec{
+					BasePath: "/images",
+					Storage:  raczylocomv1.ClusterImageStorageSpec{StorageTarget: shared.STORAGE_FILE},
+				},
+				Status: raczylocomv1.ClusterImageExportStatus{Progress: shared.STATUS_SUCCESS},
+			}
+			nextExport := &raczylocomv1.ClusterImageExport{
+				ObjectMeta: metav1.ObjectMeta{Name: "backup-2", Namespace: "default"},
+				Spec:       baselineExport.Spec,
+				Status:     raczylocomv1.ClusterImageExportStatus{Progress: shared.STATUS_SUCCESS, Baseline: "backup-1"},
+			}
+			recorder := record.NewFakeRecorder(10)
+			k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(baselineExport, nextExport).Build()
+			controllerReconciler := &ClusterImageExportReconciler{Client: k8sClient, Recorder: recorder}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(baselineExport)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+			Expect(<-recorder.Events).To(Equal("Warning " + shared.EVENT_BASELINE_IN_USE + " Cleanup postponed, export is the baseline of backup-2"))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(baselineExport), baselineExport)).To(Succeed())
+			Expect(baselineExport.Finalizers).To(ContainElement(clusterImageExportFinalizer))
+			jobs := &v1batch.JobList{}
+			Expect(k8sClient.List(ctx, jobs)).To(Succeed())
+			Expect(jobs.Items).To(BeEmpty())
+
+			Expect(k8sClient.Delete(ctx, nextExport)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(baselineExport)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(baselineExport), baselineExport))).To(BeTrue())
+			Expect(k8sClient.List(ctx, jobs)).To(Succeed())
+			Expect(jobs.Items).To(ConsistOf(MatchFields(IgnoreExtras, Fields{
+				"ObjectMeta": MatchFields(IgnoreExtras, Fields{"Name": Equal("cleanup-backup-1")}),
+			})))
+		})
+	})
+
+	Context("When building the manifest", func() {
+		exportTo := func(name string, bucket string) *raczylocomv1.ClusterImageExport {
+			return &raczylocomv1.ClusterImageExport{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Spec: raczylocomv1.ClusterImageExportSpec{
+					BasePath: "/nightly",
+					Storage:  raczylocomv1.ClusterImageStorageSpec{StorageTarget: shared.STORAGE_S3, S3: raczylocomv1.ClusterImageStorageS3{Bucket: bucket}},
+				},
+			}
+		}
+		imageOf := func(name string, exportName string, fullName string, status raczylocomv1.ClusterImageStatus) *raczylocomv1.ClusterImage {
+			return &raczylocomv1.ClusterImage{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Spec:       raczylocomv1.ClusterImageSpec{FullName: fullName, ExportName: exportName, ExportPath: "/nightly", Storage: shared.STORAGE_S3},
+				Status:     status,
+			}
+		}
+
+		It("should reference the artifacts stored in the same storage only", func() {
+			scheme := runtime.NewScheme()
+			Expect(raczylocomv1.AddToScheme(scheme)).To(Succeed())
+			fakeClient := fake.NewClientBuilder().WithScheme(scheme).
+				WithIndex(&raczylocomv1.ClusterImage{}, "spec.exportName", func(obj client.Object) []string {
+					return []string{obj.(*raczylocomv1.ClusterImage).Spec.ExportName}
+				}).
+				WithObjects(
+					exportTo("backup-a", "backup"),
+					exportTo("backup-b", "backup"),
+					exportTo("archive-a", "archive"),
+					imageOf("a-nginx", "backup-a", "nginx:1.27", raczylocomv1.ClusterImageStatus{Progress: shared.STATUS_PRESENT}),
+					imageOf("a-redis", "backup-a", "redis:7", raczylocomv1.ClusterImageStatus{Progress: shared.STATUS_PRESENT}),
+					imageOf("a-busybox", "backup-a", "busybox:1.36", raczylocomv1.ClusterImageStatus{Progress: shared.STATUS_SUCCESS, Artifact: "s3://backup/nightly/backup-a/busybox-1.36.tar", Size: 10}),
+					imageOf("a-alpine", "backup-a", "alpine:3.20", raczylocomv1.ClusterImageStatus{Progress: shared.STATUS_FAILED}),
+					imageOf("b-nginx", "backup-b", "nginx:1.27", raczylocomv1.ClusterImageStatus{Progress: shared.STATUS_SUCCESS, Artifact: "s3://backup/nightly/backup-b/nginx-1.27.tar", Size: 20}),
+					imageOf("archive-redis", "archive-a", "redis:7", raczylocomv1.ClusterImageStatus{Progress: shared.STATUS_SUCCESS, Artifact: "s3://archive/nightly/archive-a/redis-7.tar", Size: 30}),
+				).Build()
+			controllerReconciler := &ClusterImageExportReconciler{Client: fakeClient}
+
+			manifest, err := controllerReconciler.buildManifest(context.Background(), exportTo("backup-a", "backup"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(manifest.ExportName).To(Equal("backup-a"))
+			Expect(manifest.Images).To(HaveLen(3))
+			Expect(manifest.Images[0]).To(MatchFields(IgnoreExtras, Fields{"FullName": Equal("busybox:1.36"), "Artifact": Equal("s3://backup/nightly/backup-a/busybox-1.36.tar"), "Present": BeFalse()}))
+			Expect(manifest.Images[1]).To(MatchFields(IgnoreExtras, Fields{"FullName": Equal("nginx:1.27"), "Artifact": Equal("s3://backup/nightly/backup-b/nginx-1.27.tar"), "Size": Equal(int64(20)), "Present": BeTrue()}))
+			Expect(manifest.Images[2]).To(MatchFields(IgnoreExtras, Fields{"FullName": Equal("redis:7"), "Artifact": BeEmpty(), "Present": BeTrue()}))
+		})
+
+		It("should fit the manifest into the ConfigMap", func() {
+			clusterImageExport := exportTo("backup-a", "backup")
+			clusterImageExport.Spec.ManifestYAML = true
+			manifest := &shared.ExportManifest{ExportName: "backup-a", Images: []shared.ManifestImage{{FullName: "nginx:1.27", Artifact: "s3://backup/nightly/backup-a/nginx-1.27.tar"}}}
+
+			data, err := manifestData(clusterImageExport, manifest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(HaveKey(shared.MANIFEST_YAML_FILE))
+			Expect(data[shared.MANIFEST_FILE]).To(ContainSubstring("\n  "))
+
+			manifest.Images[0].Artifact = strings.Repeat("a", shared.MANIFEST_CONFIGMAP_MAX_SIZE/2)
+			data, err = manifestData(clusterImageExport, manifest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).NotTo(HaveKey(shared.MANIFEST_YAML_FILE))
+			Expect(data[shared.MANIFEST_FILE]).NotTo(ContainSubstring("\n"))
+
+			manifest.Images = append(manifest.Images, manifest.Images[0], manifest.Images[0])
+			_, err = manifestData(clusterImageExport, manifest)
+			Expect(err).To(BeAssignableToTypeOf(manifestTooLargeError{}))
+		})
+	})
+
+	Context("When discovering the images", func() {
+		namespace := func(name string, labels map[string]string) *corev1.Namespace {
+			return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+		}
+		pod := func(namespace string, image string) *corev1.Pod {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: namespace},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: image}}},
+			}
+		}
+
+		It("should export the listed namespaces together with the selected ones", func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(raczylocomv1.AddToScheme(scheme)).To(Succeed())
+			controllerReconciler := &ClusterImageExportReconciler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+					namespace("listed", nil),
+					namespace("tenant-a", map[string]string{"tenant": "true"}),
+					namespace("tenant-b", map[string]string{"tenant": "true", "environment": "sandbox"}),
+					namespace("other", nil),
+					pod("listed", "nginx:1.27"),
+					pod("tenant-a", "redis:7"),
+					pod("tenant-b", "busybox:1.36"),
+					pod("other", "alpine:3.20"),
+				).Build(),
+			}
+			clusterImageExport := &raczylocomv1.ClusterImageExport{Spec: raczylocomv1.ClusterImageExportSpec{
+				Namespaces:                []string{"listed", "tenant-a"},
+				NamespaceSelector:         &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "true"}},
+				ExcludedNamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"environment": "sandbox"}},
+			}}
+
+			containersList, err := controllerReconciler.listImagesInCluster(context.Background(), GinkgoLogr, clusterImageExport)
+			Expect(err).NotTo(HaveOccurred())
+			images := []string{}
+			for _, container := range containersList.Containers {
+				images = append(images, container.ImageNamespace+"/"+container.FullName)
+			}
+			Expect(images).To(ConsistOf("listed/nginx:1.27", "tenant-a/redis:7"))
+		})
+
+		It("should skip the broken custom workload and discover the rest", func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(raczylocomv1.AddToScheme(scheme)).To(Succeed())
+			recorder := record.NewFakeRecorder(10)
+			controllerReconciler := &ClusterImageExportReconciler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod("default", "nginx:1.27")).
+					WithInterceptorFuncs(interceptor.Funcs{List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+						if _, ok := list.(*unstructured.UnstructuredList); ok {
+							return &meta.NoKindMatchError{GroupKind: schema.GroupKind{Group: "argoproj.io", Kind: "Rollout"}}
+						}
+						return c.List(ctx, list, opts...)
+					}}).Build(),
+				Recorder: recorder,
+			}
+			clusterImageExport := &raczylocomv1.ClusterImageExport{Spec: raczylocomv1.ClusterImageExportSpec{
+				CustomWorkloads: []raczylocomv1.CustomWorkload{
+					{APIVersion: "argoproj.io/v1alpha1", Kind: "Rollout"},
+					{APIVersion: "argoproj.io/v1/alpha1", Kind: "Rollout"},
+				},
+			}}
+
+			containersList, err := controllerReconciler.listImagesInCluster(context.Background(), GinkgoLogr, clusterImageExport)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(containersList.Containers).To(ConsistOf(HaveField("FullName", "nginx:1.27")))
+			Expect(recorder.Events).To(HaveLen(2))
+			Expect(<-recorder.Events).To(HavePrefix("Warning " + shared.EVENT_WORKLOAD_SKIPPED + " Skipped custom workload argoproj.io/v1alpha1 Rollout"))
+		})
+	})
+
+	Context("When resolving the image digests", func() {
+		It("should not ask the registry about the images which already have the digest or the ClusterImage", func() {
+			scheme := runtime.NewScheme()
+			Expect(raczylocomv1.AddToScheme(scheme)).To(Succeed())
+			clusterImage := func(name string, fullName string, sha string) *raczylocomv1.ClusterImage {
+				return &raczylocomv1.ClusterImage{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+					Spec:       raczylocomv1.ClusterImageSpec{ExportName: "backup", FullName: fullName, Sha: sha},
+				}
+			}
+			controllerReconciler := &ClusterImageExportReconciler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).
+					WithIndex(&raczylocomv1.ClusterImage{}, "spec.exportName", func(obj client.Object) []string {
+						return []string{obj.(*raczylocomv1.ClusterImage).Spec.ExportName}
+					}).
+					WithObjects(
+						clusterImage("nginx", "nginx:1.27", "sha256:nginx"),
+						clusterImage("redis", "redis:7", ""),
+					).Build(),
+			}
+			clusterImageExport := &raczylocomv1.ClusterImageExport{
+				ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "default", UID: "backup-uid"},
+				Spec:       raczylocomv1.ClusterImageExportSpec{ResolveDigests: true},
+			}
+			imagesList := &shared.ContainersList{Containers: []shared.Container{
+				{Image: "nginx", Tag: "1.27", FullName: "nginx:1.27"},
+				{Image: "redis", Tag: "7", FullName: "redis:7"},
+				{Image: "alpine", Tag: "3.20", Sha: "sha256:alpine", FullName: "alpine:3.20"},
+			}}
+
+			Expect(controllerReconciler.resolveImageDigests(context.Background(), clusterImageExport, imagesList)).To(Succeed())
+			Expect(imagesList.Containers).To(HaveExactElements(
+				HaveField("Sha", "sha256:nginx"),
+				HaveField("Sha", ""),
+				HaveField("Sha", "sha256:alpine"),
+			))
+			Expect(controllerReconciler.registryClients).To(BeEmpty())
+		})
+
+		It("should reuse the registry client of the export until it's forgotten", func() {
+			scheme := runtime.NewScheme()
+			Expect(raczylocomv1.AddToScheme(scheme)).To(Succeed())
+			controllerReconciler := &ClusterImageExportReconciler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).Build(),
+			}
+			clusterImageExport := &raczylocomv1.ClusterImageExport{
+				ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "default", UID: "backup-uid"},
+			}
+
+			registryClient, err := controllerReconciler.exportRegistryClient(context.Background(), clusterImageExport)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(controllerReconciler.exportRegistryClient(context.Background(), clusterImageExport)).To(BeIdenticalTo(registryClient))
+
+			controllerReconciler.forgetRegistryClient(clusterImageExport)
+			Expect(controllerReconciler.exportRegistryClient(context.Background(), clusterImageExport)).NotTo(BeIdenticalTo(registryClient))
+		})
+	})
 })
@@ -0,0 +1,308 @@
+package raczylocom
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ref "k8s.io/client-go/tools/reference"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+	shared "github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
+)
+
+// ClusterImageExportScheduleReconciler reconciles a ClusterImageExportSchedule object
+type ClusterImageExportScheduleReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Reject the resources with the S3 keys set inline instead of the secret
+	ForbidInlineCredentials bool
+	Recorder                record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=raczylo.com,resources=*,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=raczylo.com,resources=*/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=raczylo.com,resources=*/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// missedSchedulesLimit protects from calculating an endless list of missed runs
+// when the controller was down for a long time or the clock has been skewed.
+const missedSchedulesLimit = 100
+
+func (r *ClusterImageExportScheduleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+
+	schedule := &raczylocomv1.ClusterImageExportSchedule{}
+	if err := r.Get(ctx, req.NamespacedName, schedule); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	childExports := &raczylocomv1.ClusterImageExportList{}
+	if err := r.List(ctx, childExports, client.InNamespace(req.Namespace), client.MatchingFields{shared.SCHEDULE_OWNER_KEY: req.Name}); err != nil {
+		l.Error(err, "unable to list child ClusterImageExports")
+		return ctrl.Result{}, err
+	}
+
+	var activeExports, successfulExports, plannedExports, failedExports []*raczylocomv1.ClusterImageExport
+	for i := range childExports.Items {
+		export := &childExports.Items[i]
+		switch export.Status.Progress {
+		case shared.STATUS_SUCCESS, shared.STATUS_COMPLETED_WITH_ERRORS:
+			successfulExports = append(successfulExports, export)
+		// Planned dry runs are finished, so they don't block the next runs of the schedule, but they export nothing
+		case shared.STATUS_PLANNED:
+			plannedExports = append(plannedExports, export)
+		case shared.STATUS_FAILED:
+			failedExports = append(failedExports, export)
+		default:
+			activeExports = append(activeExports, export)
+		}
+
+		scheduledTime, err := getScheduledTimeForExport(export)
+		if err != nil {
+			l.Error(err, "unable to parse schedule time for child export", "export", export.Name)
+			continue
+		}
+		if scheduledTime != nil {
+			advanceLastScheduleTime(schedule, *scheduledTime)
+		}
+	}
+
+	for _, export := range successfulExports {
+		finishedAt := export.CreationTimestamp
+		if scheduledTime, err := getScheduledTimeForExport(export); err == nil && scheduledTime != nil {
+			finishedAt = metav1.Time{Time: *scheduledTime}
+		}
+		if schedule.Status.LastSuccessfulTime == nil || schedule.Status.LastSuccessfulTime.Before(&finishedAt) {
+			schedule.Status.LastSuccessfulTime = &finishedAt
+		}
+	}
+
+	schedule.Status.Active = nil
+	for _, activeExport := range activeExports {
+		exportRef, err := ref.GetReference(r.Scheme, activeExport)
+		if err != nil {
+			l.Error(err, "unable to make reference to active export", "export", activeExport.Name)
+			continue
+		}
+		schedule.Status.Active = append(schedule.Status.Active, *exportRef)
+	}
+
+	if err := r.Status().Update(ctx, schedule); err != nil {
+		l.Error(err, "unable to update ClusterImageExportSchedule status")
+		return ctrl.Result{}, err
+	}
+
+	// Remove exports exceeding the history limits. Deleting the export runs its cleanup job,
+	// so the stored images of the pruned export are removed as well.
+	if schedule.Spec.FailedExportsHistoryLimit != nil {
+		r.pruneExports(ctx, failedExports, int(*schedule.Spec.FailedExportsHistoryLimit))
+	}
+	if schedule.Spec.SuccessfulExportsHistoryLimit != nil {
+		r.pruneExports(ctx, successfulExports, int(*schedule.Spec.SuccessfulExportsHistoryLimit))
+		r.pruneExports(ctx, plannedExports, int(*schedule.Spec.SuccessfulExportsHistoryLimit))
+	}
+
+	if schedule.Spec.Suspend != nil && *schedule.Spec.Suspend {
+		l.V(1).Info("ClusterImageExportSchedule suspended, skipping")
+		return ctrl.Result{}, nil
+	}
+
+	// Exports of the invalid template would fail right away, the webhook doesn't see them before they are created
+	template, err := r.constructExportForSchedule(schedule, time.Now())
+	if err != nil {
+		l.Error(err, "unable to construct ClusterImageExport from template")
+		return ctrl.Result{}, nil
+	}
+	if _, allErrs := shared.ValidateExport(template, r.ForbidInlineCredentials); len(allErrs) > 0 {
+		err := allErrs.ToAggregate()
+		l.Error(err, "ClusterImageExportSchedule rejected, no exports will be created")
+		r.Recorder.Eventf(schedule, corev1.EventTypeWarning, shared.REASON_INVALID_SPEC, "No exports will be created, invalid export template: %v", err)
+		return ctrl.Result{}, nil
+	}
+
+	now := time.Now()
+	missedRun, nextRun, err := getNextSchedule(schedule, now)
+	if err != nil {
+		l.Error(err, "unable to figure out ClusterImageExportSchedule schedule")
+		// Unparseable schedule won't fix itself until the spec changes, so don't requeue
+		return ctrl.Result{}, nil
+	}
+
+	scheduledResult := ctrl.Result{RequeueAfter: nextRun.Sub(now)}
+	if missedRun.IsZero() {
+		return scheduledResult, nil
+	}
+
+	tooLate := false
+	if schedule.Spec.StartingDeadlineSeconds != nil {
+		tooLate = missedRun.Add(time.Duration(*schedule.Spec.StartingDeadlineSeconds) * time.Second).Before(now)
+	}
+	if tooLate {
+		l.V(1).Info("Missed starting deadline for the last run, waiting for the next one", "missedRun", missedRun)
+		return scheduledResult, nil
+	}
+
+	if schedule.Spec.ConcurrencyPolicy == raczylocomv1.ForbidConcurrent && len(activeExports) > 0 {
+		l.V(1).Info("Concurrency policy blocks concurrent runs, skipping", "active", len(activeExports))
+		return scheduledResult, nil
+	}
+
+	if schedule.Spec.ConcurrencyPolicy == raczylocomv1.ReplaceConcurrent {
+		for _, activeExport := range activeExports {
+			if err := r.Delete(ctx, activeExport, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+				l.Error(err, "unable to delete active export", "export", activeExport.Name)
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	export, err := r.constructExportForSchedule(schedule, missedRun)
+	if err != nil {
+		l.Error(err, "unable to construct ClusterImageExport from template")
+		return scheduledResult, nil
+	}
+
+	if err := r.Create(ctx, export); err != nil && !errors.IsAlreadyExists(err) {
+		l.Error(err, "unable to create ClusterImageExport for ClusterImageExportSchedule", "export", export.Name)
+		return ctrl.Result{}, err
+	} else if err == nil {
+		l.Info("Created ClusterImageExport for ClusterImageExportSchedule run", "export", export.Name)
+	}
+
+	// Recorded right away, the export may be pruned before the next reconcile and the run must not be repeated
+	advanceLastScheduleTime(schedule, missedRun)
+	if err := r.Status().Update(ctx, schedule); err != nil {
+		l.Error(err, "unable to record the last schedule time")
+		return ctrl.Result{}, err
+	}
+	return scheduledResult, nil
+}
+
+// advanceLastScheduleTime records the scheduled run, the last schedule time only ever moves forward.
+// It's kept in the status, the exports it was derived from may be already pruned.
+func advanceLastScheduleTime(schedule *raczylocomv1.ClusterImageExportSchedule, scheduledTime time.Time) {
+	if schedule.Status.LastScheduleTime == nil || schedule.Status.LastScheduleTime.Time.Before(scheduledTime) {
+		schedule.Status.LastScheduleTime = &metav1.Time{Time: scheduledTime}
+	}
+}
+
+func (r *ClusterImageExportScheduleReconciler) pruneExports(ctx context.Context, exports []*raczylocomv1.ClusterImageExport, limit int) {
+	l := log.FromContext(ctx)
+
+	sort.Slice(exports, func(i, j int) bool {
+		return exports[i].CreationTimestamp.Before(&exports[j].CreationTimestamp)
+	})
+
+	var users map[string][]string
+	if len(exports) > limit {
+		var err error
+		if users, err = baselineUsers(ctx, r.Client, exports[0].Namespace); err != nil {
+			l.Error(err, "unable to list the exports using the baseline")
+			return
+		}
+	}
+
+	for i, export := range exports {
+		if len(exports)-i <= limit {
+			break
+		}
+		// Baseline is pruned once the exports using it are gone
+		if names := users[export.Name]; len(names) > 0 {
+			l.V(1).Info("Keeping old export used as the baseline", "export", export.Name, "exports", names)
+			continue
+		}
+		if err := r.Delete(ctx, export, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			l.Error(err, "unable to delete old export", "export", export.Name)
+			continue
+		}
+		l.V(1).Info("Deleted old export", "export", export.Name)
+	}
+}
+
+func (r *ClusterImageExportScheduleReconciler) constructExportForSchedule(schedule *raczylocomv1.ClusterImageExportSchedule, scheduledTime time.Time) (*raczylocomv1.ClusterImageExport, error) {
+	name := fmt.Sprintf("%s-%s", schedule.Name, scheduledTime.UTC().Format("20060102-1504"))
+
+	export := &raczylocomv1.ClusterImageExport{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   schedule.Namespace,
+			Annotations: make(map[string]string),
+		},
+		Spec: *schedule.Spec.ExportTemplate.DeepCopy(),
+	}
+	export.Annotations[shared.SCHEDULED_AT_ANNOTATION] = scheduledTime.Format(time.RFC3339)
+	export.Spec.Name = name
+	export.Spec.CreatedAt = metav1.Time{Time: scheduledTime}
+
+	if err := ctrl.SetControllerReference(schedule, export, r.Scheme); err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+func getScheduledTimeForExport(export *raczylocomv1.ClusterImageExport) (*time.Time, error) {
+	timeRaw := export.GetAnnotations()[shared.SCHEDULED_AT_ANNOTATION]
+	if len(timeRaw) == 0 {
+		return nil, nil
+	}
+
+	timeParsed, err := time.Parse(time.RFC3339, timeRaw)
+	if err != nil {
+		return nil, err
+	}
+	return &timeParsed, nil
+}
+
+// getNextSchedule returns the most recent missed run (zero if none) and the next upcoming run
+func getNextSchedule(schedule *raczylocomv1.ClusterImageExportSchedule, now time.Time) (lastMissed time.Time, next time.Time, err error) {
+	sched, err := cron.ParseStandard(schedule.Spec.Schedule)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("unparseable schedule %q: %w", schedule.Spec.Schedule, err)
+	}
+
+	var earliestTime time.Time
+	if schedule.Status.LastScheduleTime != nil {
+		earliestTime = schedule.Status.LastScheduleTime.Time
+	} else {
+		earliestTime = schedule.ObjectMeta.CreationTimestamp.Time
+	}
+	if schedule.Spec.StartingDeadlineSeconds != nil {
+		schedulingDeadline := now.Add(-time.Second * time.Duration(*schedule.Spec.StartingDeadlineSeconds))
+		if schedulingDeadline.After(earliestTime) {
+			earliestTime = schedulingDeadline
+		}
+	}
+	if earliestTime.After(now) {
+		return time.Time{}, sched.Next(now), nil
+	}
+
+	starts := 0
+	for t := sched.Next(earliestTime); !t.After(now); t = sched.Next(t) {
+		lastMissed = t
+		starts++
+		if starts > missedSchedulesLimit {
+			// Too many missed runs, most likely the clock has been skewed - start from now on
+			return time.Time{}, time.Time{}, fmt.Errorf("too many missed start times (> %d), set or decrease .spec.startingDeadlineSeconds or check clock skew", missedSchedulesLimit)
+		}
+	}
+	return lastMissed, sched.Next(now), nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterImageExportScheduleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&raczylocomv1.ClusterImageExportSchedule{}).
+		Owns(&raczylocomv1.ClusterImageExport{}).
+		Complete(r)
+}
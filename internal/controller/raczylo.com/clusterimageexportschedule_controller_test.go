@@ -0,0 +1,268 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raczylocom
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+	shared "github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
+)
+
+var _ = Describe("ClusterImageExportSchedule Controller", func() {
+	Context("When reconciling a resource", func() {
+		const resourceName = "test-resource"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+		clusterimageexportschedule := &raczylocomv1.ClusterImageExportSchedule{}
+
+		BeforeEach(func() {
+			By("creating the custom resource for the Kind ClusterImageExportSchedule")
+			err := k8sClient.Get(ctx, typeNamespacedName, clusterimageexportschedule)
+			if err != nil && errors.IsNotFound(err) {
+				resource := &raczylocomv1.ClusterImageExportSchedule{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      resourceName,
+						Namespace: "default",
+					},
+					Spec: raczylocomv1.ClusterImageExportScheduleSpec{
+						Schedule: "0 2 * * *",
+						ExportTemplate: raczylocomv1.ClusterImageExportSpec{
+							BasePath:          "/images",
+							MaxConcurrentJobs: 1,
+							Storage: raczylocomv1.ClusterImageStorageSpec{
+								StorageTarget: "S3",
+								S3: raczylocomv1.ClusterImageStorageS3{
+									Bucket: "backup",
+									Region: "us-west-2",
+								},
+							},
+						},
+					},
+				}
+				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			resource := &raczylocomv1.ClusterImageExportSchedule{}
+			err := k8sClient.Get(ctx, typeNamespacedName, resource)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Cleanup the specific resource instance ClusterImageExportSchedule")
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+		})
+		It("should successfully reconcile the resource", func() {
+			By("Reconciling the created resource")
+			controllerReconciler := &ClusterImageExportScheduleReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(10),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("When validating the export template", func() {
+		It("should not create the export the webhook would reject", func() {
+			scheme := runtime.NewScheme()
+			Expect(raczylocomv1.AddToScheme(scheme)).To(Succeed())
+			schedule := &raczylocomv1.ClusterImageExportSchedule{
+				ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "default", CreationTimestamp: metav1.NewTime(time.Now().Add(-48 * time.Hour))},
+				Spec: raczylocomv1.ClusterImageExportScheduleSpec{
+					Schedule: "0 2 * * *",
+					ExportTemplate: raczylocomv1.ClusterImageExportSpec{
+						BasePath:          "images",
+						MaxConcurrentJobs: 1,
+						Storage: raczylocomv1.ClusterImageStorageSpec{
+							StorageTarget: shared.STORAGE_REGISTRY,
+							Registry:      raczylocomv1.ClusterImageStorageRegistry{Registry: "registry.internal"},
+						},
+					},
+				},
+			}
+			recorder := record.NewFakeRecorder(10)
+			k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(schedule).WithStatusSubresource(schedule).
+				WithIndex(&raczylocomv1.ClusterImageExport{}, shared.SCHEDULE_OWNER_KEY, func(client.Object) []string { return nil }).Build()
+			controllerReconciler := &ClusterImageExportScheduleReconciler{Client: k8sClient, Scheme: scheme, Recorder: recorder}
+
+			_, err := controllerReconciler.Reconcile(context.Background(), reconcile.Request{NamespacedName: client.ObjectKeyFromObject(schedule)})
+			Expect(err).NotTo(HaveOccurred())
+			exports := &raczylocomv1.ClusterImageExportList{}
+			Expect(k8sClient.List(context.Background(), exports)).To(Succeed())
+			Expect(exports.Items).To(BeEmpty())
+			event := <-recorder.Events
+			Expect(event).To(HavePrefix("Warning " + shared.REASON_INVALID_SPEC))
+			Expect(event).To(ContainSubstring("spec.basePath"))
+		})
+	})
+
+	Context("When calculating the next schedule", func() {
+		created := time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)
+
+		newSchedule := func() *raczylocomv1.ClusterImageExportSchedule {
+			return &raczylocomv1.ClusterImageExportSchedule{
+				ObjectMeta: metav1.ObjectMeta{
+					CreationTimestamp: metav1.Time{Time: created},
+				},
+				Spec: raczylocomv1.ClusterImageExportScheduleSpec{
+					Schedule: "0 2 * * *",
+				},
+			}
+		}
+
+		It("should return no missed run before the first scheduled time", func() {
+			missed, next, err := getNextSchedule(newSchedule(), created.Add(time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(missed.IsZero()).To(BeTrue())
+			Expect(next).To(Equal(created.Add(2 * time.Hour)))
+		})
+
+		It("should return the most recent missed run", func() {
+			missed, next, err := getNextSchedule(newSchedule(), created.Add(49*time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(missed).To(Equal(created.Add(26 * time.Hour)))
+			Expect(next).To(Equal(created.Add(50 * time.Hour)))
+		})
+
+		It("should not go further back than the starting deadline", func() {
+			schedule := newSchedule()
+			deadline := int64(60)
+			schedule.Spec.StartingDeadlineSeconds = &deadline
+			missed, _, err := getNextSchedule(schedule, created.Add(26*time.Hour+30*time.Second))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(missed).To(Equal(created.Add(26 * time.Hour)))
+		})
+
+		It("should not repeat the run recorded before the exports were pruned", func() {
+			schedule := newSchedule()
+			advanceLastScheduleTime(schedule, created.Add(26*time.Hour))
+			advanceLastScheduleTime(schedule, created.Add(2*time.Hour))
+			Expect(schedule.Status.LastScheduleTime.Time).To(Equal(created.Add(26 * time.Hour)))
+
+			missed, next, err := getNextSchedule(schedule, created.Add(27*time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(missed.IsZero()).To(BeTrue())
+			Expect(next).To(Equal(created.Add(50 * time.Hour)))
+		})
+
+		It("should reject an invalid schedule", func() {
+			schedule := newSchedule()
+			schedule.Spec.Schedule = "not a cron"
+			_, _, err := getNextSchedule(schedule, created)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("When pruning the exports", func() {
+		It("should keep the old export used as the baseline", func() {
+			scheme := runtime.NewScheme()
+			Expect(raczylocomv1.AddToScheme(scheme)).To(Succeed())
+			created := time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)
+			export := func(name string, age time.Duration, baseline string) *raczylocomv1.ClusterImageExport {
+				return &raczylocomv1.ClusterImageExport{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", CreationTimestamp: metav1.NewTime(created.Add(-age))},
+					Status:     raczylocomv1.ClusterImageExportStatus{Progress: shared.STATUS_SUCCESS, Baseline: baseline},
+				}
+			}
+			exports := []*raczylocomv1.ClusterImageExport{
+				export("nightly-1", 3*time.Hour, ""),
+				export("nightly-2", 2*time.Hour, ""),
+				export("nightly-3", time.Hour, "nightly-1"),
+			}
+			k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(exports[0], exports[1], exports[2]).Build()
+			controllerReconciler := &ClusterImageExportScheduleReconciler{Client: k8sClient}
+
+			controllerReconciler.pruneExports(context.Background(), exports, 1)
+			remaining := &raczylocomv1.ClusterImageExportList{}
+			Expect(k8sClient.List(context.Background(), remaining)).To(Succeed())
+			Expect(remaining.Items).To(HaveLen(2))
+			Expect([]string{remaining.Items[0].Name, remaining.Items[1].Name}).To(ConsistOf("nightly-1", "nightly-3"))
+		})
+
+		It("should keep the planned dry runs apart from the successful exports", func() {
+			scheme := runtime.NewScheme()
+			Expect(raczylocomv1.AddToScheme(scheme)).To(Succeed())
+			created := time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)
+			suspend := true
+			limit := int32(1)
+			schedule := &raczylocomv1.ClusterImageExportSchedule{
+				ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "default", UID: "nightly-uid"},
+				Spec:       raczylocomv1.ClusterImageExportScheduleSpec{Schedule: "0 2 * * *", Suspend: &suspend, SuccessfulExportsHistoryLimit: &limit},
+			}
+			export := func(name string, day int, progress string) *raczylocomv1.ClusterImageExport {
+				scheduledAt := created.AddDate(0, 0, day).Add(2 * time.Hour)
+				return &raczylocomv1.ClusterImageExport{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:              name,
+						Namespace:         "default",
+						CreationTimestamp: metav1.NewTime(scheduledAt),
+						Annotations:       map[string]string{shared.SCHEDULED_AT_ANNOTATION: scheduledAt.Format(time.RFC3339)},
+						OwnerReferences: []metav1.OwnerReference{{
+							APIVersion: raczylocomv1.GroupVersion.String(), Kind: "ClusterImageExportSchedule", Name: "nightly", UID: "nightly-uid", Controller: &suspend,
+						}},
+					},
+					Status: raczylocomv1.ClusterImageExportStatus{Progress: progress},
+				}
+			}
+			k8sClient := fake.NewClientBuilder().WithScheme(scheme).
+				WithObjects(schedule,
+					export("nightly-1", 1, shared.STATUS_SUCCESS),
+					export("nightly-2", 2, shared.STATUS_PLANNED),
+					export("nightly-3", 3, shared.STATUS_PLANNED)).
+				WithStatusSubresource(schedule).
+				WithIndex(&raczylocomv1.ClusterImageExport{}, shared.SCHEDULE_OWNER_KEY, func(obj client.Object) []string {
+					owner := metav1.GetControllerOf(obj)
+					if owner == nil {
+						return nil
+					}
+					return []string{owner.Name}
+				}).Build()
+			controllerReconciler := &ClusterImageExportScheduleReconciler{Client: k8sClient, Scheme: scheme}
+
+			_, err := controllerReconciler.Reconcile(context.Background(), reconcile.Request{NamespacedName: client.ObjectKeyFromObject(schedule)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(schedule), schedule)).To(Succeed())
+			Expect(schedule.Status.LastSuccessfulTime.Time).To(BeTemporally("==", created.AddDate(0, 0, 1).Add(2*time.Hour)))
+			remaining := &raczylocomv1.ClusterImageExportList{}
+			Expect(k8sClient.List(context.Background(), remaining)).To(Succeed())
+			Expect([]string{remaining.Items[0].Name, remaining.Items[1].Name}).To(ConsistOf("nightly-1", "nightly-3"))
+		})
+	})
+})
@@ -0,0 +1,543 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raczylocom
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	v1batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+	"github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
+)
+
+// ClusterImageImportReconciler reconciles a ClusterImageImport object
+type ClusterImageImportReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Reject the resources with the S3 keys set inline instead of the secret
+	ForbidInlineCredentials bool
+}
+
+// +kubebuilder:rbac:groups=raczylo.com,resources=clusterimageimports,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=raczylo.com,resources=clusterimageimports/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=raczylo.com,resources=clusterimageimports/finalizers,verbs=update
+// +kubebuilder:rbac:groups=raczylo.com,resources=clusterimageexports,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+
+// Reconcile reads the manifest of the export and pushes every image listed in it into the destination registry
+func (r *ClusterImageImportReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+
+	clusterImageImport := &raczylocomv1.ClusterImageImport{}
+	if err := r.Get(ctx, req.NamespacedName, clusterImageImport); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		l.Error(err, "unable to fetch ClusterImageImport")
+		return ctrl.Result{}, err
+	}
+
+	if !clusterImageImport.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	switch clusterImageImport.Status.Progress {
+	case shared.STATUS_SUCCESS, shared.STATUS_FAILED:
+		return ctrl.Result{}, nil
+	case "":
+		if err := shared.ValidateStorage(clusterImageImport.Spec.Storage, r.ForbidInlineCredentials); err != nil {
+			l.Error(err, "ClusterImageImport rejected")
+			clusterImageImport.Status.Progress = shared.STATUS_FAILED
+			return ctrl.Result{}, r.Status().Update(ctx, clusterImageImport)
+		}
+		if err := shared.CheckStorageSecret(ctx, r.Client, clusterImageImport.Namespace, clusterImageImport.Spec.Storage); err != nil {
+			l.Error(err, "storage credentials are not available")
+			return ctrl.Result{}, err
+		}
+		clusterImageImport.Status.Progress = shared.STATUS_PENDING
+		if err := r.Status().Update(ctx, clusterImageImport); err != nil {
+			l.Error(err, "unable to update ClusterImageImport status to PENDING")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	case shared.STATUS_PENDING:
+		if clusterImageImport.Spec.Storage.StorageTarget == shared.STORAGE_REGISTRY {
+			l.Error(nil, "Images mirrored with the registry target can't be imported, copy them with another registry target instead")
+			clusterImageImport.Status.Progress = shared.STATUS_FAILED
+			return ctrl.Result{}, r.Status().Update(ctx, clusterImageImport)
+		}
+		return r.readManifest(ctx, clusterImageImport)
+	}
+
+	return r.importImages(ctx, clusterImageImport)
+}
+
+// readManifest stores the manifest of the export in the ConfigMap of the import and lists its images in the status.
+// Manifest of the export from this cluster is copied from its ConfigMap, otherwise the worker job fetches it from the storage.
+func (r *ClusterImageImportReconciler) readManifest(ctx context.Context, clusterImageImport *raczylocomv1.ClusterImageImport) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+	name := importManifestName(clusterImageImport)
+
+	configMap := &corev1.ConfigMap{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: clusterImageImport.Namespace, Name: name}, configMap)
+	if errors.IsNotFound(err) {
+		data, err := r.exportManifestData(ctx, clusterImageImport)
+		if err != nil {
+			l.Error(err, "unable to read the manifest of the export")
+			return ctrl.Result{}, err
+		}
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: clusterImageImport.Namespace},
+			Data:       data,
+		}
+		if err := controllerutil.SetControllerReference(clusterImageImport, configMap, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Create(ctx, configMap); err != nil {
+			l.Error(err, "unable to create import manifest ConfigMap")
+			return ctrl.Result{}, err
+		}
+	} else if err != nil {
+		l.Error(err, "unable to get import manifest ConfigMap")
+		return ctrl.Result{}, err
+	}
+
+	if _, ok := configMap.Data[shared.MANIFEST_FILE]; !ok {
+		return r.fetchManifest(ctx, clusterImageImport, name)
+	}
+
+	manifest, err := shared.ManifestFromConfigMap(configMap)
+	if err != nil {
+		l.Error(err, "unable to read the import manifest")
+		clusterImageImport.Status.Progress = shared.STATUS_FAILED
+	} else {
+		images := importImagesFromManifest(clusterImageImport, manifest)
+		clusterImageImport.Status.Progress = shared.STATUS_RUNNING
+		clusterImageImport.Status.Format = manifest.Format
+		clusterImageImport.Status.Manifest = name
+		clusterImageImport.Status.Total = len(images)
+		clusterImageImport.Status.Pending = len(images)
+	}
+	if err := r.Status().Update(ctx, clusterImageImport); err != nil {
+		l.Error(err, "unable to update ClusterImageImport status", "Status", clusterImageImport.Status.Progress)
+		return ctrl.Result{}, err
+	}
+
+	manifestJob := &v1batch.Job{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: clusterImageImport.Namespace}}
+	if err := r.Delete(ctx, manifestJob, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+		l.Error(err, "unable to delete import manifest job", "job", name)
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// exportManifestData returns the manifest ConfigMap data of the completed export from this cluster,
+// nil when the export is not here or stored its images elsewhere
+func (r *ClusterImageImportReconciler) exportManifestData(ctx context.Context, clusterImageImport *raczylocomv1.ClusterImageImport) (map[string]string, error) {
+	clusterImageExport := &raczylocomv1.ClusterImageExport{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: clusterImageImport.Namespace, Name: clusterImageImport.Spec.ExportName}, clusterImageExport); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	if !meta.IsStatusConditionTrue(clusterImageExport.Status.Conditions, shared.CONDITION_READY) ||
+		storageKey(clusterImageExport.Spec.Storage, clusterImageExport.Spec.BasePath) != storageKey(clusterImageImport.Spec.Storage, clusterImageImport.Spec.BasePath) {
+		return nil, nil
+	}
+
+	exportConfigMap := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: clusterImageExport.Namespace, Name: manifestName(clusterImageExport.Name)}, exportConfigMap); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	manifest, ok := exportConfigMap.Data[shared.MANIFEST_FILE]
+	if !ok {
+		return nil, nil
+	}
+	return map[string]string{shared.MANIFEST_FILE: manifest}, nil
+}
+
+// fetchManifest runs the worker job storing the manifest from the storage in the ConfigMap of the import
+func (r *ClusterImageImportReconciler) fetchManifest(ctx context.Context, clusterImageImport *raczylocomv1.ClusterImageImport, configMapName string) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+	jobName := configMapName
+
+	manifestJob := &v1batch.Job{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: clusterImageImport.Namespace, Name: jobName}, manifestJob)
+	if errors.IsNotFound(err) {
+		manifestLocation := shared.StorageLocation(clusterImageImport.Spec.Storage, clusterImageImport.Spec.BasePath, clusterImageImport.Spec.ExportName) + "/" + shared.MANIFEST_FILE
+		commands := []string{
+			"./import.py " + r.storageParams(clusterImageImport) + "'" + manifestLocation + "' /tmp/" + shared.MANIFEST_FILE,
+			"./configmap.py /tmp/" + shared.MANIFEST_FILE + " '" + configMapName + "' " + shared.MANIFEST_FILE,
+		}
+		if err := r.createJob(ctx, clusterImageImport, jobName, nil, commands); err != nil {
+			l.Error(err, "unable to create import manifest job")
+			return ctrl.Result{}, err
+		}
+		l.Info("Created import manifest job", "job", jobName)
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	} else if err != nil {
+		l.Error(err, "unable to get import manifest job")
+		return ctrl.Result{}, err
+	}
+
+	if manifestJob.Status.Failed == 0 {
+		// Succeeded job has stored the manifest, it's read once the ConfigMap update is seen
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	l.Error(nil, "Import manifest job failed", "job", jobName)
+	clusterImageImport.Status.Progress = shared.STATUS_FAILED
+	if err := r.Status().Update(ctx, clusterImageImport); err != nil {
+		l.Error(err, "unable to update ClusterImageImport status", "Status", clusterImageImport.Status.Progress)
+		return ctrl.Result{}, err
+	}
+	if err := r.Delete(ctx, manifestJob, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+		l.Error(err, "unable to delete import manifest job", "job", jobName)
+	}
+	return ctrl.Result{}, nil
+}
+
+// importManifestName returns the name of the manifest ConfigMap and job of the import
+func importManifestName(clusterImageImport *raczylocomv1.ClusterImageImport) string {
+	return shared.IMPORT_MANIFEST_PREFIX + shared.NormalizeImageName(clusterImageImport.Name)
+}
+
+// importImage is the image of the manifest pushed into the destination registry by its own job
+type importImage struct {
+	FullName string
+	// Location of the stored image tarball
+	Artifact string
+	// Image reference in the destination registry
+	Destination string
+	// Platforms stored in the artifact, empty for the single platform image
+	Platforms []string
+	// Referrers pushed into the destination repository next to the image
+	Referrers []raczylocomv1.ClusterImageReferrer
+	// Reason the image can't be imported at all, it fails without the job
+	Error string
+}
+
+// importImagesFromManifest lists the images to push, the ones without the stored tarball can't be imported and fail
+func importImagesFromManifest(clusterImageImport *raczylocomv1.ClusterImageImport, manifest *shared.ExportManifest) []importImage {
+	images := []importImage{}
+	seen := map[string]bool{}
+	for _, image := range manifest.Images {
+		destination := shared.ImportDestination(clusterImageImport.Spec.Destination.Registry, clusterImageImport.Spec.Destination.RepositoryPrefix, image)
+		if seen[destination] {
+			continue
+		}
+		seen[destination] = true
+		toImport := importImage{
+			FullName:    image.FullName,
+			Artifact:    image.Artifact,
+			Destination: destination,
+			Platforms:   image.Platforms,
+			Referrers:   image.Referrers,
+		}
+		if image.Artifact == "" {
+			toImport.Error = "No stored tarball listed in the manifest"
+		}
+		images = append(images, toImport)
+	}
+	return images
+}
+
+// importImages starts the import jobs up to the concurrency limit and records the progress of the images
+// reported by their jobs. Finished images keep their progress in the status after the jobs are deleted,
+// unfinished image whose job vanished is imported again.
+func (r *ClusterImageImportReconciler) importImages(ctx context.Context, clusterImageImport *raczylocomv1.ClusterImageImport) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+
+	// Manifest of the vanished ConfigMap is reported missing
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: clusterImageImport.Status.Manifest}}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: clusterImageImport.Namespace, Name: clusterImageImport.Status.Manifest}, configMap); err != nil && !errors.IsNotFound(err) {
+		l.Error(err, "unable to get import manifest ConfigMap")
+		return ctrl.Result{}, err
+	}
+	manifest, err := shared.ManifestFromConfigMap(configMap)
+	if err != nil {
+		l.Error(err, "unable to read the import manifest")
+		clusterImageImport.Status.Progress = shared.STATUS_FAILED
+		return ctrl.Result{}, r.Status().Update(ctx, clusterImageImport)
+	}
+	images := importImagesFromManifest(clusterImageImport, manifest)
+
+	jobList := &v1batch.JobList{}
+	if err := r.List(ctx, jobList, client.InNamespace(clusterImageImport.Namespace), client.MatchingLabels{shared.IMPORT_UID_LABEL: string(clusterImageImport.UID)}); err != nil {
+		l.Error(err, "unable to list import jobs")
+		return ctrl.Result{}, err
+	}
+	jobs := map[string]*v1batch.Job{}
+	for i := range jobList.Items {
+		jobs[jobList.Items[i].Name] = &jobList.Items[i]
+	}
+
+	failures := map[string]string{}
+	for _, job := range jobs {
+		if job.Status.Failed > 0 && job.Status.Succeeded == 0 && !importImageFinished(clusterImageImport, job.Name) {
+			message, err := r.importJobFailure(ctx, job)
+			if err != nil {
+				l.Error(err, "unable to read the import job failure", "job", job.Name)
+				return ctrl.Result{}, err
+			}
+			failures[job.Name] = message
+		}
+	}
+
+	pending := trackImportImages(clusterImageImport, images, jobs, failures)
+	for _, image := range pending {
+		if clusterImageImport.Status.Running >= clusterImageImport.Spec.MaxConcurrentJobs {
+			break
+		}
+		if err := r.createImportJob(ctx, clusterImageImport, image); err != nil && !errors.IsAlreadyExists(err) {
+			l.Error(err, "unable to create import job", "image", image.FullName)
+			return ctrl.Result{}, err
+		}
+		for i := range clusterImageImport.Status.Images {
+			if clusterImageImport.Status.Images[i].Destination == image.Destination {
+				clusterImageImport.Status.Images[i].Progress = shared.STATUS_RUNNING
+			}
+		}
+		clusterImageImport.Status.Pending--
+		clusterImageImport.Status.Running++
+	}
+
+	status := &clusterImageImport.Status
+	if status.Succeeded+status.Failed == status.Total {
+		status.Progress = shared.STATUS_SUCCESS
+		if status.Failed > 0 {
+			l.Error(nil, "Images failed to import", "images", status.FailedImages)
+			status.Progress = shared.STATUS_FAILED
+		}
+	}
+
+	if err := r.Status().Update(ctx, clusterImageImport); err != nil {
+		l.Error(err, "unable to update ClusterImageImport status")
+		return ctrl.Result{}, err
+	}
+
+	if clusterImageImport.Status.Progress == shared.STATUS_RUNNING {
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+	if err := r.DeleteAllOf(ctx, &v1batch.Job{}, client.InNamespace(clusterImageImport.Namespace), client.MatchingLabels{shared.IMPORT_UID_LABEL: string(clusterImageImport.UID)},
+		client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+		l.Error(err, "unable to delete import jobs")
+	}
+	return ctrl.Result{}, nil
+}
+
+// trackImportImages records the progress of every image by the result of its job, failed jobs report the message
+// from the failures. Returns the images still waiting for their job.
+func trackImportImages(clusterImageImport *raczylocomv1.ClusterImageImport, images []importImage, jobs map[string]*v1batch.Job, failures map[string]string) []*importImage {
+	status := &clusterImageImport.Status
+	previous := map[string]raczylocomv1.ClusterImageImportImage{}
+	for _, image := range status.Images {
+		previous[image.Destination] = image
+	}
+	status.Total = len(images)
+	status.Pending, status.Running, status.Succeeded, status.Failed = 0, 0, 0, 0
+	status.FailedImages = nil
+	status.Images = make([]raczylocomv1.ClusterImageImportImage, 0, len(images))
+
+	pending := []*importImage{}
+	for i := range images {
+		jobName := importJobName(clusterImageImport, &images[i])
+		imageStatus := raczylocomv1.ClusterImageImportImage{
+			FullName:    images[i].FullName,
+			Destination: images[i].Destination,
+			JobName:     jobName,
+		}
+		job, ok := jobs[jobName]
+		switch {
+		case images[i].Error != "":
+			imageStatus.JobName = ""
+			imageStatus.Progress = shared.STATUS_FAILED
+			imageStatus.LastError = images[i].Error
+		case previous[images[i].Destination].Progress == shared.STATUS_SUCCESS || previous[images[i].Destination].Progress == shared.STATUS_FAILED:
+			imageStatus = previous[images[i].Destination]
+		case !ok:
+			imageStatus.Progress = shared.STATUS_PENDING
+			pending = append(pending, &images[i])
+		case job.Status.Succeeded > 0:
+			imageStatus.Progress = shared.STATUS_SUCCESS
+		case job.Status.Failed > 0:
+			imageStatus.Progress = shared.STATUS_FAILED
+			imageStatus.LastError = failures[jobName]
+		default:
+			imageStatus.Progress = shared.STATUS_RUNNING
+		}
+
+		switch imageStatus.Progress {
+		case shared.STATUS_PENDING:
+			status.Pending++
+		case shared.STATUS_SUCCESS:
+			status.Succeeded++
+		case shared.STATUS_FAILED:
+			status.Failed++
+			status.FailedImages = append(status.FailedImages, imageStatus.FullName)
+		default:
+			status.Running++
+		}
+		status.Images = append(status.Images, imageStatus)
+	}
+	return pending
+}
+
+// importImageFinished returns true when the image of the job has already finished
+func importImageFinished(clusterImageImport *raczylocomv1.ClusterImageImport, jobName string) bool {
+	for _, image := range clusterImageImport.Status.Images {
+		if image.JobName == jobName {
+			return image.Progress == shared.STATUS_SUCCESS || image.Progress == shared.STATUS_FAILED
+		}
+	}
+	return false
+}
+
+// importJobFailure returns the termination message of the last failed container of the import job
+func (r *ClusterImageImportReconciler) importJobFailure(ctx context.Context, job *v1batch.Job) (string, error) {
+	message := fmt.Sprintf("Import job %s failed", job.Name)
+	if job.Spec.Selector == nil {
+		return message, nil
+	}
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(job.Namespace), client.MatchingLabels(job.Spec.Selector.MatchLabels)); err != nil {
+		return "", err
+	}
+	if _, failure := jobFailure(podList.Items); failure != "" {
+		message = failure
+	}
+	return message, nil
+}
+
+// createImportJob creates the job loading the stored tarball and pushing it into the destination registry
+func (r *ClusterImageImportReconciler) createImportJob(ctx context.Context, clusterImageImport *raczylocomv1.ClusterImageImport, image *importImage) error {
+	tarball := "/tmp/" + shared.NormalizeImageName(image.FullName) + ".tar"
+	pushParams, copyParams := "", importCopyParams(clusterImageImport, image)
+	if clusterImageImport.Spec.Destination.Insecure {
+		pushParams = "--tls-verify=false "
+	}
+
+	var commands []string
+	if layoutRoot, digest, ok := shared.OCILayoutBlob(image.Artifact); ok {
+		// Images stored in the OCI layout are assembled from the shared blobs first
+		commands = []string{
+			"./oci_layout.py fetch " + r.storageParams(clusterImageImport) + "'" + layoutRoot + "' '" + digest + "' --workdir=/tmp/oci-layout",
+			"skopeo copy " + copyParams + "oci:/tmp/oci-layout 'docker://" + image.Destination + "'",
+			"rm -rf /tmp/oci-layout",
+		}
+	} else if clusterImageImport.Status.Format == shared.FORMAT_OCI_ARCHIVE {
+		commands = []string{
+			"./import.py " + r.storageParams(clusterImageImport) + "'" + image.Artifact + "' '" + tarball + "'",
+			"skopeo copy " + copyParams + "'oci-archive:" + tarball + "' 'docker://" + image.Destination + "'",
+			"rm -f '" + tarball + "'",
+		}
+	} else {
+		commands = []string{
+			"./import.py " + r.storageParams(clusterImageImport) + "'" + image.Artifact + "' '" + tarball + "'",
+			"podman load --quiet -i '" + tarball + "'",
+			"podman push --quiet " + pushParams + "'" + image.FullName + "' 'docker://" + image.Destination + "'",
+			"rm -f '" + tarball + "'",
+		}
+	}
+	commands = append(commands, importReferrerCommands(image, r.storageParams(clusterImageImport), copyParams)...)
+	labels := map[string]string{shared.IMPORT_UID_LABEL: string(clusterImageImport.UID)}
+	return r.createJob(ctx, clusterImageImport, importJobName(clusterImageImport, image), labels, commands)
+}
+
+// importCopyParams returns the skopeo copy params of the stored image
+func importCopyParams(clusterImageImport *raczylocomv1.ClusterImageImport, image *importImage) string {
+	copyParams := ""
+	if clusterImageImport.Spec.Destination.Insecure {
+		copyParams = "--dest-tls-verify=false "
+	}
+	// Manifest list has to be pushed with all its platforms, referrers are valid only for the original digest of the image
+	if shared.MultiPlatform(image.Platforms) || len(image.Referrers) > 0 {
+		copyParams += "--all --preserve-digests "
+	}
+	return copyParams
+}
+
+// importReferrerCommands pushes the referrers stored next to the image into the destination repository,
+// under the same tags as in the source repository
+func importReferrerCommands(image *importImage, storageParams string, copyParams string) []string {
+	commands := []string{}
+	for _, referrer := range image.Referrers {
+		archive := "/tmp/" + shared.ReferrersTag(referrer.Digest) + ".tar"
+		destination := shared.ImageRepository(image.Destination) + ":" + shared.ReferrerTag(referrer)
+		commands = append(commands,
+			"./import.py "+storageParams+"'"+referrer.Artifact+"' '"+archive+"'",
+			"skopeo copy "+copyParams+"'oci-archive:"+archive+"' 'docker://"+destination+"'",
+			"rm -f '"+archive+"'",
+		)
+	}
+	return commands
+}
+
+func (r *ClusterImageImportReconciler) createJob(ctx context.Context, clusterImageImport *raczylocomv1.ClusterImageImport, jobName string, labels map[string]string, commands []string) error {
+	jobParams := shared.JobParams{
+		Name:             jobName,
+		Namespace:        clusterImageImport.Namespace,
+		Labels:           labels,
+		Image:            shared.BACKUP_JOB_IMAGE,
+		Commands:         commands,
+		Annotations:      clusterImageImport.Spec.JobAnnotations,
+		EnvVars:          shared.StorageEnvVars(clusterImageImport.Spec.Storage),
+		Storage:          &clusterImageImport.Spec.Storage,
+		ServiceAccount:   os.Getenv("POD_SERVICE_ACCOUNT"),
+		ImagePullSecrets: clusterImageImport.Spec.ImagePullSecrets,
+	}
+
+	job := shared.CreateJob(jobParams, func(raczylocomv1.ClusterImageImport) []string { return nil })
+	if err := controllerutil.SetControllerReference(clusterImageImport, job, r.Scheme); err != nil {
+		return err
+	}
+	return r.Create(ctx, job)
+}
+
+func (r *ClusterImageImportReconciler) storageParams(clusterImageImport *raczylocomv1.ClusterImageImport) string {
+	if clusterImageImport.Spec.Storage.StorageTarget != shared.STORAGE_S3 {
+		return ""
+	}
+	return strings.Join(shared.SetupS3Params(clusterImageImport.Spec.Storage.S3), " ") + " "
+}
+
+func importJobName(clusterImageImport *raczylocomv1.ClusterImageImport, image *importImage) string {
+	return "img-import-" + fmt.Sprintf("%x", md5.Sum([]byte(clusterImageImport.Name+image.Destination)))[:14]
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterImageImportReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&raczylocomv1.ClusterImageImport{}).
+		Owns(&v1batch.Job{}).
+		Owns(&corev1.ConfigMap{}).
+		Complete(r)
+}
@@ -0,0 +1,370 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raczylocom
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+	"github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
+)
+
+var _ = Describe("ClusterImageImport Controller", func() {
+	Context("When reconciling a resource", func() {
+		const resourceName = "test-resource"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+		clusterimageimport := &raczylocomv1.ClusterImageImport{}
+
+		BeforeEach(func() {
+			By("creating the custom resource for the Kind ClusterImageImport")
+			err := k8sClient.Get(ctx, typeNamespacedName, clusterimageimport)
+			if err != nil && errors.IsNotFound(err) {
+				resource := &raczylocomv1.ClusterImageImport{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      resourceName,
+						Namespace: "default",
+					},
+					Spec: raczylocomv1.ClusterImageImportSpec{
+						ExportName:        "backup-20240901",
+						BasePath:          "/images",
+						MaxConcurrentJobs: 1,
+						Storage: raczylocomv1.ClusterImageStorageSpec{
+							StorageTarget: "S3",
+							S3: raczylocomv1.ClusterImageStorageS3{
+								Bucket: "backup",
+								Region: "us-west-2",
+							},
+						},
+						Destination: raczylocomv1.ClusterImageImportDestination{
+							Registry: "registry.internal:5000",
+						},
+					},
+				}
+				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			resource := &raczylocomv1.ClusterImageImport{}
+			err := k8sClient.Get(ctx, typeNamespacedName, resource)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Cleanup the specific resource instance ClusterImageImport")
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+		})
+		It("should successfully reconcile the resource", func() {
+			By("Reconciling the created resource")
+			controllerReconciler := &ClusterImageImportReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("When reading the manifest", func() {
+		var (
+			scheme             *runtime.Scheme
+			clusterImageImport *raczylocomv1.ClusterImageImport
+			clusterImageExport *raczylocomv1.ClusterImageExport
+			exportConfigMap    *corev1.ConfigMap
+		)
+
+		BeforeEach(func() {
+			scheme = runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(raczylocomv1.AddToScheme(scheme)).To(Succeed())
+			storage := raczylocomv1.ClusterImageStorageSpec{
+				StorageTarget: shared.STORAGE_S3,
+				S3:            raczylocomv1.ClusterImageStorageS3{Bucket: "backup", UseRole: true},
+			}
+			clusterImageImport = &raczylocomv1.ClusterImageImport{
+				ObjectMeta: metav1.ObjectMeta{Name: "import", Namespace: "default", UID: "import-uid"},
+				Spec: raczylocomv1.ClusterImageImportSpec{
+					ExportName:  "backup-20240901",
+					BasePath:    "/images",
+					Storage:     storage,
+					Destination: raczylocomv1.ClusterImageImportDestination{Registry: "registry.internal"},
+				},
+				Status: raczylocomv1.ClusterImageImportStatus{Progress: shared.STATUS_PENDING},
+			}
+			clusterImageExport = &raczylocomv1.ClusterImageExport{
+				ObjectMeta: metav1.ObjectMeta{Name: "backup-20240901", Namespace: "default"},
+				Spec:       raczylocomv1.ClusterImageExportSpec{BasePath: "/images", Storage: storage},
+				Status: raczylocomv1.ClusterImageExportStatus{
+					Conditions: []metav1.Condition{{Type: shared.CONDITION_READY, Status: metav1.ConditionTrue, Reason: shared.REASON_COMPLETED}},
+				},
+			}
+			exportConfigMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: manifestName("backup-20240901"), Namespace: "default"},
+				Data: map[string]string{
+					shared.MANIFEST_FILE: `{"exportName":"backup-20240901","format":"oci-archive","images":[{"fullName":"nginx:1.27","image":"nginx","artifact":"s3://backup/images/backup-20240901/nginx-1.27.tar"}]}`,
+				},
+			}
+		})
+
+		It("should copy the manifest of the export from this cluster", func() {
+			k8sClient := fake.NewClientBuilder().WithScheme(scheme).
+				WithObjects(clusterImageImport, clusterImageExport, exportConfigMap).WithStatusSubresource(clusterImageImport).Build()
+			controllerReconciler := &ClusterImageImportReconciler{Client: k8sClient, Scheme: scheme}
+
+			_, err := controllerReconciler.readManifest(context.Background(), clusterImageImport)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterImageImport.Status.Progress).To(Equal(shared.STATUS_RUNNING))
+			Expect(clusterImageImport.Status.Format).To(Equal(shared.FORMAT_OCI_ARCHIVE))
+			Expect(clusterImageImport.Status.Manifest).To(Equal("import-manifest-import"))
+			Expect(clusterImageImport.Status.Total).To(Equal(1))
+
+			configMap := &corev1.ConfigMap{}
+			Expect(k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "import-manifest-import"}, configMap)).To(Succeed())
+			Expect(configMap.Data).To(Equal(exportConfigMap.Data))
+			jobs := &batchv1.JobList{}
+			Expect(k8sClient.List(context.Background(), jobs)).To(Succeed())
+			Expect(jobs.Items).To(BeEmpty())
+		})
+
+		It("should fetch the manifest of the export stored elsewhere into the ConfigMap", func() {
+			clusterImageExport.Spec.Storage.S3.Bucket = "other"
+			k8sClient := fake.NewClientBuilder().WithScheme(scheme).
+				WithObjects(clusterImageImport, clusterImageExport, exportConfigMap).WithStatusSubresource(clusterImageImport).Build()
+			controllerReconciler := &ClusterImageImportReconciler{Client: k8sClient, Scheme: scheme}
+
+			_, err := controllerReconciler.readManifest(context.Background(), clusterImageImport)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterImageImport.Status.Progress).To(Equal(shared.STATUS_PENDING))
+
+			configMap := &corev1.ConfigMap{}
+			Expect(k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "import-manifest-import"}, configMap)).To(Succeed())
+			Expect(configMap.Data).To(BeEmpty())
+			job := &batchv1.Job{}
+			Expect(k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "import-manifest-import"}, job)).To(Succeed())
+			Expect(job.Spec.Template.Spec.Containers[0].Args[2]).To(Equal(
+				"./import.py --use_role 's3://backup/images/backup-20240901/manifest.json' /tmp/manifest.json && " +
+					"./configmap.py /tmp/manifest.json 'import-manifest-import' manifest.json"))
+
+			By("reading the manifest stored by the job")
+			configMap.Data = exportConfigMap.Data
+			Expect(k8sClient.Update(context.Background(), configMap)).To(Succeed())
+			_, err = controllerReconciler.readManifest(context.Background(), clusterImageImport)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterImageImport.Status.Progress).To(Equal(shared.STATUS_RUNNING))
+			Expect(clusterImageImport.Status.Total).To(Equal(1))
+			Expect(k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "import-manifest-import"}, job)).NotTo(Succeed())
+		})
+	})
+
+	Context("When importing the images", func() {
+		It("should record the progress of the images reported by their jobs", func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(raczylocomv1.AddToScheme(scheme)).To(Succeed())
+			clusterImageImport := &raczylocomv1.ClusterImageImport{
+				ObjectMeta: metav1.ObjectMeta{Name: "import", Namespace: "default", UID: "import-uid"},
+				Spec: raczylocomv1.ClusterImageImportSpec{
+					ExportName:        "backup-20240901",
+					BasePath:          "/images",
+					Destination:       raczylocomv1.ClusterImageImportDestination{Registry: "registry.internal"},
+					MaxConcurrentJobs: 1,
+				},
+				Status: raczylocomv1.ClusterImageImportStatus{Progress: shared.STATUS_RUNNING, Manifest: "import-manifest-import", Total: 3},
+			}
+			configMap := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "import-manifest-import", Namespace: "default"},
+				Data: map[string]string{
+					shared.MANIFEST_FILE: `{"exportName":"backup-20240901","images":[` +
+						`{"fullName":"nginx:1.27","image":"nginx","tag":"1.27","artifact":"/images/backup-20240901/nginx-1.27.tar"},` +
+						`{"fullName":"redis:7","image":"redis","tag":"7","artifact":"/images/backup-20240901/redis-7.tar"},` +
+						`{"fullName":"busybox:1.36","image":"busybox","tag":"1.36","artifact":"/images/backup-20240901/busybox-1.36.tar"},` +
+						`{"fullName":"alpine:3.20","image":"alpine","tag":"3.20"}]}`,
+				},
+			}
+			manifest, err := shared.ManifestFromConfigMap(configMap)
+			Expect(err).NotTo(HaveOccurred())
+			images := importImagesFromManifest(clusterImageImport, manifest)
+			succeededJob := &batchv1.Job{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      importJobName(clusterImageImport, &images[0]),
+					Namespace: "default",
+					Labels:    map[string]string{shared.IMPORT_UID_LABEL: "import-uid"},
+				},
+				Status: batchv1.JobStatus{Succeeded: 1},
+			}
+			k8sClient := fake.NewClientBuilder().WithScheme(scheme).
+				WithObjects(clusterImageImport, configMap, succeededJob).WithStatusSubresource(clusterImageImport, succeededJob).Build()
+			controllerReconciler := &ClusterImageImportReconciler{Client: k8sClient, Scheme: scheme}
+
+			_, err = controllerReconciler.importImages(context.Background(), clusterImageImport)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterImageImport.Status).To(MatchFields(IgnoreExtras, Fields{
+				"Progress":     Equal(shared.STATUS_RUNNING),
+				"Total":        Equal(4),
+				"Pending":      Equal(1),
+				"Running":      Equal(1),
+				"Succeeded":    Equal(1),
+				"Failed":       Equal(1),
+				"FailedImages": Equal([]string{"alpine:3.20"}),
+			}))
+
+			By("finishing the import once all the jobs are finished")
+			jobs := &batchv1.JobList{}
+			Expect(k8sClient.List(context.Background(), jobs, client.MatchingLabels{shared.IMPORT_UID_LABEL: "import-uid"})).To(Succeed())
+			Expect(jobs.Items).To(HaveLen(2))
+			for i := range jobs.Items {
+				if jobs.Items[i].Name != succeededJob.Name {
+					jobs.Items[i].Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"job-name": jobs.Items[i].Name}}
+					Expect(k8sClient.Update(context.Background(), &jobs.Items[i])).To(Succeed())
+					jobs.Items[i].Status.Failed = 1
+					Expect(k8sClient.Status().Update(context.Background(), &jobs.Items[i])).To(Succeed())
+					Expect(k8sClient.Create(context.Background(), &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{Name: jobs.Items[i].Name + "-abcde", Namespace: "default", Labels: map[string]string{"job-name": jobs.Items[i].Name}},
+						Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+							State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Message: "Error: writing blob: unauthorized"}},
+						}}},
+					})).To(Succeed())
+				}
+			}
+			_, err = controllerReconciler.importImages(context.Background(), clusterImageImport)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterImageImport.Status.Running).To(Equal(1))
+			jobs = &batchv1.JobList{}
+			Expect(k8sClient.List(context.Background(), jobs, client.MatchingLabels{shared.IMPORT_UID_LABEL: "import-uid"})).To(Succeed())
+			for i := range jobs.Items {
+				if jobs.Items[i].Status.Succeeded == 0 && jobs.Items[i].Status.Failed == 0 {
+					jobs.Items[i].Status.Succeeded = 1
+					Expect(k8sClient.Status().Update(context.Background(), &jobs.Items[i])).To(Succeed())
+				}
+			}
+			_, err = controllerReconciler.importImages(context.Background(), clusterImageImport)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterImageImport.Status).To(MatchFields(IgnoreExtras, Fields{
+				"Progress":     Equal(shared.STATUS_FAILED),
+				"Succeeded":    Equal(2),
+				"Failed":       Equal(2),
+				"FailedImages": Equal([]string{"redis:7", "alpine:3.20"}),
+			}))
+			Expect(k8sClient.List(context.Background(), jobs, client.MatchingLabels{shared.IMPORT_UID_LABEL: "import-uid"})).To(Succeed())
+			Expect(jobs.Items).To(BeEmpty())
+
+			By("keeping the progress of every image after the jobs are deleted")
+			Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(clusterImageImport), clusterImageImport)).To(Succeed())
+			Expect(clusterImageImport.Status.Images).To(Equal([]raczylocomv1.ClusterImageImportImage{
+				{FullName: "nginx:1.27", Destination: "registry.internal/library/nginx:1.27", Progress: shared.STATUS_SUCCESS, JobName: importJobName(clusterImageImport, &images[0])},
+				{FullName: "redis:7", Destination: "registry.internal/library/redis:7", Progress: shared.STATUS_FAILED, JobName: importJobName(clusterImageImport, &images[1]),
+					LastError: "Error: writing blob: unauthorized"},
+				{FullName: "busybox:1.36", Destination: "registry.internal/library/busybox:1.36", Progress: shared.STATUS_SUCCESS, JobName: importJobName(clusterImageImport, &images[2])},
+				{FullName: "alpine:3.20", Destination: "registry.internal/library/alpine:3.20", Progress: shared.STATUS_FAILED, LastError: "No stored tarball listed in the manifest"},
+			}))
+		})
+	})
+
+	Context("When listing the images from the manifest", func() {
+		It("should rewrite the destinations and fail the images without tarball", func() {
+			clusterImageImport := &raczylocomv1.ClusterImageImport{
+				Spec: raczylocomv1.ClusterImageImportSpec{
+					Destination: raczylocomv1.ClusterImageImportDestination{
+						Registry:         "registry.internal",
+						RepositoryPrefix: "mirror",
+					},
+				},
+			}
+			manifest := &shared.ExportManifest{
+				Images: []shared.ManifestImage{
+					{FullName: "nginx:1.27", Image: "nginx", Tag: "1.27", Artifact: "s3://backup/images/b/nginx-1.27.tar", Platforms: []string{"linux/amd64", "linux/arm64"}},
+					{FullName: "docker.io/library/nginx:1.27", Image: "docker.io/library/nginx", Tag: "1.27", Artifact: "s3://backup/images/b/nginx-1.27.tar"},
+					{FullName: "busybox:1.36", Image: "busybox", Tag: "1.36"},
+				},
+			}
+
+			images := importImagesFromManifest(clusterImageImport, manifest)
+			Expect(images).To(Equal([]importImage{
+				{
+					FullName:    "nginx:1.27",
+					Artifact:    "s3://backup/images/b/nginx-1.27.tar",
+					Destination: "registry.internal/mirror/library/nginx:1.27",
+					Platforms:   []string{"linux/amd64", "linux/arm64"},
+				},
+				{
+					FullName:    "busybox:1.36",
+					Destination: "registry.internal/mirror/library/busybox:1.36",
+					Error:       "No stored tarball listed in the manifest",
+				},
+			}))
+		})
+	})
+
+	Context("When copying the stored image", func() {
+		It("should keep the manifest list of more platforms", func() {
+			clusterImageImport := &raczylocomv1.ClusterImageImport{}
+			Expect(importCopyParams(clusterImageImport, &importImage{})).To(BeEmpty())
+			Expect(importCopyParams(clusterImageImport, &importImage{Platforms: []string{"linux/arm64"}})).To(BeEmpty())
+			Expect(importCopyParams(clusterImageImport, &importImage{Platforms: []string{"linux/amd64", "linux/arm64"}})).To(Equal("--all --preserve-digests "))
+			Expect(importCopyParams(clusterImageImport, &importImage{Platforms: []string{shared.PLATFORM_ALL}})).To(Equal("--all --preserve-digests "))
+
+			clusterImageImport.Spec.Destination.Insecure = true
+			Expect(importCopyParams(clusterImageImport, &importImage{
+				Referrers: []raczylocomv1.ClusterImageReferrer{{Kind: shared.REFERRER_SBOM, Digest: "sha256:3333"}},
+			})).To(Equal("--dest-tls-verify=false --all --preserve-digests "))
+		})
+	})
+
+	Context("When importing the referrers", func() {
+		It("should push the referrers under their source tags", func() {
+			image := &importImage{
+				Destination: "registry.internal:5000/mirror/acme/app:1.0",
+				Referrers: []raczylocomv1.ClusterImageReferrer{
+					{Kind: shared.REFERRER_SIGNATURE, Digest: "sha256:2222", Tag: "sha256-1111.sig", Artifact: "/images/b/app.referrers/sha256-2222.tar"},
+					{Kind: shared.REFERRER_SBOM, Digest: "sha256:3333", Artifact: "/images/b/app.referrers/sha256-3333.tar"},
+				},
+			}
+
+			commands := importReferrerCommands(image, "", "--preserve-digests ")
+			Expect(commands).To(Equal([]string{
+				"./import.py '/images/b/app.referrers/sha256-2222.tar' '/tmp/sha256-2222.tar'",
+				"skopeo copy --preserve-digests 'oci-archive:/tmp/sha256-2222.tar' 'docker://registry.internal:5000/mirror/acme/app:sha256-1111.sig'",
+				"rm -f '/tmp/sha256-2222.tar'",
+				"./import.py '/images/b/app.referrers/sha256-3333.tar' '/tmp/sha256-3333.tar'",
+				"skopeo copy --preserve-digests 'oci-archive:/tmp/sha256-3333.tar' 'docker://registry.internal:5000/mirror/acme/app:sha256-3333'",
+				"rm -f '/tmp/sha256-3333.tar'",
+			}))
+		})
+	})
+})
@@ -0,0 +1,245 @@
+package raczylocom
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+	shared "github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
+)
+
+// planExport records the images the dry run export would export, without creating the ClusterImages or jobs.
+// Images are planned as present by the same rules the export follows: exported by another ClusterImage
+// or listed in the manifest of the baseline export.
+func (r *ClusterImageExportReconciler) planExport(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport, imagesList shared.ContainersList) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+
+	clusterImageList := &raczylocomv1.ClusterImageList{}
+	if err := r.List(ctx, clusterImageList); err != nil {
+		l.Error(err, "unable to list ClusterImages")
+		return ctrl.Result{}, err
+	}
+	storages, err := exportStorages(ctx, r.Client)
+	if err != nil {
+		l.Error(err, "unable to list the exports")
+		return ctrl.Result{}, err
+	}
+	baselineImages, err := r.baselineImages(ctx, clusterImageExport)
+	if err != nil {
+		l.Error(err, "unable to read the baseline manifest")
+		return ctrl.Result{}, err
+	}
+
+	plan := &shared.ExportPlan{
+		ExportName:  clusterImageExport.Name,
+		Baseline:    clusterImageExport.Status.Baseline,
+		GeneratedAt: time.Now().UTC(),
+		Images:      planImages(imagesList, clusterImageExport.Spec.Platforms, clusterImageExport.Spec.Referrers, clusterImageList.Items, storages, storageKey(clusterImageExport.Spec.Storage, clusterImageExport.Spec.BasePath), clusterImageExport.Status.Baseline, baselineImages),
+	}
+	r.estimateImageSizes(ctx, clusterImageExport, plan.Images)
+	summary := summarizePlan(plan.Images)
+	summary.ConfigMap = "plan-" + shared.NormalizeImageName(clusterImageExport.Name)
+	plan.EstimatedSize = summary.EstimatedSize
+
+	if err := r.createPlanConfigMap(ctx, clusterImageExport, summary.ConfigMap, plan); err != nil {
+		l.Error(err, "unable to store the plan")
+		return ctrl.Result{}, err
+	}
+
+	message := fmt.Sprintf("%d of %d images would be exported, about %.1f MiB", summary.Export, len(plan.Images), float64(summary.EstimatedSize)/(1<<20))
+	if summary.UnknownSize > 0 {
+		message += fmt.Sprintf(" without %d images of unknown size", summary.UnknownSize)
+	}
+	message += fmt.Sprintf(", %d are already present", summary.Present)
+
+	completionTime := metav1.Now()
+	clusterImageExport.Status.Progress = shared.STATUS_PLANNED
+	clusterImageExport.Status.CompletionTime = &completionTime
+	clusterImageExport.Status.Total = len(plan.Images)
+	clusterImageExport.Status.Plan = summary
+	setCondition(clusterImageExport, shared.CONDITION_DISCOVERED, metav1.ConditionTrue, shared.REASON_DISCOVERED, fmt.Sprintf("Discovered %d images", len(plan.Images)))
+	setCondition(clusterImageExport, shared.CONDITION_READY, metav1.ConditionTrue, shared.REASON_PLANNED, message)
+	if err := r.Status().Update(ctx, clusterImageExport); err != nil {
+		l.Error(err, "unable to update ClusterImageExport status to PLANNED")
+		return ctrl.Result{}, err
+	}
+	r.forgetRegistryClient(clusterImageExport)
+	r.Recorder.Event(clusterImageExport, corev1.EventTypeNormal, shared.EVENT_PLANNED, message)
+	return ctrl.Result{}, nil
+}
+
+// planImages decides for every image whether it would be exported or is already present in the storage.
+// Sizes of the images to export are taken from the earlier exports of the same image when known.
+func planImages(imagesList shared.ContainersList, platforms []string, referrers bool, clusterImages []raczylocomv1.ClusterImage, storages map[types.NamespacedName]raczylocomv1.ClusterImageStorageSpec, storage string, baseline string, baselineImages []shared.ManifestImage) []shared.PlannedImage {
+	planned := make([]shared.PlannedImage, 0, len(imagesList.Containers))
+	for _, container := range imagesList.Containers {
+		image := shared.PlannedImage{
+			FullName:       container.FullName,
+			Image:          container.Image,
+			Tag:            container.Tag,
+			Sha:            container.Sha,
+			ImageNamespace: container.ImageNamespace,
+			Decision:       shared.PLAN_EXPORT,
+		}
+		if ci := exportedBy(clusterImages, storages, storage, container, platforms, referrers); ci != nil {
+			image.Decision = shared.PLAN_PRESENT
+			image.Reason = fmt.Sprintf("Exported by ClusterImage %s/%s of export %s", ci.Namespace, ci.Name, ci.Spec.ExportName)
+			image.Size = ci.Status.Size
+		} else if stored := storedByBaseline(baselineImages, container, platforms); stored != nil {
+			image.Decision = shared.PLAN_PRESENT
+			image.Reason = "Stored by the baseline export " + baseline
+			image.Size = stored.Size
+		} else {
+			image.Size = knownImageSize(clusterImages, baselineImages, container, platforms)
+		}
+		planned = append(planned, image)
+	}
+	return planned
+}
+
+// summarizePlan counts the planned images for the export status
+func summarizePlan(images []shared.PlannedImage) *raczylocomv1.ClusterImageExportPlan {
+	summary := &raczylocomv1.ClusterImageExportPlan{}
+	for _, image := range images {
+		switch {
+		case image.Decision == shared.PLAN_PRESENT:
+			summary.Present++
+		case image.Size > 0:
+			summary.Export++
+			summary.EstimatedSize += image.Size
+		default:
+			summary.Export++
+			summary.UnknownSize++
+		}
+	}
+	return summary
+}
+
+// exportedBy returns the ClusterImage the image would be marked PRESENT for
+func exportedBy(clusterImages []raczylocomv1.ClusterImage, storages map[types.NamespacedName]raczylocomv1.ClusterImageStorageSpec, storage string, container shared.Container, platforms []string, referrers bool) *raczylocomv1.ClusterImage {
+	for i := range clusterImages {
+		if exportsSameImage(&clusterImages[i], storages, storage, container.Image, container.FullName, container.Sha, platforms, referrers) {
+			return &clusterImages[i]
+		}
+	}
+	return nil
+}
+
+// storedByBaseline returns the image of the baseline manifest stored with the same platforms,
+// images with known digest have to match it
+func storedByBaseline(baselineImages []shared.ManifestImage, container shared.Container, platforms []string) *shared.ManifestImage {
+	for i, image := range baselineImages {
+		if image.FullName == container.FullName && (container.Sha == "" || image.Sha == container.Sha) && shared.SamePlatforms(image.Platforms, platforms) {
+			return &baselineImages[i]
+		}
+	}
+	return nil
+}
+
+// knownImageSize returns the size of the image stored with the same platforms by the earlier exports, other digests
+// of the same tag are good enough for the estimate. Returns 0 when the image was never exported.
+func knownImageSize(clusterImages []raczylocomv1.ClusterImage, baselineImages []shared.ManifestImage, container shared.Container, platforms []string) int64 {
+	for _, ci := range clusterImages {
+		if ci.Spec.FullName == container.FullName && ci.Status.Size > 0 && shared.SamePlatforms(ci.Spec.Platforms, platforms) {
+			return ci.Status.Size
+		}
+	}
+	for _, image := range baselineImages {
+		if image.FullName == container.FullName && image.Size > 0 && shared.SamePlatforms(image.Platforms, platforms) {
+			return image.Size
+		}
+	}
+	return 0
+}
+
+// estimateImageSizes asks the registries for the compressed size of the images never exported before.
+// Images the registry can't be asked about, or beyond the limit of the lookups, are left with the unknown size.
+func (r *ClusterImageExportReconciler) estimateImageSizes(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport, images []shared.PlannedImage) {
+	l := log.FromContext(ctx)
+
+	var registryClient *shared.RegistryClient
+	lookups := 0
+	for i := range images {
+		image := &images[i]
+		if image.Decision != shared.PLAN_EXPORT || image.Size > 0 {
+			continue
+		}
+		if lookups == shared.PLAN_MAX_SIZE_LOOKUPS {
+			l.Info("Size estimation limit reached, sizes of the remaining new images are unknown", "limit", shared.PLAN_MAX_SIZE_LOOKUPS)
+			return
+		}
+		lookups++
+		if registryClient == nil {
+			var err error
+			if registryClient, err = r.exportRegistryClient(ctx, clusterImageExport); err != nil {
+				l.Error(err, "unable to read the registry credentials, sizes of the new images are unknown")
+				return
+			}
+		}
+		reference := image.Sha
+		if reference == "" {
+			reference = image.Tag
+		}
+		size, err := registryClient.ImageSize(ctx, image.Image, reference, clusterImageExport.Spec.Platforms)
+		if err != nil {
+			l.Error(err, "unable to estimate image size", "image", image.FullName)
+			continue
+		}
+		image.Size = size
+	}
+}
+
+// baselineImages returns the images listed in the manifest ConfigMap of the baseline export.
+// The ConfigMap is removed together with its export, the plan treats such baseline as empty.
+func (r *ClusterImageExportReconciler) baselineImages(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport) ([]shared.ManifestImage, error) {
+	baseline := clusterImageExport.Status.Baseline
+	if baseline == "" {
+		return nil, nil
+	}
+
+	configMap := &corev1.ConfigMap{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: clusterImageExport.Namespace, Name: manifestName(baseline)}, configMap)
+	if errors.IsNotFound(err) {
+		log.FromContext(ctx).Info("Manifest of the baseline export is not available, its images are planned for export", "baseline", baseline)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	manifest := shared.ExportManifest{}
+	if err := json.Unmarshal([]byte(configMap.Data[shared.MANIFEST_FILE]), &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest of the baseline export %s: %w", baseline, err)
+	}
+	return manifest.Images, nil
+}
+
+// createPlanConfigMap stores the plan in the ConfigMap owned by the export
+func (r *ClusterImageExportReconciler) createPlanConfigMap(ctx context.Context, clusterImageExport *raczylocomv1.ClusterImageExport, name string, plan *shared.ExportPlan) error {
+	planJSON, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: clusterImageExport.Namespace,
+		},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		configMap.Data = map[string]string{shared.PLAN_FILE: string(planJSON)}
+		return controllerutil.SetControllerReference(clusterImageExport, configMap, r.Scheme)
+	})
+	return err
+}
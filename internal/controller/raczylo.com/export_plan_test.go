@@ -0,0 +1,93 @@
+package raczylocom
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+	"github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
+)
+
+var _ = Describe("Export plan", func() {
+	fileStorage := raczylocomv1.ClusterImageStorageSpec{StorageTarget: shared.STORAGE_FILE}
+	storages := map[types.NamespacedName]raczylocomv1.ClusterImageStorageSpec{{Namespace: "default", Name: "nightly"}: fileStorage}
+	storage := storageKey(fileStorage, "/images")
+	clusterImage := func(name string, fullName string, sha string, progress string, size int64) raczylocomv1.ClusterImage {
+		container, err := shared.ProcessContainerName(fullName)
+		Expect(err).NotTo(HaveOccurred())
+		return raczylocomv1.ClusterImage{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       raczylocomv1.ClusterImageSpec{Image: container.Image, Tag: container.Tag, Sha: sha, FullName: fullName, ExportName: "nightly", ExportPath: "/images"},
+			Status:     raczylocomv1.ClusterImageStatus{Progress: progress, Size: size},
+		}
+	}
+	images := func(fullNames ...string) shared.ContainersList {
+		containersList := shared.ContainersList{}
+		for _, fullName := range fullNames {
+			container, err := shared.ProcessContainerName(fullName)
+			Expect(err).NotTo(HaveOccurred())
+			containersList.Containers = append(containersList.Containers, container)
+		}
+		return containersList
+	}
+
+	It("should plan the images exported elsewhere or stored by the baseline as present", func() {
+		clusterImages := []raczylocomv1.ClusterImage{
+			clusterImage("nginx", "nginx:1.27", "", shared.STATUS_SUCCESS, 100),
+			clusterImage("redis", "redis:7", "", shared.STATUS_FAILED, 0),
+		}
+		baselineImages := []shared.ManifestImage{{FullName: "busybox:1.36", Size: 10}}
+
+		planned := planImages(images("nginx:1.27", "busybox:1.36", "redis:7"), nil, false, clusterImages, storages, storage, "weekly", baselineImages)
+		Expect(planned).To(HaveLen(3))
+		Expect(planned[0].Decision).To(Equal(shared.PLAN_PRESENT))
+		Expect(planned[0].Reason).To(Equal("Exported by ClusterImage default/nginx of export nightly"))
+		Expect(planned[1].Decision).To(Equal(shared.PLAN_PRESENT))
+		Expect(planned[1].Reason).To(Equal("Stored by the baseline export weekly"))
+		Expect(planned[2].Decision).To(Equal(shared.PLAN_EXPORT))
+	})
+
+	It("should plan the images exported into the other storage for the export", func() {
+		clusterImages := []raczylocomv1.ClusterImage{
+			clusterImage("nginx", "nginx:1.27", "", shared.STATUS_SUCCESS, 100),
+		}
+		s3Storage := raczylocomv1.ClusterImageStorageSpec{StorageTarget: shared.STORAGE_S3, S3: raczylocomv1.ClusterImageStorageS3{Bucket: "images"}}
+
+		planned := planImages(images("nginx:1.27"), nil, false, clusterImages, storages, storageKey(s3Storage, "/images"), "", nil)
+		Expect(planned[0].Decision).To(Equal(shared.PLAN_EXPORT))
+		Expect(planned[0].Size).To(Equal(int64(100)))
+
+		planned = planImages(images("nginx:1.27"), nil, false, clusterImages, storages, storageKey(fileStorage, "/archive"), "", nil)
+		Expect(planned[0].Decision).To(Equal(shared.PLAN_EXPORT))
+	})
+
+	It("should estimate the size from the earlier exports of the same image", func() {
+		clusterImages := []raczylocomv1.ClusterImage{
+			clusterImage("app", "ghcr.io/acme/app:1.0", "sha256:old", shared.STATUS_SUCCESS, 300),
+		}
+		containers := images("ghcr.io/acme/app:1.0", "ghcr.io/acme/new:1.0")
+		containers.Containers[0].Sha = "sha256:new"
+
+		planned := planImages(containers, nil, false, clusterImages, storages, storage, "", nil)
+		Expect(planned[0].Decision).To(Equal(shared.PLAN_EXPORT))
+		Expect(planned[0].Size).To(Equal(int64(300)))
+		Expect(planned[1].Size).To(BeZero())
+
+		summary := summarizePlan(planned)
+		Expect(summary.Export).To(Equal(2))
+		Expect(summary.EstimatedSize).To(Equal(int64(300)))
+		Expect(summary.UnknownSize).To(Equal(1))
+	})
+
+	It("should not count the present images into the estimated size", func() {
+		summary := summarizePlan([]shared.PlannedImage{
+			{Decision: shared.PLAN_PRESENT, Size: 100},
+			{Decision: shared.PLAN_EXPORT, Size: 50},
+		})
+		Expect(summary.Present).To(Equal(1))
+		Expect(summary.Export).To(Equal(1))
+		Expect(summary.EstimatedSize).To(Equal(int64(50)))
+	})
+})
@@ -0,0 +1,174 @@
+package raczylocom
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	v1batch "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+	"github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
+)
+
+// Created jobs are counted as running until the cache catches up with them
+const jobExpectationTimeout = 30 * time.Second
+
+// jobQueue admits the PENDING images once there is a free job slot of their export and of the whole cluster.
+// Running jobs are counted from the Jobs themselves, so the limits hold across the restarts of the manager.
+type jobQueue struct {
+	sync.Mutex
+	// Jobs created by this manager which may not be in the cache yet
+	expected map[types.NamespacedName]expectedJob
+}
+
+type expectedJob struct {
+	exportUID types.UID
+	createdAt time.Time
+}
+
+// exportQueue holds the pending images of a single export
+type exportQueue struct {
+	limit   int
+	created metav1.Time
+	running int
+	pending []raczylocomv1.ClusterImage
+}
+
+// expect counts the just created job as running until it shows up in the cache
+func (q *jobQueue) expect(job *v1batch.Job) {
+	q.Lock()
+	defer q.Unlock()
+	if q.expected == nil {
+		q.expected = map[types.NamespacedName]expectedJob{}
+	}
+	q.expected[client.ObjectKeyFromObject(job)] = expectedJob{
+		exportUID: types.UID(job.Labels[shared.EXPORT_UID_LABEL]),
+		createdAt: time.Now(),
+	}
+}
+
+// runningJobs returns the number of the running export jobs per export UID and in total
+func (q *jobQueue) runningJobs(ctx context.Context, c client.Client) (map[types.UID]int, int, error) {
+	jobList := &v1batch.JobList{}
+	if err := c.List(ctx, jobList, client.MatchingLabels{"app": "image-export"}, client.HasLabels{shared.EXPORT_UID_LABEL}); err != nil {
+		return nil, 0, err
+	}
+
+	q.Lock()
+	defer q.Unlock()
+	running := map[types.UID]int{}
+	total := 0
+	seen := map[types.NamespacedName]bool{}
+	for _, job := range jobList.Items {
+		seen[client.ObjectKeyFromObject(&job)] = true
+		if !jobActive(&job) {
+			continue
+		}
+		running[types.UID(job.Labels[shared.EXPORT_UID_LABEL])]++
+		total++
+	}
+	for key, expected := range q.expected {
+		if seen[key] || time.Since(expected.createdAt) > jobExpectationTimeout {
+			delete(q.expected, key)
+			continue
+		}
+		running[expected.exportUID]++
+		total++
+	}
+	activeJobs.Set(float64(total))
+	return running, total, nil
+}
+
+// jobActive returns true until the job finishes or gets deleted
+func jobActive(job *v1batch.Job) bool {
+	return job.DeletionTimestamp == nil && job.Status.Succeeded == 0 && job.Status.Failed == 0
+}
+
+// admit returns true when the pending image gets one of the free job slots
+func (q *jobQueue) admit(ctx context.Context, c client.Client, clusterImage *raczylocomv1.ClusterImage, maxGlobalJobs int) (bool, error) {
+	running, total, err := q.runningJobs(ctx, c)
+	if err != nil {
+		return false, err
+	}
+	free := -1
+	if maxGlobalJobs > 0 {
+		free = maxGlobalJobs - total
+		if free <= 0 {
+			return false, nil
+		}
+	}
+
+	exportList := &raczylocomv1.ClusterImageExportList{}
+	if err := c.List(ctx, exportList); err != nil {
+		return false, err
+	}
+	queues := map[types.UID]*exportQueue{}
+	for _, export := range exportList.Items {
+		// Pending images of the failed exports are not started
+		if export.Status.Progress == shared.STATUS_FAILED {
+			continue
+		}
+		queues[export.UID] = &exportQueue{
+			limit:   export.Spec.MaxConcurrentJobs,
+			created: export.CreationTimestamp,
+			running: running[export.UID],
+		}
+	}
+
+	clusterImageList := &raczylocomv1.ClusterImageList{}
+	if err := c.List(ctx, clusterImageList); err != nil {
+		return false, err
+	}
+	for _, ci := range clusterImageList.Items {
+		owner := metav1.GetControllerOf(&ci)
+		if ci.Status.Progress != shared.STATUS_PENDING || owner == nil || queues[owner.UID] == nil {
+			continue
+		}
+		queues[owner.UID].pending = append(queues[owner.UID].pending, ci)
+	}
+
+	return admitPending(queues, free)[client.ObjectKeyFromObject(clusterImage)], nil
+}
+
+// admitPending hands out the free job slots in a fair way. Exports take turns: the one with the fewest
+// running jobs goes first and the older export wins the tie, exports created at the same time are ordered
+// by their UID. Images of the export are admitted oldest first. Negative free means there is no limit for the whole cluster.
+func admitPending(queues map[types.UID]*exportQueue, free int) map[types.NamespacedName]bool {
+	for _, queue := range queues {
+		sort.SliceStable(queue.pending, func(i, j int) bool {
+			if !queue.pending[i].CreationTimestamp.Equal(&queue.pending[j].CreationTimestamp) {
+				return queue.pending[i].CreationTimestamp.Before(&queue.pending[j].CreationTimestamp)
+			}
+			return queue.pending[i].Name < queue.pending[j].Name
+		})
+	}
+
+	admitted := map[types.NamespacedName]bool{}
+	for free != 0 {
+		var next *exportQueue
+		var nextUID types.UID
+		for uid, queue := range queues {
+			if len(queue.pending) == 0 || queue.running >= max(queue.limit, 1) {
+				continue
+			}
+			if next == nil || queue.running < next.running ||
+				(queue.running == next.running && queue.created.Before(&next.created)) ||
+				(queue.running == next.running && queue.created.Equal(&next.created) && uid < nextUID) {
+				next, nextUID = queue, uid
+			}
+		}
+		if next == nil {
+			break
+		}
+		admitted[client.ObjectKeyFromObject(&next.pending[0])] = true
+		next.pending = next.pending[1:]
+		next.running++
+		free--
+	}
+	return admitted
+}
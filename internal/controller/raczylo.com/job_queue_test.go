@@ -0,0 +1,104 @@
+package raczylocom
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1batch "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+	"github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
+)
+
+var _ = Describe("Job queue", func() {
+	created := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	pendingImage := func(name string, age time.Duration) raczylocomv1.ClusterImage {
+		return raczylocomv1.ClusterImage{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			CreationTimestamp: metav1.NewTime(created.Add(-age)),
+		}}
+	}
+	exportJob := func(name string, exportUID string, status v1batch.JobStatus) *v1batch.Job {
+		return &v1batch.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Labels:    map[string]string{"app": "image-export", shared.EXPORT_UID_LABEL: exportUID},
+			},
+			Status: status,
+		}
+	}
+
+	It("should admit the oldest images up to the limit of the export", func() {
+		queues := map[types.UID]*exportQueue{
+			"export-a": {limit: 2, running: 1, pending: []raczylocomv1.ClusterImage{
+				pendingImage("new", time.Minute), pendingImage("old", time.Hour), pendingImage("older", 2*time.Hour),
+			}},
+		}
+		admitted := admitPending(queues, -1)
+		Expect(admitted).To(HaveLen(1))
+		Expect(admitted).To(HaveKey(types.NamespacedName{Name: "older", Namespace: "default"}))
+	})
+
+	It("should share the free slots of the cluster between the exports", func() {
+		queues := map[types.UID]*exportQueue{
+			"busy": {limit: 10, running: 2, created: metav1.NewTime(created.Add(-time.Hour)), pending: []raczylocomv1.ClusterImage{
+				pendingImage("busy-1", time.Hour), pendingImage("busy-2", time.Hour),
+			}},
+			"idle": {limit: 10, created: metav1.NewTime(created), pending: []raczylocomv1.ClusterImage{
+				pendingImage("idle-1", time.Minute), pendingImage("idle-2", time.Minute), pendingImage("idle-3", time.Minute),
+			}},
+		}
+		admitted := admitPending(queues, 3)
+		Expect(admitted).To(HaveLen(3))
+		Expect(admitted).To(HaveKey(types.NamespacedName{Name: "idle-1", Namespace: "default"}))
+		Expect(admitted).To(HaveKey(types.NamespacedName{Name: "idle-2", Namespace: "default"}))
+		// Exports are tied at two running jobs, the older one goes first
+		Expect(admitted).To(HaveKey(types.NamespacedName{Name: "busy-1", Namespace: "default"}))
+	})
+
+	It("should admit the same image of the exports created at the same time", func() {
+		for range 20 {
+			queues := map[types.UID]*exportQueue{}
+			for _, uid := range []types.UID{"export-c", "export-a", "export-b"} {
+				queues[uid] = &exportQueue{limit: 1, created: metav1.NewTime(created), pending: []raczylocomv1.ClusterImage{
+					pendingImage(string(uid)+"-2", time.Minute), pendingImage(string(uid)+"-1", time.Minute),
+				}}
+			}
+			Expect(admitPending(queues, 1)).To(Equal(map[types.NamespacedName]bool{{Name: "export-a-1", Namespace: "default"}: true}))
+		}
+	})
+
+	It("should admit at least one job of the export without the limit", func() {
+		queues := map[types.UID]*exportQueue{
+			"export-a": {pending: []raczylocomv1.ClusterImage{pendingImage("image", time.Minute)}},
+		}
+		Expect(admitPending(queues, -1)).To(HaveLen(1))
+		Expect(admitPending(queues, 0)).To(BeEmpty())
+	})
+
+	It("should count the running jobs and the created ones missing from the cache", func() {
+		fakeClient := fake.NewClientBuilder().WithObjects(
+			exportJob("running", "export-a", v1batch.JobStatus{Active: 1}),
+			exportJob("succeeded", "export-a", v1batch.JobStatus{Succeeded: 1}),
+			exportJob("failed", "export-b", v1batch.JobStatus{Failed: 1}),
+		).Build()
+
+		queue := &jobQueue{}
+		queue.expect(exportJob("running", "export-a", v1batch.JobStatus{}))
+		queue.expect(exportJob("created", "export-b", v1batch.JobStatus{}))
+
+		running, total, err := queue.runningJobs(context.Background(), fakeClient)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(total).To(Equal(2))
+		Expect(running).To(Equal(map[types.UID]int{"export-a": 1, "export-b": 1}))
+		// Jobs seen in the cache are no longer expected
+		Expect(queue.expected).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,108 @@
+package raczylocom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+	"github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
+)
+
+// Metrics are registered in the controller-runtime registry and served by the manager metrics endpoint
+var (
+	exportImagesDiscovered = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "images_sync_export_images_discovered",
+		Help: "Number of the images discovered for the export",
+	}, []string{"namespace", "export"})
+
+	exportClusterImages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "images_sync_export_cluster_images",
+		Help: "Number of the ClusterImages of the export by their phase",
+	}, []string{"namespace", "export", "phase"})
+
+	exportDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "images_sync_export_duration_seconds",
+		Help: "Time from the start of the export until it completed or failed",
+		// 30 seconds up to about 17 hours
+		Buckets: prometheus.ExponentialBuckets(30, 2, 12),
+	}, []string{"storage", "result"})
+
+	exportsFinished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "images_sync_exports_finished_total",
+		Help: "Number of the exports which completed or failed",
+	}, []string{"namespace", "storage", "result"})
+
+	jobRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "images_sync_job_retries_total",
+		Help: "Number of the retried image export jobs",
+	}, []string{"namespace"})
+
+	uploadedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "images_sync_uploaded_bytes_total",
+		Help: "Size of the image tarballs uploaded to the storage",
+	}, []string{"storage"})
+
+	activeJobs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "images_sync_active_jobs",
+		Help: "Number of the image export jobs currently running",
+	})
+)
+
+// Phases of the ClusterImages reported by the images_sync_export_cluster_images metric
+const (
+	phasePending   = "pending"
+	phaseRunning   = "running"
+	phaseSucceeded = "succeeded"
+	phasePresent   = "present"
+	phaseFailed    = "failed"
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		exportImagesDiscovered,
+		exportClusterImages,
+		exportDuration,
+		exportsFinished,
+		jobRetries,
+		uploadedBytes,
+		activeJobs,
+	)
+}
+
+// recordImageCounters publishes the image counters of the export status
+func recordImageCounters(clusterImageExport *raczylocomv1.ClusterImageExport) {
+	status := clusterImageExport.Status
+	for phase, count := range map[string]int{
+		phasePending:   status.Pending,
+		phaseRunning:   status.Running,
+		phaseSucceeded: status.Succeeded,
+		phasePresent:   status.Present,
+		phaseFailed:    status.Failed,
+	} {
+		exportClusterImages.WithLabelValues(clusterImageExport.Namespace, clusterImageExport.Name, phase).Set(float64(count))
+	}
+}
+
+// recordExportFinished observes the duration and the result of the finished export
+func recordExportFinished(clusterImageExport *raczylocomv1.ClusterImageExport) {
+	status := clusterImageExport.Status
+	storage := clusterImageExport.Spec.Storage.StorageTarget
+	exportsFinished.WithLabelValues(clusterImageExport.Namespace, storage, status.Progress).Inc()
+	if status.StartTime != nil && status.CompletionTime != nil {
+		exportDuration.WithLabelValues(storage, status.Progress).Observe(status.CompletionTime.Sub(status.StartTime.Time).Seconds())
+	}
+}
+
+// forgetExportMetrics removes the series of the deleted export
+func forgetExportMetrics(clusterImageExport *raczylocomv1.ClusterImageExport) {
+	labels := prometheus.Labels{"namespace": clusterImageExport.Namespace, "export": clusterImageExport.Name}
+	exportImagesDiscovered.DeletePartialMatch(labels)
+	exportClusterImages.DeletePartialMatch(labels)
+}
+
+// recordUploadedBytes counts the size of the tarball uploaded by the export job, images found in the baseline are not uploaded
+func recordUploadedBytes(clusterImage *raczylocomv1.ClusterImage) {
+	if clusterImage.Status.Progress == shared.STATUS_SUCCESS && clusterImage.Status.Size > 0 {
+		uploadedBytes.WithLabelValues(clusterImage.Spec.Storage).Add(float64(clusterImage.Status.Size))
+	}
+}
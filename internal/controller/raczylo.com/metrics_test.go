@@ -0,0 +1,38 @@
+package raczylocom
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+	"github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
+)
+
+var _ = Describe("Metrics", func() {
+	It("should publish the image counters until the export is removed", func() {
+		clusterImageExport := &raczylocomv1.ClusterImageExport{
+			ObjectMeta: metav1.ObjectMeta{Name: "backup-metrics", Namespace: "default"},
+			Status:     raczylocomv1.ClusterImageExportStatus{Pending: 2, Succeeded: 3, Failed: 1},
+		}
+		recordImageCounters(clusterImageExport)
+		Expect(testutil.ToFloat64(exportClusterImages.WithLabelValues("default", "backup-metrics", phaseSucceeded))).To(Equal(3.0))
+		Expect(testutil.ToFloat64(exportClusterImages.WithLabelValues("default", "backup-metrics", phaseFailed))).To(Equal(1.0))
+
+		forgetExportMetrics(clusterImageExport)
+		Expect(exportClusterImages.DeleteLabelValues("default", "backup-metrics", phasePending)).To(BeFalse())
+	})
+
+	It("should count only the uploaded tarballs", func() {
+		uploaded := testutil.ToFloat64(uploadedBytes.WithLabelValues(shared.STORAGE_S3))
+		clusterImage := &raczylocomv1.ClusterImage{
+			Spec:   raczylocomv1.ClusterImageSpec{Storage: shared.STORAGE_S3},
+			Status: raczylocomv1.ClusterImageStatus{Progress: shared.STATUS_PRESENT, Size: 1024},
+		}
+		recordUploadedBytes(clusterImage)
+		clusterImage.Status.Progress = shared.STATUS_SUCCESS
+		recordUploadedBytes(clusterImage)
+		Expect(testutil.ToFloat64(uploadedBytes.WithLabelValues(shared.STORAGE_S3)) - uploaded).To(Equal(1024.0))
+	})
+})
@@ -0,0 +1,73 @@
+package raczylocom
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+	shared "github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
+)
+
+// discoverReferrers lists the signatures, attestations, SBOMs and other referrers of the image digest.
+// Images exported by the tag are looked up by the digest the tag points to now.
+func (r *ClusterImageReconciler) discoverReferrers(ctx context.Context, clusterImage *raczylocomv1.ClusterImage) ([]raczylocomv1.ClusterImageReferrer, error) {
+	auths, err := registryAuths(ctx, r.Client, clusterImage.Namespace, clusterImage.Spec.ImagePullSecrets)
+	if err != nil {
+		return nil, err
+	}
+	registryClient := shared.NewRegistryClient(auths)
+
+	digest := clusterImage.Spec.Sha
+	if digest == "" {
+		if digest, err = registryClient.ResolveDigest(ctx, clusterImage.Spec.Image, clusterImage.Spec.Tag); err != nil {
+			return nil, err
+		}
+	}
+	return registryClient.Referrers(ctx, clusterImage.Spec.Image, digest)
+}
+
+// imageReferrers returns the referrers of the image. Image whose referrers can't be discovered is exported
+// without them, so the unavailable referrers API doesn't keep the image pending.
+func (r *ClusterImageReconciler) imageReferrers(ctx context.Context, clusterImage *raczylocomv1.ClusterImage) []raczylocomv1.ClusterImageReferrer {
+	referrers, err := r.discoverReferrers(ctx, clusterImage)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to discover the referrers", "image", clusterImage.Spec.FullName)
+		r.Recorder.Eventf(clusterImage, corev1.EventTypeWarning, shared.EVENT_NO_REFERRERS, "Unable to discover the referrers, the image is exported without them: %s", err)
+		return nil
+	}
+	return referrers
+}
+
+// referrerCommands returns the commands storing every referrer in its own oci-archive in the referrers directory
+// next to the image and records the location in the referrer. The commands run before the image is exported,
+// so the termination message is written by the export of the image itself.
+func referrerCommands(referrers []raczylocomv1.ClusterImageReferrer, image string, referrersDir string, exportParams string) []string {
+	commands := []string{}
+	for i := range referrers {
+		referrer := &referrers[i]
+		archive := "/tmp/" + shared.ReferrersTag(referrer.Digest) + ".tar"
+		referrer.Artifact = referrersDir + "/" + shared.ReferrersTag(referrer.Digest) + ".tar"
+		commands = append(commands,
+			"skopeo copy --preserve-digests --retry-times=3 'docker://"+image+"@"+referrer.Digest+"' '"+shared.FORMAT_OCI_ARCHIVE+":"+archive+"'",
+			"./export.py "+exportParams+"'"+archive+"' '"+referrer.Artifact+"'",
+			"rm -f "+archive,
+		)
+	}
+	return commands
+}
+
+// mirrorReferrerArgs returns the mirror.py arguments copying the referrers found by the referrers API, the cosign
+// artifacts are copied by their tags anyway. Records the location of each referrer in the destination repository.
+func mirrorReferrerArgs(referrers []raczylocomv1.ClusterImageReferrer, destination string) string {
+	args := ""
+	for i := range referrers {
+		referrer := &referrers[i]
+		referrer.Artifact = "docker://" + shared.ImageRepository(destination) + "@" + referrer.Digest
+		if referrer.Tag == "" {
+			args += " --referrer='" + referrer.Digest + "'"
+		}
+	}
+	return args
+}
@@ -3,9 +3,14 @@ package shared
 import (
 	"regexp"
 	"strings"
+	"time"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
 )
 
-var BACKUP_JOB_IMAGE = "ghcr.io/lukaszraczylo/kubernetes-images-sync-worker:1.0.2"
+// BACKUP_JOB_IMAGE is the worker image of the jobs. Release builds pin it to the worker of the same
+// version with -ldflags, the --worker-image flag of the manager overrides it
+var BACKUP_JOB_IMAGE = "ghcr.io/lukaszraczylo/kubernetes-images-sync-worker:latest"
 
 const (
 	// AVAILABLE STATUSES
@@ -16,12 +21,148 @@ const (
 	STATUS_FAILED   = "FAILED"
 	STATUS_SUCCESS  = "COMPLETED"
 	STATUS_PRESENT  = "PRESENT"
+	// Export completed with the failed images tolerated by its failure policy
+	STATUS_COMPLETED_WITH_ERRORS = "COMPLETED_WITH_ERRORS"
+	// Dry run export finishes once the plan is written
+	STATUS_PLANNED = "PLANNED"
 
 	// STORAGE DEFINITIONS
 	STORAGE_S3   = "S3"
-	STORAGE_FILE = "FILE"
+	STORAGE_FILE = "file"
+	// Volume of the file target is mounted here, base path is relative to it
+	FILE_STORAGE_PATH = "/home/runner/storage"
+	// Keys of the S3 credentials in the storage secret, passed to the worker as the env vars of the same name
+	S3_ACCESS_KEY_ID     = "AWS_ACCESS_KEY_ID"
+	S3_SECRET_ACCESS_KEY = "AWS_SECRET_ACCESS_KEY"
+	// Registry target mirrors the images directly, there are no tarballs and no manifest in the storage
+	STORAGE_REGISTRY            = "registry"
+	DEFAULT_REPOSITORY_TEMPLATE = "{{ .Repository }}"
+
+	// FORMAT DEFINITIONS
+	FORMAT_DOCKER_ARCHIVE = "docker-archive"
+	FORMAT_OCI_ARCHIVE    = "oci-archive"
+	FORMAT_OCI_LAYOUT     = "oci-layout"
+	// OCI layout is stored in this directory of the export root
+	OCI_LAYOUT_DIR = "oci"
+	// Keys of the OCI layout files in the manifest ConfigMap
+	OCI_INDEX_KEY  = "oci-index.json"
+	OCI_LAYOUT_KEY = "oci-layout"
+	// Platforms value exporting the whole manifest list
+	PLATFORM_ALL = "all"
+
+	// REFERRER DEFINITIONS
+	REFERRER_SIGNATURE   = "signature"
+	REFERRER_ATTESTATION = "attestation"
+	REFERRER_SBOM        = "sbom"
+	REFERRER_OTHER       = "referrer"
+	// Referrers are stored in the directory named after the image artifact with this suffix
+	REFERRERS_DIR_SUFFIX = ".referrers"
+
+	// BASELINE DEFINITIONS
+	BASELINE_LATEST    = "latest"
+	MANIFEST_FILE      = "manifest.json"
+	MANIFEST_YAML_FILE = "manifest.yaml"
+	// ConfigMap is limited to 1 MiB, the rest is left for its metadata
+	MANIFEST_CONFIGMAP_MAX_SIZE = 1000 * 1024
+
+	// IMPORT DEFINITIONS
+	// Prefix of the ConfigMap holding the manifest of the import
+	IMPORT_MANIFEST_PREFIX = "import-manifest-"
+
+	// FILTER DEFINITIONS
+	FILTER_FIELD_REGISTRY     = "registry"
+	FILTER_FIELD_REPOSITORY   = "repository"
+	FILTER_FIELD_TAG          = "tag"
+	FILTER_FIELD_DIGEST       = "digest"
+	FILTER_FIELD_FULL_NAME    = "fullName"
+	FILTER_MATCH_SUBSTRING    = "substring"
+	FILTER_MATCH_GLOB         = "glob"
+	FILTER_MATCH_REGEX        = "regex"
+	FILTER_MATCH_SEMVER       = "semver"
+	FILTER_PRECEDENCE_INCLUDE = "include"
+
+	// PLAN DEFINITIONS
+	PLAN_FILE    = "plan.json"
+	PLAN_EXPORT  = "export"
+	PLAN_PRESENT = "present"
+	// Registries are asked for the size of this many new images at most, the rest is left with the unknown size
+	PLAN_MAX_SIZE_LOOKUPS = 50
+
+	// DISCOVERY DEFINITIONS
+	SOURCE_SPEC           = "spec"
+	SOURCE_RUNNING        = "running"
+	DEFAULT_POD_SPEC_PATH = "{.spec.template.spec}"
+
+	// Number of the finished export attempts kept in the ClusterImage status
+	MAX_ATTEMPTS_HISTORY = 5
+
+	// JOB DEFINITIONS
+	// Label of the image export jobs with the UID of their export, running jobs are counted against the limits by it
+	EXPORT_UID_LABEL = "raczylo.com/export-uid"
+	// Label of the image import jobs with the UID of their import, the jobs keep the progress of the imported images
+	IMPORT_UID_LABEL = "raczylo.com/import-uid"
+
+	// RETRY DEFINITIONS
+	DEFAULT_MAX_RETRIES     = 3
+	DEFAULT_INITIAL_BACKOFF = 30 * time.Second
+	DEFAULT_MAX_BACKOFF     = 10 * time.Minute
+	// Changing the value of this annotation retries the failed images of the export and resumes it
+	RETRY_FAILED_ANNOTATION = "raczylo.com/retry-failed"
+	// Classes of the job failures, recognised by the termination message of the failed container
+	FAILURE_RATE_LIMITED = "RateLimited"
+	FAILURE_UNAUTHORIZED = "Unauthorized"
+	FAILURE_NOT_FOUND    = "NotFound"
+	FAILURE_OTHER        = "Other"
+	// Failure policies of the export
+	FAILURE_POLICY_FAIL_FAST = "FailFast"
+	FAILURE_POLICY_CONTINUE  = "Continue"
+	FAILURE_POLICY_THRESHOLD = "Threshold"
+
+	// CONDITION DEFINITIONS
+	CONDITION_DISCOVERED = "Discovered"
+	CONDITION_EXPORTING  = "Exporting"
+	CONDITION_READY      = "Ready"
+	CONDITION_DEGRADED   = "Degraded"
+	// Reasons of the conditions
+	REASON_PENDING          = "Pending"
+	REASON_DISCOVERED       = "ImagesDiscovered"
+	REASON_DISCOVERY_FAILED = "DiscoveryFailed"
+	REASON_EXPORTING        = "ExportInProgress"
+	REASON_COMPLETED        = "ExportCompleted"
+	REASON_COMPLETED_ERRORS = "CompletedWithErrors"
+	REASON_RESUMED          = "Resumed"
+	REASON_PLANNED          = "Planned"
+	REASON_INVALID_SPEC     = "InvalidSpec"
+	REASON_IMAGE_FAILED     = "ImageExportFailed"
+	REASON_MANIFEST_FAILED  = "ManifestFailed"
+
+	// EVENT REASONS, the export completion events use the reasons of the conditions
+	EVENT_DISCOVERED        = "Discovered"
+	EVENT_IMAGE_CREATED     = "ClusterImageCreated"
+	EVENT_JOB_STARTED       = "JobStarted"
+	EVENT_RETRYING          = "Retrying"
+	EVENT_FAILED            = "Failed"
+	EVENT_PRESENT           = "Present"
+	EVENT_EXPORTED          = "Exported"
+	EVENT_CLEANUP_STARTED   = "CleanupStarted"
+	EVENT_CLEANUP_COMPLETED = "CleanupCompleted"
+	EVENT_CLEANUP_FAILED    = "CleanupFailed"
+	EVENT_RESUMED           = "Resumed"
+	EVENT_PLANNED           = "Planned"
+	EVENT_NO_REFERRERS      = "ReferrersUnavailable"
+	EVENT_WORKLOAD_SKIPPED  = "CustomWorkloadSkipped"
+	EVENT_BASELINE_IN_USE   = "BaselineInUse"
+
+	// SCHEDULE DEFINITIONS
+	SCHEDULED_AT_ANNOTATION = "raczylo.com/scheduled-at"
+	SCHEDULE_OWNER_KEY      = ".metadata.scheduleOwner"
 )
 
+// ExportFinished returns true once the export reached its final state
+func ExportFinished(progress string) bool {
+	return progress == STATUS_SUCCESS || progress == STATUS_FAILED || progress == STATUS_COMPLETED_WITH_ERRORS || progress == STATUS_PLANNED
+}
+
 type Container struct {
 	Image          string `json:"image"`
 	Tag            string `json:"tag"`
@@ -34,6 +175,66 @@ type ContainersList struct {
 	Containers []Container `json:"containers"`
 }
 
+// ExportManifest is stored in the export root and lists all the exported images
+type ExportManifest struct {
+	ExportName  string          `json:"exportName"`
+	Format      string          `json:"format,omitempty"`
+	Baseline    string          `json:"baseline,omitempty"`
+	GeneratedAt time.Time       `json:"generatedAt"`
+	Images      []ManifestImage `json:"images"`
+}
+
+type ManifestImage struct {
+	FullName       string `json:"fullName"`
+	Image          string `json:"image"`
+	Tag            string `json:"tag,omitempty"`
+	Sha            string `json:"sha,omitempty"`
+	ImageNamespace string `json:"imageNamespace,omitempty"`
+	Artifact       string `json:"artifact"`
+	Size           int64  `json:"size,omitempty"`
+	Sha256         string `json:"sha256,omitempty"`
+	MediaType      string `json:"mediaType,omitempty"`
+	Present        bool   `json:"present,omitempty"`
+	// Platforms stored in the artifact, empty for the platform of the node the job ran on
+	Platforms []string `json:"platforms,omitempty"`
+	// Signatures, attestations, SBOMs and other referrers of the image digest
+	Referrers []raczylocomv1.ClusterImageReferrer `json:"referrers,omitempty"`
+}
+
+// ExportPlan is stored in the plan ConfigMap of the dry run export and lists the images it would export
+type ExportPlan struct {
+	ExportName    string         `json:"exportName"`
+	Baseline      string         `json:"baseline,omitempty"`
+	GeneratedAt   time.Time      `json:"generatedAt"`
+	EstimatedSize int64          `json:"estimatedSize"`
+	Images        []PlannedImage `json:"images"`
+}
+
+type PlannedImage struct {
+	FullName       string `json:"fullName"`
+	Image          string `json:"image"`
+	Tag            string `json:"tag,omitempty"`
+	Sha            string `json:"sha,omitempty"`
+	ImageNamespace string `json:"imageNamespace,omitempty"`
+	// export or present
+	Decision string `json:"decision"`
+	// Why the image would not be exported again
+	Reason string `json:"reason,omitempty"`
+	// Size known from the earlier exports or the registry, 0 when unknown
+	Size int64 `json:"size,omitempty"`
+}
+
+// JobResult is reported by the worker through the container termination message
+type JobResult struct {
+	Present   bool   `json:"present,omitempty"`
+	Artifact  string `json:"artifact,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Sha256    string `json:"sha256,omitempty"`
+	MediaType string `json:"mediaType,omitempty"`
+	// Referrers stored by the baseline export for the present image
+	Referrers []raczylocomv1.ClusterImageReferrer `json:"referrers,omitempty"`
+}
+
 func RemoveDuplicates(containersList ContainersList) ContainersList {
 	// remove duplicates from the list
 	encountered := map[Container]bool{}
@@ -97,6 +298,24 @@ func NormalizeImageName(name string) string {
 	return strings.Trim(normalized, "-")
 }
 
+// ArtifactName returns the name of the stored image tarball. Images referenced by tag get the resolved
+// digest appended, so the exports of the moving tag can be told apart.
+func ArtifactName(fullName string, sha string) string {
+	name := NormalizeImageName(fullName)
+	if sha != "" && !strings.Contains(fullName, "@") {
+		name += "-" + NormalizeImageName(sha)
+	}
+	return name
+}
+
+// PullReference returns the image reference pinned to the digest when it's known
+func PullReference(container Container) string {
+	if container.Sha != "" && !strings.Contains(container.FullName, "@") {
+		return container.Image + "@" + container.Sha
+	}
+	return container.FullName
+}
+
 // filterOnlyFromNamespaces filters out containers from namespaces that are not in the list
 func FilterOnlyFromNamespaces(containers ContainersList, namespaces []string) ContainersList {
 	result := ContainersList{}
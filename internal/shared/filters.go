@@ -0,0 +1,147 @@
+package shared
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/blang/semver/v4"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+)
+
+// imageMatcher reports whether the image matches the filter rule
+type imageMatcher func(container Container) bool
+
+// FilterImages returns the images selected by the filter rules of the export
+func FilterImages(containers ContainersList, filters raczylocomv1.ImageFilters) (ContainersList, error) {
+	if len(filters.Include) == 0 && len(filters.Exclude) == 0 {
+		return containers, nil
+	}
+	includes, err := compileImageFilters(filters.Include)
+	if err != nil {
+		return ContainersList{}, fmt.Errorf("include: %w", err)
+	}
+	excludes, err := compileImageFilters(filters.Exclude)
+	if err != nil {
+		return ContainersList{}, fmt.Errorf("exclude: %w", err)
+	}
+
+	result := ContainersList{}
+	for _, container := range containers.Containers {
+		included := matchesAny(includes, container)
+		excluded := matchesAny(excludes, container)
+		var keep bool
+		if filters.Precedence == FILTER_PRECEDENCE_INCLUDE {
+			// Include rules make the exceptions from the exclude rules
+			keep = included || !excluded
+		} else {
+			keep = (len(includes) == 0 || included) && !excluded
+		}
+		if keep {
+			result.Containers = append(result.Containers, container)
+		}
+	}
+	return result, nil
+}
+
+// ValidateImageFilters returns the error of the first invalid rule
+func ValidateImageFilters(filters []raczylocomv1.ImageFilter) error {
+	_, err := compileImageFilters(filters)
+	return err
+}
+
+func matchesAny(matchers []imageMatcher, container Container) bool {
+	for _, matches := range matchers {
+		if matches(container) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileImageFilters(filters []raczylocomv1.ImageFilter) ([]imageMatcher, error) {
+	matchers := make([]imageMatcher, 0, len(filters))
+	for i, filter := range filters {
+		matcher, err := compileImageFilter(filter)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+func compileImageFilter(filter raczylocomv1.ImageFilter) (imageMatcher, error) {
+	field := filter.Field
+	if field == "" {
+		field = FILTER_FIELD_REPOSITORY
+	}
+	value, err := filterField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	switch filter.Match {
+	case FILTER_MATCH_SUBSTRING:
+		substring := strings.ToLower(filter.Value)
+		return func(container Container) bool {
+			return strings.Contains(strings.ToLower(value(container)), substring)
+		}, nil
+	case FILTER_MATCH_REGEX:
+		pattern, err := regexp.Compile(filter.Value)
+		if err != nil {
+			return nil, err
+		}
+		return func(container Container) bool { return pattern.MatchString(value(container)) }, nil
+	case FILTER_MATCH_SEMVER:
+		versionRange, err := semver.ParseRange(filter.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid semver range %q: %w", filter.Value, err)
+		}
+		return func(container Container) bool {
+			version, err := semver.ParseTolerant(value(container))
+			return err == nil && versionRange(version)
+		}, nil
+	case FILTER_MATCH_GLOB, "":
+		pattern, err := globRegexp(filter.Value)
+		if err != nil {
+			return nil, err
+		}
+		return func(container Container) bool { return pattern.MatchString(value(container)) }, nil
+	default:
+		return nil, fmt.Errorf("unknown match type %q", filter.Match)
+	}
+}
+
+// filterField returns the function reading the matched field of the image reference
+func filterField(field string) (func(Container) string, error) {
+	switch field {
+	case FILTER_FIELD_REGISTRY:
+		return func(container Container) string {
+			registry, _ := ParseImageReference(container.Image)
+			return registry
+		}, nil
+	case FILTER_FIELD_REPOSITORY:
+		return func(container Container) string {
+			_, repository := ParseImageReference(container.Image)
+			return repository
+		}, nil
+	case FILTER_FIELD_TAG:
+		return func(container Container) string { return container.Tag }, nil
+	case FILTER_FIELD_DIGEST:
+		return func(container Container) string { return container.Sha }, nil
+	case FILTER_FIELD_FULL_NAME:
+		return func(container Container) string { return container.FullName }, nil
+	default:
+		return nil, fmt.Errorf("unknown field %q", field)
+	}
+}
+
+// globRegexp turns the glob into the regexp matching the whole value, * matches any characters including /
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	pattern := regexp.QuoteMeta(glob)
+	pattern = strings.ReplaceAll(pattern, `\*`, ".*")
+	pattern = strings.ReplaceAll(pattern, `\?`, ".")
+	return regexp.Compile("^" + pattern + "$")
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shared
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+)
+
+var _ = Describe("Image filters", func() {
+	images := ContainersList{}
+	for _, name := range []string{
+		"nginx:1.27.1",
+		"docker.io/acme/my-nginx-exporter:0.11.0",
+		"ghcr.io/acme/api:2.3.0",
+		"ghcr.io/acme/worker:v1.4",
+		"quay.io/prometheus/node-exporter@sha256:4cb2b9019f1c4d2e6e7b5d33b9c1e1c7c7e5a8a1a5a3cbb8a8d0d0b3d6b9f1e2",
+	} {
+		container, err := ProcessContainerName(name)
+		Expect(err).NotTo(HaveOccurred())
+		images.Containers = append(images.Containers, container)
+	}
+	fullNames := func(containers ContainersList) []string {
+		names := []string{}
+		for _, container := range containers.Containers {
+			names = append(names, container.FullName)
+		}
+		return names
+	}
+
+	It("should match the whole repository with the glob", func() {
+		filtered, err := FilterImages(images, raczylocomv1.ImageFilters{
+			Exclude: []raczylocomv1.ImageFilter{{Value: "library/nginx"}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fullNames(filtered)).To(ContainElement("docker.io/acme/my-nginx-exporter:0.11.0"))
+		Expect(fullNames(filtered)).NotTo(ContainElement("nginx:1.27.1"))
+	})
+
+	It("should match the registry, the tag range and the digest", func() {
+		filtered, err := FilterImages(images, raczylocomv1.ImageFilters{
+			Include: []raczylocomv1.ImageFilter{
+				{Field: FILTER_FIELD_REGISTRY, Value: "ghcr.io"},
+				{Field: FILTER_FIELD_DIGEST, Match: FILTER_MATCH_SUBSTRING, Value: "SHA256:4CB2"},
+			},
+			Exclude: []raczylocomv1.ImageFilter{{Field: FILTER_FIELD_TAG, Match: FILTER_MATCH_SEMVER, Value: ">=2.0.0"}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fullNames(filtered)).To(ConsistOf(
+			"ghcr.io/acme/worker:v1.4",
+			"quay.io/prometheus/node-exporter@sha256:4cb2b9019f1c4d2e6e7b5d33b9c1e1c7c7e5a8a1a5a3cbb8a8d0d0b3d6b9f1e2",
+		))
+	})
+
+	It("should let the include rules make exceptions from the exclude rules", func() {
+		filters := raczylocomv1.ImageFilters{
+			Include: []raczylocomv1.ImageFilter{{Field: FILTER_FIELD_FULL_NAME, Match: FILTER_MATCH_REGEX, Value: `/api:`}},
+			Exclude: []raczylocomv1.ImageFilter{{Field: FILTER_FIELD_REGISTRY, Value: "*.io"}},
+		}
+		filtered, err := FilterImages(images, filters)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fullNames(filtered)).To(BeEmpty())
+
+		filters.Precedence = FILTER_PRECEDENCE_INCLUDE
+		filtered, err = FilterImages(images, filters)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fullNames(filtered)).To(ConsistOf("ghcr.io/acme/api:2.3.0"))
+	})
+
+	It("should reject the invalid rules", func() {
+		Expect(ValidateImageFilters([]raczylocomv1.ImageFilter{{Match: FILTER_MATCH_REGEX, Value: "("}})).NotTo(Succeed())
+		Expect(ValidateImageFilters([]raczylocomv1.ImageFilter{{Match: FILTER_MATCH_SEMVER, Value: "latest"}})).NotTo(Succeed())
+		Expect(ValidateImageFilters([]raczylocomv1.ImageFilter{{Field: "host", Value: "ghcr.io"}})).NotTo(Succeed())
+		Expect(ValidateImageFilters([]raczylocomv1.ImageFilter{{Field: FILTER_FIELD_TAG, Value: "1.*"}})).To(Succeed())
+	})
+})
@@ -1,26 +1,41 @@
 package shared
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"path"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type JobParams struct {
-	Name             string
-	Namespace        string
-	Annotations      map[string]string
+	Name        string
+	Namespace   string
+	Annotations map[string]string
+	// Labels added to the job on top of app=image-export
+	Labels           map[string]string
 	Image            string
 	Commands         []string
 	EnvVars          []corev1.EnvVar
 	OwnerReferences  []metav1.OwnerReference
 	ServiceAccount   string
 	ImagePullSecrets []corev1.LocalObjectReference
+	// Storage of the export, the volume of the file target is mounted into the job
+	Storage      *raczylocomv1.ClusterImageStorageSpec
+	Volumes      []corev1.Volume
+	VolumeMounts []corev1.VolumeMount
+	// Run the pod once and fail the job with it, the controller retries the failed attempts itself
+	SingleAttempt bool
 }
 
 func CreateJob[T any](params JobParams, setupFunc func(T) []string) *batchv1.Job {
@@ -45,17 +60,38 @@ func CreateJob[T any](params JobParams, setupFunc func(T) []string) *batchv1.Job
 		}
 	}
 
+	if params.Storage != nil {
+		storageVolumes, storageVolumeMounts := StorageVolumes(*params.Storage)
+		volumes = append(volumes, storageVolumes...)
+		volumeMounts = append(volumeMounts, storageVolumeMounts...)
+	}
+
+	volumes = append(volumes, params.Volumes...)
+	volumeMounts = append(volumeMounts, params.VolumeMounts...)
+
+	labels := map[string]string{}
+	for key, value := range params.Labels {
+		labels[key] = value
+	}
+	labels["app"] = "image-export"
+
+	restartPolicy := corev1.RestartPolicyOnFailure
+	var backoffLimit *int32
+	if params.SingleAttempt {
+		restartPolicy = corev1.RestartPolicyNever
+		backoffLimit = pointer.Int32(0)
+	}
+
 	j := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:            params.Name,
 			Namespace:       params.Namespace,
 			OwnerReferences: params.OwnerReferences,
-			Labels: map[string]string{
-				"app": "image-export",
-			},
-			Annotations: params.Annotations,
+			Labels:          labels,
+			Annotations:     params.Annotations,
 		},
 		Spec: batchv1.JobSpec{
+			BackoffLimit: backoffLimit,
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Labels: map[string]string{
@@ -64,7 +100,7 @@ func CreateJob[T any](params JobParams, setupFunc func(T) []string) *batchv1.Job
 					Annotations: params.Annotations,
 				},
 				Spec: corev1.PodSpec{
-					RestartPolicy:      corev1.RestartPolicyOnFailure,
+					RestartPolicy:      restartPolicy,
 					ServiceAccountName: params.ServiceAccount,
 					ImagePullSecrets:   params.ImagePullSecrets,
 					Volumes:            volumes,
@@ -77,6 +113,8 @@ func CreateJob[T any](params JobParams, setupFunc func(T) []string) *batchv1.Job
 							Args:         []string{"/bin/bash", "-c", strings.Join(params.Commands, " && ")},
 							VolumeMounts: volumeMounts,
 							Env:          params.EnvVars,
+							// Failed containers report the tail of their output, so the error outlives the job
+							TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
 							SecurityContext: &corev1.SecurityContext{
 								Privileged: pointer.Bool(true),
 							},
@@ -93,7 +131,7 @@ func SetupS3Params(s3Config raczylocomv1.ClusterImageStorageS3) []string {
 	params := []string{}
 	if s3Config.UseRole {
 		params = append(params, "--use_role")
-	} else {
+	} else if s3Config.SecretName == "" {
 		params = append(params, fmt.Sprintf("--aws_access_key_id='%s'", s3Config.AccessKey))
 		params = append(params, fmt.Sprintf("--aws_secret_access_key='%s'", s3Config.SecretKey))
 	}
@@ -108,3 +146,215 @@ func SetupS3Params(s3Config raczylocomv1.ClusterImageStorageS3) []string {
 	}
 	return params
 }
+
+// StorageEnvVars returns the S3 credentials of the storage secret as the env vars of the worker,
+// so they don't show up in the job spec
+func StorageEnvVars(storage raczylocomv1.ClusterImageStorageSpec) []corev1.EnvVar {
+	if storage.StorageTarget != STORAGE_S3 || storage.S3.UseRole || storage.S3.SecretName == "" {
+		return nil
+	}
+	envVars := []corev1.EnvVar{}
+	for _, key := range []string{S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY} {
+		envVars = append(envVars, corev1.EnvVar{
+			Name: key,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: storage.S3.SecretName},
+					Key:                  key,
+				},
+			},
+		})
+	}
+	return envVars
+}
+
+// ValidateStorageCredentials rejects the inline S3 keys when they are forbidden by the operator policy
+func ValidateStorageCredentials(storage raczylocomv1.ClusterImageStorageSpec, forbidInline bool) error {
+	if storage.StorageTarget != STORAGE_S3 || !forbidInline {
+		return nil
+	}
+	if storage.S3.AccessKey != "" || storage.S3.SecretKey != "" {
+		return fmt.Errorf("inline S3 credentials are forbidden, use secretName or useRole instead")
+	}
+	return nil
+}
+
+// CheckStorageSecret ensures the storage secret exists and holds the S3 credentials
+func CheckStorageSecret(ctx context.Context, c client.Client, namespace string, storage raczylocomv1.ClusterImageStorageSpec) error {
+	if StorageEnvVars(storage) == nil {
+		return nil
+	}
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: storage.S3.SecretName}, secret); err != nil {
+		return fmt.Errorf("unable to get storage secret %s: %w", storage.S3.SecretName, err)
+	}
+	for _, key := range []string{S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY} {
+		if len(secret.Data[key]) == 0 {
+			return fmt.Errorf("storage secret %s has no %s", storage.S3.SecretName, key)
+		}
+	}
+	return nil
+}
+
+// StorageLocation returns the root of the export within the storage, e.g. s3://bucket/images/backup-20240901
+func StorageLocation(storage raczylocomv1.ClusterImageStorageSpec, basePath string, exportName string) string {
+	if storage.StorageTarget == STORAGE_S3 {
+		return "s3://" + storage.S3.Bucket + basePath + "/" + exportName
+	}
+	return path.Join(FILE_STORAGE_PATH, basePath, exportName)
+}
+
+// StorageVolumes returns the volume of the file target with its mount
+func StorageVolumes(storage raczylocomv1.ClusterImageStorageSpec) ([]corev1.Volume, []corev1.VolumeMount) {
+	if storage.StorageTarget != STORAGE_FILE {
+		return nil, nil
+	}
+	volume := corev1.Volume{
+		Name: "storage",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: storage.File.PersistentVolumeClaim,
+			NFS:                   storage.File.NFS,
+			HostPath:              storage.File.HostPath,
+		},
+	}
+	mount := corev1.VolumeMount{
+		Name:      "storage",
+		MountPath: FILE_STORAGE_PATH,
+	}
+	return []corev1.Volume{volume}, []corev1.VolumeMount{mount}
+}
+
+// ValidateStorage checks the storage is usable before the export or import starts
+func ValidateStorage(storage raczylocomv1.ClusterImageStorageSpec, forbidInline bool) error {
+	if storage.StorageTarget == STORAGE_FILE {
+		sources := 0
+		for _, set := range []bool{storage.File.PersistentVolumeClaim != nil, storage.File.NFS != nil, storage.File.HostPath != nil} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fmt.Errorf("file storage requires exactly one of persistentVolumeClaim, nfs or hostPath")
+		}
+	}
+	return ValidateStorageCredentials(storage, forbidInline)
+}
+
+// ManifestFromConfigMap reads the export manifest stored in the ConfigMap
+func ManifestFromConfigMap(configMap *corev1.ConfigMap) (*ExportManifest, error) {
+	data, ok := configMap.Data[MANIFEST_FILE]
+	if !ok || data == "" {
+		return nil, fmt.Errorf("manifest not found in ConfigMap %s", configMap.Name)
+	}
+	manifest := &ExportManifest{}
+	if err := json.Unmarshal([]byte(data), manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest in ConfigMap %s: %w", configMap.Name, err)
+	}
+	return manifest, nil
+}
+
+// OCIDescriptor points to the image manifest within the OCI layout
+type OCIDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// OCIIndex is the index.json of the OCI layout
+type OCIIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []OCIDescriptor `json:"manifests"`
+}
+
+// BuildOCIIndex lists the images of the manifest stored in the OCI layout, images stored elsewhere
+// (e.g. by the baseline export) are left out
+func BuildOCIIndex(manifest *ExportManifest, layoutRoot string) OCIIndex {
+	index := OCIIndex{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.index.v1+json",
+		Manifests:     []OCIDescriptor{},
+	}
+	for _, image := range manifest.Images {
+		root, digest, ok := OCILayoutBlob(image.Artifact)
+		if !ok || root != layoutRoot || image.MediaType == "" {
+			continue
+		}
+		index.Manifests = append(index.Manifests, OCIDescriptor{
+			MediaType:   image.MediaType,
+			Digest:      digest,
+			Size:        image.Size,
+			Annotations: map[string]string{"org.opencontainers.image.ref.name": image.FullName},
+		})
+	}
+	return index
+}
+
+// OCILayoutBlob splits the location of the blob into the root of the OCI layout and the digest of the blob
+func OCILayoutBlob(artifact string) (string, string, bool) {
+	root, blob, found := strings.Cut(artifact, "/blobs/")
+	if !found {
+		return "", "", false
+	}
+	algorithm, encoded, found := strings.Cut(blob, "/")
+	if !found || encoded == "" || strings.Contains(encoded, "/") {
+		return "", "", false
+	}
+	return root, algorithm + ":" + encoded, true
+}
+
+// Termination messages of the failed jobs are matched against these, first match wins.
+// Patterns match the errors of the registry only, so the failures of the job itself, e.g. "permission denied"
+// writing the tarball or "command not found", are not mistaken for them.
+var failureClasses = []struct {
+	class   string
+	pattern *regexp.Regexp
+}{
+	{FAILURE_RATE_LIMITED, regexp.MustCompile(`(?i)toomanyrequests|\b429 too many requests|pull rate limit`)},
+	{FAILURE_UNAUTHORIZED, regexp.MustCompile(`(?i)unauthorized: |authentication required|denied: requested access|requested access to the resource is denied|\b401 unauthorized|\b403 forbidden`)},
+	{FAILURE_NOT_FOUND, regexp.MustCompile(`(?i)manifest unknown|name unknown|repository \S+ not found|no such image|\b404 not found`)},
+}
+
+// ClassifyFailure returns the class of the job failure from the termination message of the failed container
+func ClassifyFailure(message string) string {
+	for _, failure := range failureClasses {
+		if failure.pattern.MatchString(message) {
+			return failure.class
+		}
+	}
+	return FAILURE_OTHER
+}
+
+// RetryBackoff returns the delay before the next attempt of the failed image, false means the image is not retried.
+// The delay doubles with every retry up to the maximum backoff, rate limited images wait the maximum right away.
+func RetryBackoff(policy raczylocomv1.RetryPolicy, failureClass string, retryCount int) (time.Duration, bool) {
+	maxRetries := DEFAULT_MAX_RETRIES
+	if policy.MaxRetries != nil {
+		maxRetries = *policy.MaxRetries
+	}
+	retryOn := policy.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = []string{FAILURE_RATE_LIMITED, FAILURE_OTHER}
+	}
+	if retryCount >= maxRetries || !slices.Contains(retryOn, failureClass) {
+		return 0, false
+	}
+
+	initialBackoff := DEFAULT_INITIAL_BACKOFF
+	if policy.InitialBackoff != nil {
+		initialBackoff = policy.InitialBackoff.Duration
+	}
+	maxBackoff := DEFAULT_MAX_BACKOFF
+	if policy.MaxBackoff != nil {
+		maxBackoff = policy.MaxBackoff.Duration
+	}
+	if failureClass == FAILURE_RATE_LIMITED {
+		return maxBackoff, true
+	}
+	backoff := initialBackoff
+	for i := 0; i < retryCount && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxBackoff), true
+}
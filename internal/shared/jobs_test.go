@@ -0,0 +1,206 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shared
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+)
+
+var _ = Describe("Manifest ConfigMap", func() {
+	It("should read the manifest", func() {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "import-manifest-b"},
+			Data: map[string]string{
+				MANIFEST_FILE: `{"exportName":"b","generatedAt":"2024-09-01T02:13:54Z","images":[{"fullName":"nginx:1.27","image":"nginx","artifact":"s3://backup/images/b/nginx-1.27.tar"}]}`,
+			},
+		}
+
+		manifest, err := ManifestFromConfigMap(configMap)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.ExportName).To(Equal("b"))
+		Expect(manifest.Images).To(HaveLen(1))
+		Expect(manifest.Images[0].Artifact).To(Equal("s3://backup/images/b/nginx-1.27.tar"))
+	})
+
+	It("should fail when the manifest is missing", func() {
+		_, err := ManifestFromConfigMap(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "import-manifest-b"}})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("OCI layout", func() {
+	It("should split the blob location", func() {
+		root, digest, ok := OCILayoutBlob("s3://backup/images/b/oci/blobs/sha256/abcd")
+		Expect(ok).To(BeTrue())
+		Expect(root).To(Equal("s3://backup/images/b/oci"))
+		Expect(digest).To(Equal("sha256:abcd"))
+
+		_, _, ok = OCILayoutBlob("s3://backup/images/b/nginx-1.27.tar")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should index only the images stored in the layout of the export", func() {
+		manifest := &ExportManifest{
+			Images: []ManifestImage{
+				{FullName: "nginx:1.27", Artifact: "/images/b/oci/blobs/sha256/1111", Size: 1024, MediaType: "application/vnd.oci.image.manifest.v1+json"},
+				{FullName: "busybox:1.36", Artifact: "/images/a/oci/blobs/sha256/2222", Size: 512, MediaType: "application/vnd.oci.image.manifest.v1+json", Present: true},
+				{FullName: "redis:7", Artifact: "/images/a/redis-7.tar"},
+			},
+		}
+
+		index := BuildOCIIndex(manifest, "/images/b/oci")
+		Expect(index.SchemaVersion).To(Equal(2))
+		Expect(index.Manifests).To(Equal([]OCIDescriptor{
+			{
+				MediaType:   "application/vnd.oci.image.manifest.v1+json",
+				Digest:      "sha256:1111",
+				Size:        1024,
+				Annotations: map[string]string{"org.opencontainers.image.ref.name": "nginx:1.27"},
+			},
+		}))
+	})
+})
+
+var _ = Describe("Storage credentials", func() {
+	storage := func(s3 raczylocomv1.ClusterImageStorageS3) raczylocomv1.ClusterImageStorageSpec {
+		return raczylocomv1.ClusterImageStorageSpec{StorageTarget: STORAGE_S3, S3: s3}
+	}
+
+	It("should keep the keys out of the command line when the secret is used", func() {
+		s3 := raczylocomv1.ClusterImageStorageS3{Bucket: "backup", Region: "us-west-2", SecretName: "s3-credentials", AccessKey: "yyy", SecretKey: "zzz"}
+		Expect(SetupS3Params(s3)).To(Equal([]string{"--region=us-west-2"}))
+
+		envVars := StorageEnvVars(storage(s3))
+		Expect(envVars).To(HaveLen(2))
+		Expect(envVars[0].Name).To(Equal(S3_ACCESS_KEY_ID))
+		Expect(envVars[0].ValueFrom.SecretKeyRef.Name).To(Equal("s3-credentials"))
+		Expect(envVars[1].ValueFrom.SecretKeyRef.Key).To(Equal(S3_SECRET_ACCESS_KEY))
+	})
+
+	It("should not use the secret with the role", func() {
+		Expect(StorageEnvVars(storage(raczylocomv1.ClusterImageStorageS3{UseRole: true, SecretName: "s3-credentials"}))).To(BeNil())
+	})
+
+	It("should reject the inline keys only when forbidden", func() {
+		inline := storage(raczylocomv1.ClusterImageStorageS3{AccessKey: "yyy", SecretKey: "zzz"})
+		Expect(ValidateStorageCredentials(inline, false)).To(Succeed())
+		Expect(ValidateStorageCredentials(inline, true)).NotTo(Succeed())
+		Expect(ValidateStorageCredentials(storage(raczylocomv1.ClusterImageStorageS3{SecretName: "s3-credentials"}), true)).To(Succeed())
+	})
+})
+
+var _ = Describe("File storage", func() {
+	storage := raczylocomv1.ClusterImageStorageSpec{
+		StorageTarget: STORAGE_FILE,
+		File: raczylocomv1.ClusterImageStorageFile{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "image-backups"},
+		},
+	}
+
+	It("should locate the export within the mounted volume", func() {
+		Expect(StorageLocation(storage, "/images", "backup-20240901")).To(Equal(FILE_STORAGE_PATH + "/images/backup-20240901"))
+	})
+
+	It("should mount the volume into the jobs", func() {
+		job := CreateJob(JobParams{Name: "img-export", Namespace: "default", Storage: &storage}, func(raczylocomv1.ClusterImageExport) []string { return nil })
+		Expect(job.Spec.Template.Spec.Volumes).To(ContainElement(HaveField("VolumeSource.PersistentVolumeClaim.ClaimName", "image-backups")))
+		Expect(job.Spec.Template.Spec.Containers[0].VolumeMounts).To(ContainElement(HaveField("MountPath", FILE_STORAGE_PATH)))
+
+		volumes, mounts := StorageVolumes(raczylocomv1.ClusterImageStorageSpec{StorageTarget: STORAGE_S3})
+		Expect(volumes).To(BeEmpty())
+		Expect(mounts).To(BeEmpty())
+	})
+
+	It("should require exactly one volume source", func() {
+		Expect(ValidateStorage(storage, true)).To(Succeed())
+		Expect(ValidateStorage(raczylocomv1.ClusterImageStorageSpec{StorageTarget: STORAGE_FILE}, false)).NotTo(Succeed())
+
+		both := storage
+		both.File.HostPath = &corev1.HostPathVolumeSource{Path: "/var/backups"}
+		Expect(ValidateStorage(both, false)).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("Job labels", func() {
+	It("should keep the app label next to the extra ones", func() {
+		job := CreateJob(JobParams{Name: "img-export", Labels: map[string]string{EXPORT_UID_LABEL: "1234", "app": "other"}}, func(raczylocomv1.ClusterImageExport) []string { return nil })
+		Expect(job.Labels).To(Equal(map[string]string{"app": "image-export", EXPORT_UID_LABEL: "1234"}))
+	})
+})
+
+var _ = Describe("Retry policy", func() {
+	It("should classify the failures by the error of the worker", func() {
+		Expect(ClassifyFailure("Error: initializing source docker://nginx:1.27: reading manifest 1.27 in docker.io/library/nginx: toomanyrequests: You have reached your pull rate limit")).To(Equal(FAILURE_RATE_LIMITED))
+		Expect(ClassifyFailure("Error: initializing source docker://ghcr.io/acme/api:1.0: unauthorized: authentication required")).To(Equal(FAILURE_UNAUTHORIZED))
+		Expect(ClassifyFailure("Error: initializing source docker://nginx:9.99: reading manifest 9.99 in docker.io/library/nginx: manifest unknown")).To(Equal(FAILURE_NOT_FOUND))
+		Expect(ClassifyFailure("Error: copying blob sha256:4290ab: connection reset by peer")).To(Equal(FAILURE_OTHER))
+		Expect(ClassifyFailure("Error: reading manifest 1.0 in ghcr.io/acme/api: denied: requested access to the resource is denied")).To(Equal(FAILURE_UNAUTHORIZED))
+		Expect(ClassifyFailure("Error: initializing source docker://registry.internal/acme/api:1.0: repository acme/api not found")).To(Equal(FAILURE_NOT_FOUND))
+		Expect(ClassifyFailure("Error: open /tmp/nginx-1.27.tar: permission denied")).To(Equal(FAILURE_OTHER))
+		Expect(ClassifyFailure("/bin/sh: skopeo: command not found")).To(Equal(FAILURE_OTHER))
+	})
+
+	It("should double the backoff up to the maximum", func() {
+		policy := raczylocomv1.RetryPolicy{
+			MaxRetries:     pointer.Int(5),
+			InitialBackoff: &metav1.Duration{Duration: time.Minute},
+			MaxBackoff:     &metav1.Duration{Duration: 5 * time.Minute},
+		}
+		for retryCount, expected := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+			backoff, retry := RetryBackoff(policy, FAILURE_OTHER, retryCount)
+			Expect(retry).To(BeTrue())
+			Expect(backoff).To(Equal(expected))
+		}
+		_, retry := RetryBackoff(policy, FAILURE_OTHER, 5)
+		Expect(retry).To(BeFalse())
+
+		backoff, retry := RetryBackoff(policy, FAILURE_RATE_LIMITED, 0)
+		Expect(retry).To(BeTrue())
+		Expect(backoff).To(Equal(5 * time.Minute))
+	})
+
+	It("should retry only the listed failure classes", func() {
+		_, retry := RetryBackoff(raczylocomv1.RetryPolicy{}, FAILURE_UNAUTHORIZED, 0)
+		Expect(retry).To(BeFalse())
+		backoff, retry := RetryBackoff(raczylocomv1.RetryPolicy{}, FAILURE_OTHER, DEFAULT_MAX_RETRIES-1)
+		Expect(retry).To(BeTrue())
+		Expect(backoff).To(Equal(4 * DEFAULT_INITIAL_BACKOFF))
+
+		_, retry = RetryBackoff(raczylocomv1.RetryPolicy{RetryOn: []string{FAILURE_NOT_FOUND}}, FAILURE_NOT_FOUND, 0)
+		Expect(retry).To(BeTrue())
+		_, retry = RetryBackoff(raczylocomv1.RetryPolicy{MaxRetries: pointer.Int(0)}, FAILURE_OTHER, 0)
+		Expect(retry).To(BeFalse())
+	})
+
+	It("should leave the retries of the single attempt job to the controller", func() {
+		job := CreateJob(JobParams{Name: "img-export", SingleAttempt: true}, func(raczylocomv1.ClusterImageExport) []string { return nil })
+		Expect(job.Spec.Template.Spec.RestartPolicy).To(Equal(corev1.RestartPolicyNever))
+		Expect(*job.Spec.BackoffLimit).To(BeZero())
+
+		job = CreateJob(JobParams{Name: "manifest"}, func(raczylocomv1.ClusterImageExport) []string { return nil })
+		Expect(job.Spec.Template.Spec.RestartPolicy).To(Equal(corev1.RestartPolicyOnFailure))
+		Expect(job.Spec.BackoffLimit).To(BeNil())
+	})
+})
@@ -11,7 +11,14 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/jsonpath"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
@@ -22,10 +29,14 @@ type K8sResource interface {
 
 // Wrapper types
 type (
-	DeploymentWrapper appsv1.Deployment
-	JobWrapper        batchv1.Job
-	DaemonSetWrapper  appsv1.DaemonSet
-	CronJobWrapper    batchv1.CronJob
+	DeploymentWrapper  appsv1.Deployment
+	JobWrapper         batchv1.Job
+	DaemonSetWrapper   appsv1.DaemonSet
+	CronJobWrapper     batchv1.CronJob
+	StatefulSetWrapper appsv1.StatefulSet
+	ReplicaSetWrapper  appsv1.ReplicaSet
+	PodWrapper         corev1.Pod
+	PodSpecWrapper     corev1.PodSpec
 )
 
 // Implement the K8sResource interface for wrapper types
@@ -35,6 +46,10 @@ func (ds *DaemonSetWrapper) GetPodSpec() *corev1.PodSpec { return &ds.Spec.Templ
 func (cj *CronJobWrapper) GetPodSpec() *corev1.PodSpec {
 	return &cj.Spec.JobTemplate.Spec.Template.Spec
 }
+func (ss *StatefulSetWrapper) GetPodSpec() *corev1.PodSpec { return &ss.Spec.Template.Spec }
+func (rs *ReplicaSetWrapper) GetPodSpec() *corev1.PodSpec  { return &rs.Spec.Template.Spec }
+func (p *PodWrapper) GetPodSpec() *corev1.PodSpec          { return &p.Spec }
+func (ps *PodSpecWrapper) GetPodSpec() *corev1.PodSpec     { return (*corev1.PodSpec)(ps) }
 
 type ContainerCache struct {
 	sync.RWMutex
@@ -132,8 +147,8 @@ func processContainer(ctx context.Context, image string, containerNamespace stri
 	}
 }
 
-func ListAndProcessResources[T K8sResource, L client.ObjectList](ctx context.Context, r client.Client, list L, containersList *ContainersList) error {
-	if err := r.List(ctx, list, &client.ListOptions{}); err != nil {
+func ListAndProcessResources[T K8sResource, L client.ObjectList](ctx context.Context, r client.Client, list L, containersList *ContainersList, opts ...client.ListOption) error {
+	if err := r.List(ctx, list, opts...); err != nil {
 		return fmt.Errorf("failed to list resources: %w", err)
 	}
 
@@ -183,6 +198,21 @@ func ListAndProcessResources[T K8sResource, L client.ObjectList](ctx context.Con
 			wg.Add(1)
 			go processItem((*CronJobWrapper)(&typedList.Items[i]), typedList.Items[i].Namespace)
 		}
+	case *appsv1.StatefulSetList:
+		for i := range typedList.Items {
+			wg.Add(1)
+			go processItem((*StatefulSetWrapper)(&typedList.Items[i]), typedList.Items[i].Namespace)
+		}
+	case *appsv1.ReplicaSetList:
+		for i := range typedList.Items {
+			wg.Add(1)
+			go processItem((*ReplicaSetWrapper)(&typedList.Items[i]), typedList.Items[i].Namespace)
+		}
+	case *corev1.PodList:
+		for i := range typedList.Items {
+			wg.Add(1)
+			go processItem((*PodWrapper)(&typedList.Items[i]), typedList.Items[i].Namespace)
+		}
 	default:
 		return fmt.Errorf("unsupported list type: %T", list)
 	}
@@ -204,6 +234,161 @@ func ListAndProcessResources[T K8sResource, L client.ObjectList](ctx context.Con
 	return nil
 }
 
+// ListRunningContainers discovers the images from the container statuses of the pods, pinned to the digest the node runs
+func ListRunningContainers(ctx context.Context, r client.Client, containersList *ContainersList, opts ...client.ListOption) error {
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList, opts...); err != nil {
+		return fmt.Errorf("failed to list resources: %w", err)
+	}
+
+	for i := range podList.Items {
+		containers, err := ContainersFromPodStatus(&podList.Items[i])
+		if err != nil {
+			return err
+		}
+		containersList.Containers = append(containersList.Containers, containers...)
+	}
+	return nil
+}
+
+// ContainersFromPodStatus returns the images of the started containers of the pod. Containers which
+// were not pulled yet have no imageID and are skipped.
+func ContainersFromPodStatus(pod *corev1.Pod) ([]Container, error) {
+	statuses := append([]corev1.ContainerStatus{}, pod.Status.ContainerStatuses...)
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.EphemeralContainerStatuses...)
+
+	containers := []Container{}
+	for _, status := range statuses {
+		if status.ImageID == "" {
+			continue
+		}
+		cnt, err := ProcessContainerName(status.Image)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process container name: %s - %w", status.Image, err)
+		}
+		// imageID looks like docker-pullable://nginx@sha256:..., locally built images have no repository digest
+		if _, digest, found := strings.Cut(status.ImageID, "@"); found && cnt.Sha == "" {
+			cnt.Sha = digest
+		}
+		cnt.ImageNamespace = pod.Namespace
+		containers = append(containers, cnt)
+	}
+	return containers, nil
+}
+
+// CustomWorkloadError is returned when the custom workload can't be discovered because of its definition,
+// e.g. the unknown kind, the invalid JSONPath or the missing permissions to list it
+type CustomWorkloadError struct {
+	Kind string
+	Err  error
+}
+
+func (e *CustomWorkloadError) Error() string {
+	return fmt.Sprintf("custom workload %s: %v", e.Kind, e.Err)
+}
+
+func (e *CustomWorkloadError) Unwrap() error {
+	return e.Err
+}
+
+// ListAndProcessCustomResources discovers the images from the custom resources, extracting the pod specs with JSONPath.
+// Images are added only when the whole workload is discovered, so the broken workload can be skipped.
+func ListAndProcessCustomResources(ctx context.Context, r client.Client, workload raczylocomv1.CustomWorkload, containersList *ContainersList, opts ...client.ListOption) error {
+	gv, err := schema.ParseGroupVersion(workload.APIVersion)
+	if err != nil {
+		return &CustomWorkloadError{Kind: workload.Kind, Err: fmt.Errorf("invalid apiVersion: %w", err)}
+	}
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gv.WithKind(workload.Kind + "List"))
+	if err := r.List(ctx, list, opts...); err != nil {
+		if meta.IsNoMatchError(err) || runtime.IsNotRegisteredError(err) || errors.IsForbidden(err) || errors.IsNotFound(err) {
+			return &CustomWorkloadError{Kind: workload.Kind, Err: err}
+		}
+		return fmt.Errorf("failed to list resources: %w", err)
+	}
+
+	workloadContainers := &ContainersList{}
+	for i := range list.Items {
+		podSpecs, err := PodSpecsFromUnstructured(&list.Items[i], workload.PodSpecPaths)
+		if err != nil {
+			return &CustomWorkloadError{Kind: workload.Kind, Err: fmt.Errorf("failed to extract pod specs from %s/%s: %w", list.Items[i].GetNamespace(), list.Items[i].GetName(), err)}
+		}
+		for j := range podSpecs {
+			if err := processContainers(ctx, (*PodSpecWrapper)(&podSpecs[j]), list.Items[i].GetNamespace(), workloadContainers); err != nil {
+				return err
+			}
+		}
+	}
+	containersList.Containers = append(containersList.Containers, workloadContainers.Containers...)
+	return nil
+}
+
+// PodSpecsFromUnstructured returns all the pod specs found under the JSONPath expressions, missing paths are skipped
+func PodSpecsFromUnstructured(obj *unstructured.Unstructured, paths []string) ([]corev1.PodSpec, error) {
+	if len(paths) == 0 {
+		paths = []string{DEFAULT_POD_SPEC_PATH}
+	}
+
+	podSpecs := []corev1.PodSpec{}
+	for _, path := range paths {
+		if !strings.HasPrefix(path, "{") {
+			path = "{" + path + "}"
+		}
+		parser := jsonpath.New("podSpec").AllowMissingKeys(true)
+		if err := parser.Parse(path); err != nil {
+			return nil, fmt.Errorf("invalid JSONPath %s: %w", path, err)
+		}
+		results, err := parser.FindResults(obj.Object)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			for _, value := range result {
+				raw, ok := value.Interface().(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("JSONPath %s does not point to the pod spec", path)
+				}
+				podSpec := corev1.PodSpec{}
+				if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &podSpec); err != nil {
+					return nil, err
+				}
+				podSpecs = append(podSpecs, podSpec)
+			}
+		}
+	}
+	return podSpecs, nil
+}
+
+// SelectorListOptions returns the list options matching the label selector, nil selector matches everything
+func SelectorListOptions(selector *metav1.LabelSelector) ([]client.ListOption, error) {
+	if selector == nil {
+		return nil, nil
+	}
+	labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	return []client.ListOption{client.MatchingLabelsSelector{Selector: labelSelector}}, nil
+}
+
+// SelectNamespaces returns the names of the namespaces matching the label selector
+func SelectNamespaces(ctx context.Context, r client.Client, selector *metav1.LabelSelector) ([]string, error) {
+	opts, err := SelectorListOptions(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace selector: %w", err)
+	}
+	namespaceList := &corev1.NamespaceList{}
+	if err := r.List(ctx, namespaceList, opts...); err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	namespaces := make([]string, 0, len(namespaceList.Items))
+	for _, namespace := range namespaceList.Items {
+		namespaces = append(namespaces, namespace.Name)
+	}
+	return namespaces, nil
+}
+
 func SetupIndexers(mgr manager.Manager) error {
 	return wait.ExponentialBackoff(wait.Backoff{
 		Duration: 1 * time.Second,
@@ -218,6 +403,16 @@ func SetupIndexers(mgr manager.Manager) error {
 		if err != nil {
 			return false, nil
 		}
+		err = mgr.GetFieldIndexer().IndexField(context.Background(), &raczylocomv1.ClusterImageExport{}, SCHEDULE_OWNER_KEY, func(rawObj client.Object) []string {
+			owner := metav1.GetControllerOf(rawObj)
+			if owner == nil || owner.APIVersion != raczylocomv1.GroupVersion.String() || owner.Kind != "ClusterImageExportSchedule" {
+				return nil
+			}
+			return []string{owner.Name}
+		})
+		if err != nil {
+			return false, nil
+		}
 		return true, nil
 	})
 }
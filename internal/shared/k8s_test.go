@@ -0,0 +1,154 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shared
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Custom workloads", func() {
+	rollout := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Rollout",
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers":     []interface{}{map[string]interface{}{"name": "app", "image": "nginx:1.27"}},
+					"initContainers": []interface{}{map[string]interface{}{"name": "init", "image": "busybox:1.36"}},
+				},
+			},
+			"analysis": map[string]interface{}{
+				"templates": []interface{}{
+					map[string]interface{}{"spec": map[string]interface{}{"containers": []interface{}{map[string]interface{}{"name": "a", "image": "curl:8"}}}},
+					map[string]interface{}{"spec": map[string]interface{}{"containers": []interface{}{map[string]interface{}{"name": "b", "image": "jq:1"}}}},
+				},
+			},
+		},
+	}}
+
+	It("should use the pod template by default", func() {
+		podSpecs, err := PodSpecsFromUnstructured(rollout, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(podSpecs).To(HaveLen(1))
+		Expect(podSpecs[0].Containers[0].Image).To(Equal("nginx:1.27"))
+		Expect(podSpecs[0].InitContainers[0].Image).To(Equal("busybox:1.36"))
+	})
+
+	It("should collect the pod specs from all the paths and skip the missing ones", func() {
+		podSpecs, err := PodSpecsFromUnstructured(rollout, []string{".spec.analysis.templates[*].spec", "{.spec.missing.spec}"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(podSpecs).To(HaveLen(2))
+		Expect(podSpecs[0].Containers[0].Image).To(Equal("curl:8"))
+		Expect(podSpecs[1].Containers[0].Image).To(Equal("jq:1"))
+	})
+
+	It("should reject the path not pointing to the pod spec", func() {
+		_, err := PodSpecsFromUnstructured(rollout, []string{"{.kind}"})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Running containers", func() {
+	It("should pin the images to the digests reported by the node", func() {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "app", Image: "docker.io/library/nginx:1.27", ImageID: "docker.io/library/nginx@sha256:1111"},
+					{Name: "pending", Image: "redis:7"},
+					{Name: "local", Image: "my-app:dev", ImageID: "sha256:2222"},
+				},
+				InitContainerStatuses: []corev1.ContainerStatus{
+					{Name: "init", Image: "busybox:1.36", ImageID: "docker-pullable://busybox@sha256:3333"},
+				},
+			},
+		}
+
+		containers, err := ContainersFromPodStatus(pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(containers).To(ConsistOf(
+			Container{FullName: "docker.io/library/nginx:1.27", Image: "docker.io/library/nginx", Tag: "1.27", Sha: "sha256:1111", ImageNamespace: "default"},
+			Container{FullName: "my-app:dev", Image: "my-app", Tag: "dev", ImageNamespace: "default"},
+			Container{FullName: "busybox:1.36", Image: "busybox", Tag: "1.36", Sha: "sha256:3333", ImageNamespace: "default"},
+		))
+	})
+})
+
+var _ = Describe("Label selectors", func() {
+	namespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	deployment := func(name string, image string, labels map[string]string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Image: image}},
+			}}},
+		}
+	}
+
+	It("should select the namespaces by their labels", func() {
+		fakeClient := fake.NewClientBuilder().WithObjects(
+			namespace("tenant-a", map[string]string{"tenant": "true"}),
+			namespace("tenant-b", map[string]string{"tenant": "true", "environment": "sandbox"}),
+			namespace("kube-system", nil),
+		).Build()
+
+		namespaces, err := SelectNamespaces(context.Background(), fakeClient, &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "true"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(namespaces).To(ConsistOf("tenant-a", "tenant-b"))
+
+		namespaces, err = SelectNamespaces(context.Background(), fakeClient, &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "environment", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"sandbox"}},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(namespaces).To(ConsistOf("tenant-a", "kube-system"))
+	})
+
+	It("should process only the workloads matching the selector", func() {
+		fakeClient := fake.NewClientBuilder().WithObjects(
+			deployment("selected", "nginx:1.27", map[string]string{"backup": "enabled"}),
+			deployment("skipped", "redis:7", nil),
+		).Build()
+
+		opts, err := SelectorListOptions(&metav1.LabelSelector{MatchLabels: map[string]string{"backup": "enabled"}})
+		Expect(err).NotTo(HaveOccurred())
+		containersList := &ContainersList{}
+		Expect(ListAndProcessResources[*DeploymentWrapper](context.Background(), fakeClient, &appsv1.DeploymentList{}, containersList, opts...)).To(Succeed())
+		Expect(containersList.Containers).To(HaveLen(1))
+		Expect(containersList.Containers[0].FullName).To(Equal("nginx:1.27"))
+	})
+
+	It("should match everything without the selector and reject the invalid one", func() {
+		opts, err := SelectorListOptions(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opts).To(BeEmpty())
+
+		_, err = SelectorListOptions(&metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "backup", Operator: "Maybe"},
+		}})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,82 @@
+package shared
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Platform is the os/arch[/variant] platform of the image, e.g. linux/arm64/v8
+type Platform struct {
+	OS           string
+	Architecture string
+	Variant      string
+}
+
+// ParsePlatform splits the platform into its parts
+func ParsePlatform(platform string) (Platform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", platform)
+	}
+	parsed := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		parsed.Variant = parts[2]
+	}
+	return parsed, nil
+}
+
+// ValidatePlatforms returns the error of the first invalid platform, all has to be the only one
+func ValidatePlatforms(platforms []string) error {
+	for _, platform := range platforms {
+		if platform == PLATFORM_ALL {
+			if len(platforms) > 1 {
+				return fmt.Errorf("%s can't be combined with the other platforms", PLATFORM_ALL)
+			}
+			continue
+		}
+		if _, err := ParsePlatform(platform); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllPlatforms returns true when the whole manifest list is exported
+func AllPlatforms(platforms []string) bool {
+	return len(platforms) == 1 && platforms[0] == PLATFORM_ALL
+}
+
+// MultiPlatform returns true when the export keeps the manifest list instead of the single platform image
+func MultiPlatform(platforms []string) bool {
+	return len(platforms) > 1 || AllPlatforms(platforms)
+}
+
+// SamePlatforms returns true when both exports store the same platforms of the image
+func SamePlatforms(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]string{}, a...)
+	sortedB := append([]string{}, b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// PlatformArgs returns the platform arguments of the worker scripts, empty for the platform of the node
+func PlatformArgs(platforms []string) string {
+	if AllPlatforms(platforms) {
+		return " --all-platforms"
+	}
+	args := ""
+	for _, platform := range platforms {
+		args += " --platform='" + platform + "'"
+	}
+	return args
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shared
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Platforms", func() {
+	It("should parse the platform with the optional variant", func() {
+		Expect(ParsePlatform("linux/amd64")).To(Equal(Platform{OS: "linux", Architecture: "amd64"}))
+		Expect(ParsePlatform("linux/arm64/v8")).To(Equal(Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}))
+		_, err := ParsePlatform("arm64")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should allow all only on its own", func() {
+		Expect(ValidatePlatforms([]string{PLATFORM_ALL})).To(Succeed())
+		Expect(ValidatePlatforms([]string{"linux/amd64", "linux/arm64"})).To(Succeed())
+		Expect(ValidatePlatforms([]string{PLATFORM_ALL, "linux/amd64"})).NotTo(Succeed())
+		Expect(ValidatePlatforms([]string{"linux"})).NotTo(Succeed())
+	})
+
+	It("should tell the multi-platform exports apart", func() {
+		Expect(MultiPlatform(nil)).To(BeFalse())
+		Expect(MultiPlatform([]string{"linux/arm64"})).To(BeFalse())
+		Expect(MultiPlatform([]string{"linux/amd64", "linux/arm64"})).To(BeTrue())
+		Expect(MultiPlatform([]string{PLATFORM_ALL})).To(BeTrue())
+	})
+
+	It("should compare the platforms regardless of their order", func() {
+		Expect(SamePlatforms(nil, []string{})).To(BeTrue())
+		Expect(SamePlatforms([]string{"linux/amd64", "linux/arm64"}, []string{"linux/arm64", "linux/amd64"})).To(BeTrue())
+		Expect(SamePlatforms([]string{"linux/amd64"}, nil)).To(BeFalse())
+	})
+
+	It("should pass the platforms to the worker scripts", func() {
+		Expect(PlatformArgs(nil)).To(BeEmpty())
+		Expect(PlatformArgs([]string{PLATFORM_ALL})).To(Equal(" --all-platforms"))
+		Expect(PlatformArgs([]string{"linux/amd64", "linux/arm64"})).To(Equal(" --platform='linux/amd64' --platform='linux/arm64'"))
+	})
+})
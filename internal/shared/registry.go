@@ -0,0 +1,599 @@
+package shared
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+)
+
+const (
+	DOCKER_HUB_REGISTRY = "docker.io"
+	DOCKER_HUB_API      = "registry-1.docker.io"
+)
+
+// Manifest media types accepted when resolving the digest. Manifest lists go first,
+// so the resolved digest covers all the platforms of the image.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// RegistryError is returned when the registry responds with an unexpected status
+type RegistryError struct {
+	StatusCode int
+	URL        string
+}
+
+func (e *RegistryError) Error() string {
+	return fmt.Sprintf("unexpected status %d from %s", e.StatusCode, e.URL)
+}
+
+type RegistryAuth struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+}
+
+// RegistryClient talks to the OCI distribution API of the registries
+type RegistryClient struct {
+	HTTPClient *http.Client
+	// Credentials keyed by the registry host
+	Auths map[string]RegistryAuth
+
+	sync.Mutex
+	digests map[string]string
+	// Authorization of the last answered challenge keyed by the registry host, reused until the registry rejects it
+	authorizations map[string]string
+}
+
+func NewRegistryClient(auths map[string]RegistryAuth) *RegistryClient {
+	return &RegistryClient{
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Auths:      auths,
+	}
+}
+
+// ParseDockerConfig extracts the registry credentials from the .dockerconfigjson contents
+func ParseDockerConfig(data []byte) (map[string]RegistryAuth, error) {
+	config := struct {
+		Auths map[string]RegistryAuth `json:"auths"`
+	}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	auths := map[string]RegistryAuth{}
+	for registry, auth := range config.Auths {
+		if auth.Auth != "" && auth.Username == "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth for registry %s: %w", registry, err)
+			}
+			auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		auths[normalizeRegistryHost(registry)] = auth
+	}
+	return auths, nil
+}
+
+func normalizeRegistryHost(registry string) string {
+	registry = strings.TrimPrefix(strings.TrimPrefix(registry, "https://"), "http://")
+	registry, _, _ = strings.Cut(registry, "/")
+	switch registry {
+	case "index.docker.io", DOCKER_HUB_API:
+		return DOCKER_HUB_REGISTRY
+	}
+	return registry
+}
+
+// ParseImageReference splits the image name into the registry host and the repository,
+// following the docker rules for the short names, e.g. nginx is docker.io/library/nginx
+func ParseImageReference(image string) (registry string, repository string) {
+	first, rest, found := strings.Cut(image, "/")
+	if !found || (!strings.ContainsAny(first, ".:") && first != "localhost") {
+		registry, repository = DOCKER_HUB_REGISTRY, image
+	} else {
+		registry, repository = normalizeRegistryHost(first), rest
+	}
+	if registry == DOCKER_HUB_REGISTRY && !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+	return registry, repository
+}
+
+// ResolveDigest returns the digest of the manifest the tag currently points to
+func (c *RegistryClient) ResolveDigest(ctx context.Context, image string, tag string) (string, error) {
+	cacheKey := image + ":" + tag
+	c.Lock()
+	if digest, ok := c.digests[cacheKey]; ok {
+		c.Unlock()
+		return digest, nil
+	}
+	c.Unlock()
+
+	registry, repository := ParseImageReference(image)
+	manifestURL := registryManifestURL(registry, repository, tag)
+
+	resp, err := c.doWithAuth(ctx, http.MethodHead, manifestURL, registry, repository)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		// Not all registries return the digest on HEAD, calculate it from the manifest itself
+		resp, err = c.doWithAuth(ctx, http.MethodGet, manifestURL, registry, repository)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", err
+		}
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	}
+
+	c.Lock()
+	if c.digests == nil {
+		c.digests = map[string]string{}
+	}
+	c.digests[cacheKey] = digest
+	c.Unlock()
+	return digest, nil
+}
+
+// registryManifest holds the fields of the image manifest and the manifest list the image size is read from
+type registryManifest struct {
+	Manifests []platformManifest `json:"manifests"`
+	Config    struct {
+		Size int64 `json:"size"`
+	} `json:"config"`
+	Layers []struct {
+		Digest string `json:"digest"`
+		Size   int64  `json:"size"`
+	} `json:"layers"`
+}
+
+type platformManifest struct {
+	Digest   string `json:"digest"`
+	Platform *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+		Variant      string `json:"variant,omitempty"`
+	} `json:"platform,omitempty"`
+}
+
+// imageSize sums the config and the layers of the manifest. Layers listed without the size are asked for
+// with HEAD, the registry reports the size of the blob in its Content-Length without sending it.
+func (c *RegistryClient) imageSize(ctx context.Context, registry string, repository string, manifest *registryManifest) (int64, error) {
+	size := manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		if layer.Size == 0 && layer.Digest != "" {
+			resp, err := c.doWithAuth(ctx, http.MethodHead, registryBlobURL(registry, repository, layer.Digest), registry, repository)
+			if err != nil {
+				return 0, err
+			}
+			resp.Body.Close()
+			if resp.ContentLength > 0 {
+				layer.Size = resp.ContentLength
+			}
+		}
+		size += layer.Size
+	}
+	return size, nil
+}
+
+// matches returns true when the manifest is one of the platforms, the platform of the operator without them
+func (m *platformManifest) matches(platforms []string) bool {
+	if AllPlatforms(platforms) {
+		return true
+	}
+	if m.Platform == nil {
+		return false
+	}
+	if len(platforms) == 0 {
+		return m.Platform.OS == runtime.GOOS && m.Platform.Architecture == runtime.GOARCH
+	}
+	for _, platform := range platforms {
+		parsed, err := ParsePlatform(platform)
+		if err != nil {
+			continue
+		}
+		if parsed.OS == m.Platform.OS && parsed.Architecture == m.Platform.Architecture && (parsed.Variant == "" || parsed.Variant == m.Platform.Variant) {
+			return true
+		}
+	}
+	return false
+}
+
+// ImageSize returns the compressed size of the image config and layers. For the multi-platform images the sizes
+// of the requested platforms are summed, all platforms for all. Without the platforms the size of the platform
+// the operator runs on is returned, or of the first platform when it's missing.
+func (c *RegistryClient) ImageSize(ctx context.Context, image string, reference string, platforms []string) (int64, error) {
+	registry, repository := ParseImageReference(image)
+	manifest, err := c.fetchManifest(ctx, registry, repository, reference)
+	if err != nil {
+		return 0, err
+	}
+	if len(manifest.Manifests) == 0 {
+		return c.imageSize(ctx, registry, repository, manifest)
+	}
+
+	digests := []string{}
+	for _, platformManifest := range manifest.Manifests {
+		if platformManifest.matches(platforms) {
+			digests = append(digests, platformManifest.Digest)
+		}
+	}
+	if len(platforms) == 0 {
+		// Node pulls the single platform only
+		if len(digests) == 0 {
+			digests = append(digests, manifest.Manifests[0].Digest)
+		}
+		digests = digests[:1]
+	}
+
+	var size int64
+	for _, digest := range digests {
+		platformManifest, err := c.fetchManifest(ctx, registry, repository, digest)
+		if err != nil {
+			return 0, err
+		}
+		platformSize, err := c.imageSize(ctx, registry, repository, platformManifest)
+		if err != nil {
+			return 0, err
+		}
+		size += platformSize
+	}
+	return size, nil
+}
+
+func (c *RegistryClient) fetchManifest(ctx context.Context, registry string, repository string, reference string) (*registryManifest, error) {
+	resp, err := c.doWithAuth(ctx, http.MethodGet, registryManifestURL(registry, repository, reference), registry, repository)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	manifest := &registryManifest{}
+	if err := json.NewDecoder(resp.Body).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest of %s/%s:%s: %w", registry, repository, reference, err)
+	}
+	return manifest, nil
+}
+
+// Tags cosign attaches the signatures, attestations and SBOMs to the image digest with
+var cosignTagSuffixes = map[string]string{
+	"sig":  REFERRER_SIGNATURE,
+	"att":  REFERRER_ATTESTATION,
+	"sbom": REFERRER_SBOM,
+}
+
+// Referrers returns the artifacts attached to the image digest. The referrers API is asked first, registries
+// without it are looked up by the fallback tag of the digest. Cosign artifacts are found by their tags as well.
+func (c *RegistryClient) Referrers(ctx context.Context, image string, digest string) ([]raczylocomv1.ClusterImageReferrer, error) {
+	registry, repository := ParseImageReference(image)
+	referrers, err := c.referrersIndex(ctx, registry, repository, registryReferrersURL(registry, repository, digest))
+	if registryErr, ok := err.(*RegistryError); ok && registryErr.StatusCode < http.StatusInternalServerError {
+		referrers, err = c.referrersIndex(ctx, registry, repository, registryManifestURL(registry, repository, ReferrersTag(digest)))
+		if registryErr, ok := err.(*RegistryError); ok && registryErr.StatusCode == http.StatusNotFound {
+			referrers, err = nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	suffixes := make([]string, 0, len(cosignTagSuffixes))
+	for suffix := range cosignTagSuffixes {
+		suffixes = append(suffixes, suffix)
+	}
+	sort.Strings(suffixes)
+	for _, suffix := range suffixes {
+		tag := ReferrersTag(digest) + "." + suffix
+		tagDigest, err := c.ResolveDigest(ctx, image, tag)
+		if registryErr, ok := err.(*RegistryError); ok && registryErr.StatusCode == http.StatusNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		known := false
+		for i := range referrers {
+			if referrers[i].Digest == tagDigest {
+				referrers[i].Tag, known = tag, true
+			}
+		}
+		if !known {
+			referrers = append(referrers, raczylocomv1.ClusterImageReferrer{Kind: cosignTagSuffixes[suffix], Digest: tagDigest, Tag: tag})
+		}
+	}
+	return referrers, nil
+}
+
+// referrersIndex reads the referrers from the image index returned by the referrers API or the fallback tag
+func (c *RegistryClient) referrersIndex(ctx context.Context, registry string, repository string, target string) ([]raczylocomv1.ClusterImageReferrer, error) {
+	resp, err := c.doWithAuth(ctx, http.MethodGet, target, registry, repository)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	index := struct {
+		Manifests []struct {
+			Digest       string `json:"digest"`
+			ArtifactType string `json:"artifactType"`
+		} `json:"manifests"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return nil, fmt.Errorf("invalid referrers index from %s: %w", target, err)
+	}
+
+	referrers := []raczylocomv1.ClusterImageReferrer{}
+	for _, descriptor := range index.Manifests {
+		referrers = append(referrers, raczylocomv1.ClusterImageReferrer{
+			Kind:         referrerKind(descriptor.ArtifactType),
+			Digest:       descriptor.Digest,
+			ArtifactType: descriptor.ArtifactType,
+		})
+	}
+	return referrers, nil
+}
+
+// referrerKind recognises the signatures, attestations and SBOMs by the artifact type
+func referrerKind(artifactType string) string {
+	artifactType = strings.ToLower(artifactType)
+	switch {
+	case strings.Contains(artifactType, "spdx"), strings.Contains(artifactType, "cyclonedx"), strings.Contains(artifactType, "sbom"):
+		return REFERRER_SBOM
+	case strings.Contains(artifactType, "in-toto"), strings.Contains(artifactType, "attestation"):
+		return REFERRER_ATTESTATION
+	case strings.Contains(artifactType, "signature"), strings.Contains(artifactType, "simplesigning"), strings.Contains(artifactType, "sigstore"):
+		return REFERRER_SIGNATURE
+	}
+	return REFERRER_OTHER
+}
+
+// ReferrersTag returns the tag derived from the digest, the referrers of the digest are stored under it
+// by the registries without the referrers API and by cosign
+func ReferrersTag(digest string) string {
+	return strings.ReplaceAll(digest, ":", "-")
+}
+
+// ReferrerTag returns the tag the referrer is stored under in the repository of the image, the referrers found
+// by the referrers API get the tag derived from their own digest
+func ReferrerTag(referrer raczylocomv1.ClusterImageReferrer) string {
+	if referrer.Tag != "" {
+		return referrer.Tag
+	}
+	return ReferrersTag(referrer.Digest)
+}
+
+// ImageRepository strips the tag and the digest from the image reference
+func ImageRepository(reference string) string {
+	reference, _, _ = strings.Cut(reference, "@")
+	if i := strings.LastIndex(reference, ":"); i > strings.LastIndex(reference, "/") {
+		reference = reference[:i]
+	}
+	return reference
+}
+
+func registryReferrersURL(registry string, repository string, digest string) string {
+	return strings.Replace(registryManifestURL(registry, repository, digest), "/manifests/", "/referrers/", 1)
+}
+
+// registryManifestURL returns the URL of the manifest, Docker Hub serves the API from its own host
+func registryManifestURL(registry string, repository string, reference string) string {
+	host := registry
+	if registry == DOCKER_HUB_REGISTRY {
+		host = DOCKER_HUB_API
+	}
+	return fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, repository, reference)
+}
+
+// registryBlobURL returns the URL of the blob, e.g. the image layer
+func registryBlobURL(registry string, repository string, digest string) string {
+	return strings.Replace(registryManifestURL(registry, repository, digest), "/manifests/", "/blobs/", 1)
+}
+
+func (c *RegistryClient) doWithAuth(ctx context.Context, method string, target string, registry string, repository string) (*http.Response, error) {
+	c.Lock()
+	authorization := c.authorizations[registry]
+	c.Unlock()
+	resp, err := c.do(ctx, method, target, authorization)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		// Token expired or was issued for the other repository, answer the challenge again
+		resp.Body.Close()
+		authorization, err = c.authorize(ctx, resp.Header.Get("WWW-Authenticate"), registry, repository)
+		if err != nil {
+			return nil, err
+		}
+		c.Lock()
+		if c.authorizations == nil {
+			c.authorizations = map[string]string{}
+		}
+		c.authorizations[registry] = authorization
+		c.Unlock()
+		resp, err = c.do(ctx, method, target, authorization)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &RegistryError{StatusCode: resp.StatusCode, URL: target}
+	}
+	return resp, nil
+}
+
+func (c *RegistryClient) do(ctx context.Context, method string, target string, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// authorize answers the registry challenge, fetching the bearer token when needed
+func (c *RegistryClient) authorize(ctx context.Context, challenge string, registry string, repository string) (string, error) {
+	auth, hasAuth := c.Auths[registry]
+	scheme, params := parseChallenge(challenge)
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if !hasAuth {
+			return "", fmt.Errorf("registry %s requires credentials", registry)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth.Username+":"+auth.Password)), nil
+	case "bearer":
+		tokenURL, err := url.Parse(params["realm"])
+		if err != nil || params["realm"] == "" {
+			return "", fmt.Errorf("invalid bearer realm %q from registry %s", params["realm"], registry)
+		}
+		query := tokenURL.Query()
+		if params["service"] != "" {
+			query.Set("service", params["service"])
+		}
+		scope := params["scope"]
+		if scope == "" {
+			scope = "repository:" + repository + ":pull"
+		}
+		query.Set("scope", scope)
+		tokenURL.RawQuery = query.Encode()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		if hasAuth {
+			req.SetBasicAuth(auth.Username, auth.Password)
+		}
+		resp, err := c.HTTPClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("unable to get token from %s: status %d", tokenURL.Host, resp.StatusCode)
+		}
+
+		token := struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}{}
+		if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+			return "", err
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		return "Bearer " + token.Token, nil
+	}
+
+	return "", fmt.Errorf("unsupported authentication challenge %q from registry %s", challenge, registry)
+}
+
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+	for rest != "" {
+		key, value, found := strings.Cut(strings.TrimLeft(rest, ", "), "=")
+		if !found {
+			break
+		}
+		if strings.HasPrefix(value, `"`) {
+			// Quoted values may contain commas, e.g. scope="repository:foo:pull,push"
+			value, rest, _ = strings.Cut(value[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(value, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return scheme, params
+}
+
+// ImportDestination returns the reference the image is pushed to when imported into the destination registry.
+// The source registry is replaced, the repository is kept under the optional prefix.
+func ImportDestination(registry string, repositoryPrefix string, image ManifestImage) string {
+	_, repository := ParseImageReference(image.Image)
+	if prefix := strings.Trim(repositoryPrefix, "/"); prefix != "" {
+		repository = prefix + "/" + repository
+	}
+	return strings.TrimSuffix(registry, "/") + "/" + repository + ":" + destinationTag(image.Tag, image.Sha)
+}
+
+// RepositoryTemplateData holds the fields available in the repository template of the registry target
+type RepositoryTemplateData struct {
+	// Repository within the source registry, e.g. library/nginx
+	Repository string
+	// Last element of the repository, e.g. nginx
+	Name           string
+	SourceRegistry string
+	// Namespace the image was discovered in
+	Namespace  string
+	ExportName string
+}
+
+// MirrorDestination returns the reference the image is copied to by the registry target
+func MirrorDestination(registry raczylocomv1.ClusterImageStorageRegistry, container Container, exportName string) (string, error) {
+	sourceRegistry, repository := ParseImageReference(container.Image)
+	data := RepositoryTemplateData{
+		Repository:     repository,
+		Name:           repository[strings.LastIndex(repository, "/")+1:],
+		SourceRegistry: sourceRegistry,
+		Namespace:      container.ImageNamespace,
+		ExportName:     exportName,
+	}
+
+	repositoryTemplate := registry.RepositoryTemplate
+	if repositoryTemplate == "" {
+		repositoryTemplate = DEFAULT_REPOSITORY_TEMPLATE
+	}
+	tmpl, err := template.New("repository").Option("missingkey=error").Parse(repositoryTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid repository template: %w", err)
+	}
+	rendered := &strings.Builder{}
+	if err := tmpl.Execute(rendered, data); err != nil {
+		return "", fmt.Errorf("invalid repository template: %w", err)
+	}
+
+	destinationRepository := strings.Trim(rendered.String(), "/")
+	if destinationRepository == "" {
+		return "", fmt.Errorf("repository template %q rendered empty repository for %s", repositoryTemplate, container.FullName)
+	}
+	return strings.TrimSuffix(registry.Registry, "/") + "/" + strings.ToLower(destinationRepository) + ":" + destinationTag(container.Tag, container.Sha), nil
+}
+
+func destinationTag(tag string, sha string) string {
+	if tag == "" {
+		// Images referenced only by digest get the tag derived from it, the registries don't accept pushes by digest
+		return strings.ReplaceAll(sha, ":", "-")
+	}
+	return tag
+}
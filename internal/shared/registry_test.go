@@ -0,0 +1,378 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shared
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+)
+
+// fakeRegistry is a minimal stand-in for the OCI distribution API serving a single manifest
+type fakeRegistry struct {
+	server     *httptest.Server
+	manifest   string
+	token      string
+	headDigest bool
+	requests   []string
+	// Manifests served by the digest, e.g. the platform manifests of the index
+	manifests map[string]string
+	// Indexes served by the referrers API keyed by the subject digest, the API is missing without them
+	referrers map[string]string
+	// Sizes of the blobs keyed by the digest
+	blobs map[string]int
+}
+
+func newFakeRegistry(token string, headDigest bool) *fakeRegistry {
+	registry := &fakeRegistry{
+		manifest:   `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`,
+		token:      token,
+		headDigest: headDigest,
+	}
+	registry.server = httptest.NewTLSServer(http.HandlerFunc(registry.handle))
+	return registry
+}
+
+func (f *fakeRegistry) host() string {
+	return strings.TrimPrefix(f.server.URL, "https://")
+}
+
+func (f *fakeRegistry) digest() string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(f.manifest)))
+}
+
+func (f *fakeRegistry) handle(w http.ResponseWriter, r *http.Request) {
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+
+	if r.URL.Path == "/token" {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "robot" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("scope") != "repository:team/app:pull" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, `{"token":%q}`, f.token)
+		return
+	}
+
+	if f.token != "" && r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, f.server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if index, ok := f.referrers[strings.TrimPrefix(r.URL.Path, "/v2/team/app/referrers/")]; ok {
+		w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+		fmt.Fprint(w, index)
+		return
+	}
+	if size, ok := f.blobs[strings.TrimPrefix(r.URL.Path, "/v2/team/app/blobs/")]; ok {
+		w.Header().Set("Content-Length", fmt.Sprint(size))
+		return
+	}
+	if manifest, ok := f.manifests[strings.TrimPrefix(r.URL.Path, "/v2/team/app/manifests/")]; ok {
+		fmt.Fprint(w, manifest)
+		return
+	}
+	if r.URL.Path != "/v2/team/app/manifests/1.0" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+	if f.headDigest {
+		w.Header().Set("Docker-Content-Digest", f.digest())
+	}
+	if r.Method == http.MethodGet {
+		fmt.Fprint(w, f.manifest)
+	}
+}
+
+var _ = Describe("Registry client", func() {
+	ctx := context.Background()
+
+	Context("When parsing the image references", func() {
+		It("should expand the docker hub short names", func() {
+			registry, repository := ParseImageReference("nginx")
+			Expect(registry).To(Equal("docker.io"))
+			Expect(repository).To(Equal("library/nginx"))
+
+			registry, repository = ParseImageReference("minio/minio")
+			Expect(registry).To(Equal("docker.io"))
+			Expect(repository).To(Equal("minio/minio"))
+		})
+
+		It("should recognise the registry hosts", func() {
+			registry, repository := ParseImageReference("ghcr.io/lukaszraczylo/worker")
+			Expect(registry).To(Equal("ghcr.io"))
+			Expect(repository).To(Equal("lukaszraczylo/worker"))
+
+			registry, repository = ParseImageReference("localhost:5000/app")
+			Expect(registry).To(Equal("localhost:5000"))
+			Expect(repository).To(Equal("app"))
+
+			registry, repository = ParseImageReference("index.docker.io/library/busybox")
+			Expect(registry).To(Equal("docker.io"))
+			Expect(repository).To(Equal("library/busybox"))
+		})
+	})
+
+	Context("When parsing the docker config", func() {
+		It("should decode the auth field", func() {
+			encoded := base64.StdEncoding.EncodeToString([]byte("robot:secret"))
+			auths, err := ParseDockerConfig([]byte(`{"auths":{"https://index.docker.io/v1/":{"auth":"` + encoded + `"},"ghcr.io":{"username":"user","password":"pass"}}}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(auths).To(HaveKeyWithValue("docker.io", RegistryAuth{Username: "robot", Password: "secret", Auth: encoded}))
+			Expect(auths).To(HaveKeyWithValue("ghcr.io", RegistryAuth{Username: "user", Password: "pass"}))
+		})
+
+		It("should reject the malformed config", func() {
+			_, err := ParseDockerConfig([]byte(`{"auths":{"ghcr.io":{"auth":"%%%"}}}`))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("When resolving the digest", func() {
+		It("should use the digest returned on HEAD", func() {
+			registry := newFakeRegistry("", true)
+			defer registry.server.Close()
+			client := NewRegistryClient(nil)
+			client.HTTPClient = registry.server.Client()
+
+			digest, err := client.ResolveDigest(ctx, registry.host()+"/team/app", "1.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(digest).To(Equal(registry.digest()))
+			Expect(registry.requests).To(Equal([]string{"HEAD /v2/team/app/manifests/1.0"}))
+
+			By("serving the repeated lookups from the cache")
+			_, err = client.ResolveDigest(ctx, registry.host()+"/team/app", "1.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(registry.requests).To(HaveLen(1))
+		})
+
+		It("should calculate the digest when the registry does not return it", func() {
+			registry := newFakeRegistry("", false)
+			defer registry.server.Close()
+			client := NewRegistryClient(nil)
+			client.HTTPClient = registry.server.Client()
+
+			digest, err := client.ResolveDigest(ctx, registry.host()+"/team/app", "1.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(digest).To(Equal(registry.digest()))
+		})
+
+		It("should authenticate with the bearer token", func() {
+			registry := newFakeRegistry("t0k3n", true)
+			defer registry.server.Close()
+			client := NewRegistryClient(map[string]RegistryAuth{registry.host(): {Username: "robot", Password: "secret"}})
+			client.HTTPClient = registry.server.Client()
+
+			digest, err := client.ResolveDigest(ctx, registry.host()+"/team/app", "1.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(digest).To(Equal(registry.digest()))
+			Expect(registry.requests).To(ContainElement("GET /token"))
+
+			By("reusing the token for the next requests")
+			registry.requests = nil
+			_, err = client.ResolveDigest(ctx, registry.host()+"/team/app", "sha256:1111")
+			Expect(err).To(HaveOccurred())
+			Expect(registry.requests).To(Equal([]string{"HEAD /v2/team/app/manifests/sha256:1111"}))
+		})
+
+		It("should fail without the valid credentials", func() {
+			registry := newFakeRegistry("t0k3n", true)
+			defer registry.server.Close()
+			client := NewRegistryClient(nil)
+			client.HTTPClient = registry.server.Client()
+
+			_, err := client.ResolveDigest(ctx, registry.host()+"/team/app", "1.0")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return the registry error for the unknown tag", func() {
+			registry := newFakeRegistry("", true)
+			defer registry.server.Close()
+			client := NewRegistryClient(nil)
+			client.HTTPClient = registry.server.Client()
+
+			_, err := client.ResolveDigest(ctx, registry.host()+"/team/app", "2.0")
+			var registryErr *RegistryError
+			Expect(err).To(BeAssignableToTypeOf(registryErr))
+			Expect(err.(*RegistryError).StatusCode).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("When estimating the image size", func() {
+		It("should sum the config and layers of the platform manifest", func() {
+			registry := newFakeRegistry("", true)
+			defer registry.server.Close()
+			registry.manifest = fmt.Sprintf(`{"manifests":[
+				{"digest":"sha256:other","platform":{"os":"windows","architecture":"amd64"}},
+				{"digest":"sha256:native","platform":{"os":%q,"architecture":%q}}]}`, runtime.GOOS, runtime.GOARCH)
+			registry.manifests = map[string]string{
+				"sha256:other":  `{"config":{"size":1},"layers":[{"size":1000}]}`,
+				"sha256:native": `{"config":{"size":10},"layers":[{"size":100},{"size":200}]}`,
+			}
+			client := NewRegistryClient(nil)
+			client.HTTPClient = registry.server.Client()
+
+			size, err := client.ImageSize(ctx, registry.host()+"/team/app", "1.0", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(int64(310)))
+
+			By("summing the requested platforms")
+			size, err = client.ImageSize(ctx, registry.host()+"/team/app", "1.0", []string{"windows/amd64"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(int64(1001)))
+			size, err = client.ImageSize(ctx, registry.host()+"/team/app", "1.0", []string{PLATFORM_ALL})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(int64(1311)))
+		})
+
+		It("should read the single platform manifest directly", func() {
+			registry := newFakeRegistry("", true)
+			defer registry.server.Close()
+			registry.manifests = map[string]string{"sha256:single": `{"config":{"size":5},"layers":[{"size":50}]}`}
+			client := NewRegistryClient(nil)
+			client.HTTPClient = registry.server.Client()
+
+			size, err := client.ImageSize(ctx, registry.host()+"/team/app", "sha256:single", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(int64(55)))
+		})
+
+		It("should ask for the size of the layers missing it with HEAD", func() {
+			registry := newFakeRegistry("", true)
+			defer registry.server.Close()
+			registry.manifests = map[string]string{"sha256:single": `{"config":{"size":5},"layers":[{"digest":"sha256:layer"},{"digest":"sha256:sized","size":50}]}`}
+			registry.blobs = map[string]int{"sha256:layer": 500}
+			client := NewRegistryClient(nil)
+			client.HTTPClient = registry.server.Client()
+
+			size, err := client.ImageSize(ctx, registry.host()+"/team/app", "sha256:single", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(int64(555)))
+			Expect(registry.requests).To(Equal([]string{"GET /v2/team/app/manifests/sha256:single", "HEAD /v2/team/app/blobs/sha256:layer"}))
+		})
+	})
+	Context("When discovering the referrers", func() {
+		signature := `{"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[]}`
+		signatureDigest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(signature)))
+		attestation := `{"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[{}]}`
+		attestationDigest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(attestation)))
+
+		It("should list the referrers API results with the cosign tags", func() {
+			registry := newFakeRegistry("", true)
+			defer registry.server.Close()
+			registry.referrers = map[string]string{"sha256:1111": fmt.Sprintf(`{"manifests":[
+				{"digest":"sha256:2222","artifactType":"application/spdx+json"},
+				{"digest":%q,"artifactType":"application/vnd.dev.cosign.simplesigning.v1+json"}]}`, signatureDigest)}
+			registry.manifests = map[string]string{
+				"sha256-1111.sig": signature,
+				"sha256-1111.att": attestation,
+			}
+			client := NewRegistryClient(nil)
+			client.HTTPClient = registry.server.Client()
+
+			referrers, err := client.Referrers(ctx, registry.host()+"/team/app", "sha256:1111")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(referrers).To(Equal([]raczylocomv1.ClusterImageReferrer{
+				{Kind: REFERRER_SBOM, Digest: "sha256:2222", ArtifactType: "application/spdx+json"},
+				{Kind: REFERRER_SIGNATURE, Digest: signatureDigest, ArtifactType: "application/vnd.dev.cosign.simplesigning.v1+json", Tag: "sha256-1111.sig"},
+				{Kind: REFERRER_ATTESTATION, Digest: attestationDigest, Tag: "sha256-1111.att"},
+			}))
+		})
+
+		It("should fall back to the tag of the digest without the referrers API", func() {
+			registry := newFakeRegistry("", true)
+			defer registry.server.Close()
+			registry.manifests = map[string]string{
+				"sha256-1111": `{"manifests":[{"digest":"sha256:3333","artifactType":"application/vnd.in-toto+json"}]}`,
+			}
+			client := NewRegistryClient(nil)
+			client.HTTPClient = registry.server.Client()
+
+			referrers, err := client.Referrers(ctx, registry.host()+"/team/app", "sha256:1111")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(referrers).To(Equal([]raczylocomv1.ClusterImageReferrer{
+				{Kind: REFERRER_ATTESTATION, Digest: "sha256:3333", ArtifactType: "application/vnd.in-toto+json"},
+			}))
+
+			By("finding nothing for the unsigned image")
+			referrers, err = client.Referrers(ctx, registry.host()+"/team/app", "sha256:4444")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(referrers).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("Import destination", func() {
+	It("should replace the registry and keep the repository", func() {
+		Expect(ImportDestination("registry.internal:5000", "", ManifestImage{Image: "nginx", Tag: "1.27"})).
+			To(Equal("registry.internal:5000/library/nginx:1.27"))
+		Expect(ImportDestination("registry.internal:5000/", "", ManifestImage{Image: "ghcr.io/team/app", Tag: "v1"})).
+			To(Equal("registry.internal:5000/team/app:v1"))
+	})
+
+	It("should prepend the repository prefix", func() {
+		Expect(ImportDestination("registry.internal", "/mirror/", ManifestImage{Image: "minio/minio", Tag: "latest"})).
+			To(Equal("registry.internal/mirror/minio/minio:latest"))
+	})
+
+	It("should derive the tag from the digest", func() {
+		Expect(ImportDestination("registry.internal", "", ManifestImage{Image: "busybox", Sha: "sha256:abcd"})).
+			To(Equal("registry.internal/library/busybox:sha256-abcd"))
+	})
+})
+
+var _ = Describe("Mirror destination", func() {
+	container := Container{FullName: "ghcr.io/team/app:v1", Image: "ghcr.io/team/app", Tag: "v1", ImageNamespace: "prod"}
+
+	It("should keep the repository by default", func() {
+		destination, err := MirrorDestination(raczylocomv1.ClusterImageStorageRegistry{Registry: "staging.internal"}, container, "backup")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(destination).To(Equal("staging.internal/team/app:v1"))
+	})
+
+	It("should render the repository template", func() {
+		registry := raczylocomv1.ClusterImageStorageRegistry{
+			Registry:           "staging.internal",
+			RepositoryTemplate: "{{ .ExportName }}/{{ .SourceRegistry }}/{{ .Namespace }}/{{ .Name }}",
+		}
+		destination, err := MirrorDestination(registry, container, "backup")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(destination).To(Equal("staging.internal/backup/ghcr.io/prod/app:v1"))
+	})
+
+	It("should reject the invalid template", func() {
+		registry := raczylocomv1.ClusterImageStorageRegistry{Registry: "staging.internal", RepositoryTemplate: "{{ .Missing }}"}
+		_, err := MirrorDestination(registry, container, "backup")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,125 @@
+package shared
+
+import (
+	"strings"
+
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+)
+
+// ValidateExport returns the warnings and the field errors of the export. The admission webhook rejects
+// such export when it's applied and the controller fails it, so the checks hold with the webhook disabled.
+func ValidateExport(clusterImageExport *raczylocomv1.ClusterImageExport, forbidInlineCredentials bool) ([]string, field.ErrorList) {
+	warnings, allErrs := validateExportSpec(&clusterImageExport.Spec, field.NewPath("spec"), forbidInlineCredentials)
+	if clusterImageExport.Spec.Baseline != "" && clusterImageExport.Spec.Baseline == clusterImageExport.Name {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "baseline"), clusterImageExport.Spec.Baseline, "export can't be its own baseline"))
+	}
+	return warnings, allErrs
+}
+
+// validateExportSpec returns the field errors of the export spec
+func validateExportSpec(spec *raczylocomv1.ClusterImageExportSpec, specPath *field.Path, forbidInlineCredentials bool) ([]string, field.ErrorList) {
+	allErrs := field.ErrorList{}
+
+	if !strings.HasPrefix(spec.BasePath, "/") {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("basePath"), spec.BasePath, "must start with /"))
+	}
+	if spec.MaxConcurrentJobs < 1 || spec.MaxConcurrentJobs > 100 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("maxConcurrentJobs"), spec.MaxConcurrentJobs, "must be between 1 and 100"))
+	}
+	for i, image := range spec.AdditionalImages {
+		if _, err := ProcessContainerName(image); err != nil {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("additionalImages").Index(i), image, err.Error()))
+		}
+	}
+
+	if err := ValidateImageFilters(spec.Filters.Include); err != nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("filters", "include"), "", err.Error()))
+	}
+	if err := ValidateImageFilters(spec.Filters.Exclude); err != nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("filters", "exclude"), "", err.Error()))
+	}
+	selectorOptions := metav1validation.LabelSelectorValidationOptions{}
+	allErrs = append(allErrs, metav1validation.ValidateLabelSelector(spec.NamespaceSelector, selectorOptions, specPath.Child("namespaceSelector"))...)
+	allErrs = append(allErrs, metav1validation.ValidateLabelSelector(spec.ExcludedNamespaceSelector, selectorOptions, specPath.Child("excludedNamespaceSelector"))...)
+	allErrs = append(allErrs, metav1validation.ValidateLabelSelector(spec.WorkloadSelector, selectorOptions, specPath.Child("workloadSelector"))...)
+
+	if err := ValidatePlatforms(spec.Platforms); err != nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("platforms"), spec.Platforms, err.Error()))
+	} else if MultiPlatform(spec.Platforms) && (spec.Format == "" || spec.Format == FORMAT_DOCKER_ARCHIVE) && spec.Storage.StorageTarget != STORAGE_REGISTRY {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("platforms"), spec.Platforms, "docker-archive holds a single platform, use oci-archive or oci-layout format"))
+	}
+
+	// Referrers are attached to the digest of the whole image, which the export has to keep
+	if spec.Referrers {
+		if spec.Storage.StorageTarget == STORAGE_REGISTRY {
+			if len(spec.Platforms) > 0 && !AllPlatforms(spec.Platforms) {
+				allErrs = append(allErrs, field.Invalid(specPath.Child("referrers"), spec.Referrers, "referrers need all the platforms of the image to be mirrored"))
+			}
+		} else if (spec.Format != FORMAT_OCI_ARCHIVE && spec.Format != FORMAT_OCI_LAYOUT) || !AllPlatforms(spec.Platforms) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("referrers"), spec.Referrers, "referrers need the oci-archive or oci-layout format with all platforms"))
+		}
+	}
+
+	retryPolicy := spec.RetryPolicy
+	retryPath := specPath.Child("retryPolicy")
+	if backoff := retryPolicy.InitialBackoff; backoff != nil && backoff.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(retryPath.Child("initialBackoff"), backoff.Duration.String(), "must be positive"))
+	}
+	if backoff := retryPolicy.MaxBackoff; backoff != nil && backoff.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(retryPath.Child("maxBackoff"), backoff.Duration.String(), "must be positive"))
+	}
+	if retryPolicy.InitialBackoff != nil && retryPolicy.MaxBackoff != nil && retryPolicy.InitialBackoff.Duration > retryPolicy.MaxBackoff.Duration {
+		allErrs = append(allErrs, field.Invalid(retryPath.Child("initialBackoff"), retryPolicy.InitialBackoff.Duration.String(), "can't be longer than maxBackoff"))
+	}
+
+	warnings, storageErrs := validateExportStorage(&spec.Storage, specPath.Child("storage"), forbidInlineCredentials)
+	return warnings, append(allErrs, storageErrs...)
+}
+
+func validateExportStorage(storage *raczylocomv1.ClusterImageStorageSpec, storagePath *field.Path, forbidInlineCredentials bool) ([]string, field.ErrorList) {
+	var warnings []string
+	allErrs := field.ErrorList{}
+
+	switch storage.StorageTarget {
+	case STORAGE_S3:
+		s3 := storage.S3
+		s3Path := storagePath.Child("s3")
+		if s3.Bucket == "" {
+			allErrs = append(allErrs, field.Required(s3Path.Child("bucket"), ""))
+		}
+		hasKeys := s3.AccessKey != "" || s3.SecretKey != ""
+		if hasKeys && (s3.AccessKey == "" || s3.SecretKey == "") {
+			allErrs = append(allErrs, field.Invalid(s3Path, "", "both accessKey and secretKey have to be set"))
+		}
+		if hasKeys && forbidInlineCredentials {
+			allErrs = append(allErrs, field.Forbidden(s3Path.Child("accessKey"), "inline S3 credentials are forbidden, use secretName or useRole instead"))
+		}
+		if s3.UseRole && (hasKeys || s3.SecretName != "") {
+			warnings = append(warnings, "spec.storage.s3 access keys and secretName are ignored when the role is used")
+		}
+		if !s3.UseRole && !hasKeys && s3.SecretName == "" {
+			allErrs = append(allErrs, field.Required(s3Path, "one of accessKey and secretKey, secretName or useRole has to be set"))
+		}
+		if s3.RoleARN != "" && !s3.UseRole {
+			allErrs = append(allErrs, field.Invalid(s3Path.Child("roleARN"), s3.RoleARN, "roleARN requires useRole"))
+		}
+		if s3.UseRole && s3.Endpoint != "" {
+			allErrs = append(allErrs, field.Invalid(s3Path.Child("endpoint"), s3.Endpoint, "endpoint can't be used together with the role"))
+		}
+	case STORAGE_FILE:
+		if err := ValidateStorage(*storage, forbidInlineCredentials); err != nil {
+			allErrs = append(allErrs, field.Invalid(storagePath.Child("file"), "", err.Error()))
+		}
+	case STORAGE_REGISTRY:
+		registryPath := storagePath.Child("registry")
+		if storage.Registry.Registry == "" {
+			allErrs = append(allErrs, field.Required(registryPath.Child("registry"), ""))
+		} else if _, err := MirrorDestination(storage.Registry, Container{Image: "nginx", Tag: "latest"}, "export"); err != nil {
+			allErrs = append(allErrs, field.Invalid(registryPath.Child("repositoryTemplate"), storage.Registry.RepositoryTemplate, err.Error()))
+		}
+	}
+	return warnings, allErrs
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+	shared "github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
+)
+
+// log is for logging in this package.
+var clusterimageexportlog = logf.Log.WithName("clusterimageexport-resource")
+
+// SetupClusterImageExportWebhookWithManager registers the webhook for ClusterImageExport in the manager.
+func SetupClusterImageExportWebhookWithManager(mgr ctrl.Manager, forbidInlineCredentials bool) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&raczylocomv1.ClusterImageExport{}).
+		WithValidator(&ClusterImageExportCustomValidator{ForbidInlineCredentials: forbidInlineCredentials}).
+		WithDefaulter(&ClusterImageExportCustomDefaulter{}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-raczylo-com-v1-clusterimageexport,mutating=true,failurePolicy=fail,sideEffects=None,groups=raczylo.com,resources=clusterimageexports,verbs=create;update,versions=v1,name=mclusterimageexport-v1.kb.io,admissionReviewVersions=v1
+
+// ClusterImageExportCustomDefaulter fills the safe defaults of the ClusterImageExport
+type ClusterImageExportCustomDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &ClusterImageExportCustomDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the Kind ClusterImageExport.
+func (d *ClusterImageExportCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	clusterimageexport, ok := obj.(*raczylocomv1.ClusterImageExport)
+	if !ok {
+		return fmt.Errorf("expected an ClusterImageExport object but got %T", obj)
+	}
+	clusterimageexportlog.Info("Defaulting for ClusterImageExport", "name", clusterimageexport.GetName())
+
+	defaultSpec(&clusterimageexport.Spec)
+	if clusterimageexport.Spec.Name == "" {
+		clusterimageexport.Spec.Name = clusterimageexport.Name
+	}
+	return nil
+}
+
+// defaultSpec fills the defaults the controllers would otherwise assume
+func defaultSpec(spec *raczylocomv1.ClusterImageExportSpec) {
+	if spec.Source == "" {
+		spec.Source = shared.SOURCE_SPEC
+	}
+	if spec.Format == "" {
+		spec.Format = shared.FORMAT_DOCKER_ARCHIVE
+	}
+	if spec.BasePath != "/" {
+		spec.BasePath = strings.TrimSuffix(spec.BasePath, "/")
+	}
+	if spec.Storage.StorageTarget == shared.STORAGE_REGISTRY && spec.Storage.Registry.RepositoryTemplate == "" {
+		spec.Storage.Registry.RepositoryTemplate = shared.DEFAULT_REPOSITORY_TEMPLATE
+	}
+	for i := range spec.CustomWorkloads {
+		if len(spec.CustomWorkloads[i].PodSpecPaths) == 0 {
+			spec.CustomWorkloads[i].PodSpecPaths = []string{shared.DEFAULT_POD_SPEC_PATH}
+		}
+	}
+}
+
+// +kubebuilder:webhook:path=/validate-raczylo-com-v1-clusterimageexport,mutating=false,failurePolicy=fail,sideEffects=None,groups=raczylo.com,resources=clusterimageexports,verbs=create;update,versions=v1,name=vclusterimageexport-v1.kb.io,admissionReviewVersions=v1
+
+// ClusterImageExportCustomValidator rejects the ClusterImageExport specs which would fail only once the export runs
+type ClusterImageExportCustomValidator struct {
+	// Reject the resources with the S3 keys set inline instead of the secret
+	ForbidInlineCredentials bool
+}
+
+var _ webhook.CustomValidator = &ClusterImageExportCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type ClusterImageExport.
+func (v *ClusterImageExportCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	clusterimageexport, ok := obj.(*raczylocomv1.ClusterImageExport)
+	if !ok {
+		return nil, fmt.Errorf("expected a ClusterImageExport object but got %T", obj)
+	}
+	clusterimageexportlog.Info("Validation for ClusterImageExport upon creation", "name", clusterimageexport.GetName())
+
+	return v.validate(clusterimageexport)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type ClusterImageExport.
+func (v *ClusterImageExportCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	clusterimageexport, ok := newObj.(*raczylocomv1.ClusterImageExport)
+	if !ok {
+		return nil, fmt.Errorf("expected a ClusterImageExport object for the newObj but got %T", newObj)
+	}
+	oldclusterimageexport, ok := oldObj.(*raczylocomv1.ClusterImageExport)
+	if !ok {
+		return nil, fmt.Errorf("expected a ClusterImageExport object for the oldObj but got %T", oldObj)
+	}
+	clusterimageexportlog.Info("Validation for ClusterImageExport upon update", "name", clusterimageexport.GetName())
+
+	// Export admitted by the older rules has to stay deletable, the finalizer and annotations change without the spec
+	if !clusterimageexport.DeletionTimestamp.IsZero() || equality.Semantic.DeepEqual(oldclusterimageexport.Spec, clusterimageexport.Spec) {
+		return nil, nil
+	}
+	return v.validate(clusterimageexport)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type ClusterImageExport.
+func (v *ClusterImageExportCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *ClusterImageExportCustomValidator) validate(clusterimageexport *raczylocomv1.ClusterImageExport) (admission.Warnings, error) {
+	warnings, allErrs := shared.ValidateExport(clusterimageexport, v.ForbidInlineCredentials)
+	if len(allErrs) == 0 {
+		return warnings, nil
+	}
+	return warnings, apierrors.NewInvalid(raczylocomv1.GroupVersion.WithKind("ClusterImageExport").GroupKind(), clusterimageexport.Name, allErrs)
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	raczylocomv1 "github.com/lukaszraczylo/kubernetes-images-sync-operator/api/raczylo.com/v1"
+	shared "github.com/lukaszraczylo/kubernetes-images-sync-operator/internal/shared"
+)
+
+var _ = Describe("ClusterImageExport Webhook", func() {
+	var (
+		obj       *raczylocomv1.ClusterImageExport
+		validator ClusterImageExportCustomValidator
+		defaulter ClusterImageExportCustomDefaulter
+	)
+
+	BeforeEach(func() {
+		obj = &raczylocomv1.ClusterImageExport{
+			ObjectMeta: metav1.ObjectMeta{Name: "backup-20240901", Namespace: "default"},
+			Spec: raczylocomv1.ClusterImageExportSpec{
+				BasePath: "/images",
+				Storage: raczylocomv1.ClusterImageStorageSpec{
+					StorageTarget: shared.STORAGE_S3,
+					S3:            raczylocomv1.ClusterImageStorageS3{Bucket: "backup", Region: "us-west-2", SecretName: "s3-credentials"},
+				},
+				MaxConcurrentJobs: 2,
+			},
+		}
+		validator = ClusterImageExportCustomValidator{}
+		defaulter = ClusterImageExportCustomDefaulter{}
+	})
+
+	// fieldErrors returns the paths of the rejected fields
+	fieldErrors := func(err error) []string {
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		fields := []string{}
+		for _, cause := range err.(*apierrors.StatusError).ErrStatus.Details.Causes {
+			fields = append(fields, cause.Field)
+		}
+		return fields
+	}
+
+	Context("When creating ClusterImageExport under Defaulting Webhook", func() {
+		It("Should fill in the default values", func() {
+			obj.Spec.BasePath = "/images/"
+			obj.Spec.Storage = raczylocomv1.ClusterImageStorageSpec{StorageTarget: shared.STORAGE_REGISTRY}
+			obj.Spec.CustomWorkloads = []raczylocomv1.CustomWorkload{{APIVersion: "argoproj.io/v1alpha1", Kind: "Rollout"}}
+			Expect(defaulter.Default(context.Background(), obj)).To(Succeed())
+
+			Expect(obj.Spec.Name).To(Equal("backup-20240901"))
+			Expect(obj.Spec.BasePath).To(Equal("/images"))
+			Expect(obj.Spec.Source).To(Equal(shared.SOURCE_SPEC))
+			Expect(obj.Spec.Format).To(Equal(shared.FORMAT_DOCKER_ARCHIVE))
+			Expect(obj.Spec.Storage.Registry.RepositoryTemplate).To(Equal(shared.DEFAULT_REPOSITORY_TEMPLATE))
+			Expect(obj.Spec.CustomWorkloads[0].PodSpecPaths).To(Equal([]string{shared.DEFAULT_POD_SPEC_PATH}))
+		})
+	})
+
+	Context("When creating or updating ClusterImageExport under Validating Webhook", func() {
+		It("Should admit the valid export", func() {
+			Expect(validator.ValidateCreate(context.Background(), obj)).To(BeEmpty())
+			Expect(validator.ValidateUpdate(context.Background(), obj, obj)).To(BeEmpty())
+		})
+
+		It("Should reject the invalid fields of the spec", func() {
+			obj.Spec.BasePath = "images"
+			obj.Spec.MaxConcurrentJobs = 0
+			obj.Spec.AdditionalImages = []string{"busybox:1.36", "nginx@md5:1234"}
+			_, err := validator.ValidateCreate(context.Background(), obj)
+			Expect(fieldErrors(err)).To(ConsistOf("spec.basePath", "spec.maxConcurrentJobs", "spec.additionalImages[1]"))
+		})
+
+		It("Should reject the S3 storage without usable credentials", func() {
+			obj.Spec.Storage.S3 = raczylocomv1.ClusterImageStorageS3{Bucket: "backup", RoleARN: "arn:aws:iam::123456789012:role/backup"}
+			_, err := validator.ValidateCreate(context.Background(), obj)
+			Expect(fieldErrors(err)).To(ConsistOf("spec.storage.s3", "spec.storage.s3.roleARN"))
+
+			obj.Spec.Storage.S3 = raczylocomv1.ClusterImageStorageS3{Bucket: "backup", AccessKey: "yyy"}
+			_, err = validator.ValidateCreate(context.Background(), obj)
+			Expect(fieldErrors(err)).To(ConsistOf("spec.storage.s3"))
+		})
+
+		It("Should reject the inline keys only when forbidden", func() {
+			obj.Spec.Storage.S3 = raczylocomv1.ClusterImageStorageS3{Bucket: "backup", AccessKey: "yyy", SecretKey: "zzz"}
+			Expect(validator.ValidateCreate(context.Background(), obj)).To(BeEmpty())
+
+			validator.ForbidInlineCredentials = true
+			_, err := validator.ValidateCreate(context.Background(), obj)
+			Expect(fieldErrors(err)).To(ConsistOf("spec.storage.s3.accessKey"))
+		})
+
+		It("Should warn about the credentials ignored by the role", func() {
+			obj.Spec.Storage.S3.UseRole = true
+			warnings, err := validator.ValidateCreate(context.Background(), obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(HaveLen(1))
+		})
+
+		It("Should reject the file and registry storage without the destination", func() {
+			obj.Spec.Storage = raczylocomv1.ClusterImageStorageSpec{StorageTarget: shared.STORAGE_FILE}
+			_, err := validator.ValidateCreate(context.Background(), obj)
+			Expect(fieldErrors(err)).To(ConsistOf("spec.storage.file"))
+
+			obj.Spec.Storage.File.HostPath = &corev1.HostPathVolumeSource{Path: "/var/backups"}
+			Expect(validator.ValidateCreate(context.Background(), obj)).To(BeEmpty())
+
+			obj.Spec.Storage = raczylocomv1.ClusterImageStorageSpec{
+				StorageTarget: shared.STORAGE_REGISTRY,
+				Registry:      raczylocomv1.ClusterImageStorageRegistry{Registry: "registry.internal", RepositoryTemplate: "{{ .Missing }}"},
+			}
+			_, err = validator.ValidateCreate(context.Background(), obj)
+			Expect(fieldErrors(err)).To(ConsistOf("spec.storage.registry.repositoryTemplate"))
+		})
+
+		It("Should reject the filter rules which don't compile", func() {
+			obj.Spec.Filters = raczylocomv1.ImageFilters{
+				Include: []raczylocomv1.ImageFilter{{Field: shared.FILTER_FIELD_TAG, Match: shared.FILTER_MATCH_SEMVER, Value: ">=1.25.0"}},
+				Exclude: []raczylocomv1.ImageFilter{{Match: shared.FILTER_MATCH_REGEX, Value: "nginx("}},
+			}
+			_, err := validator.ValidateCreate(context.Background(), obj)
+			Expect(fieldErrors(err)).To(ConsistOf("spec.filters.exclude"))
+		})
+
+		It("Should reject the invalid label selectors", func() {
+			obj.Spec.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "true"}}
+			obj.Spec.WorkloadSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "backup", Operator: metav1.LabelSelectorOpIn},
+			}}
+			_, err := validator.ValidateCreate(context.Background(), obj)
+			Expect(fieldErrors(err)).To(ConsistOf("spec.workloadSelector.matchExpressions[0].values"))
+		})
+
+		It("Should reject more platforms in the docker archive", func() {
+			obj.Spec.Platforms = []string{"linux/amd64", "linux/arm64"}
+			_, err := validator.ValidateCreate(context.Background(), obj)
+			Expect(fieldErrors(err)).To(ConsistOf("spec.platforms"))
+
+			obj.Spec.Format = shared.FORMAT_OCI_ARCHIVE
+			_, err = validator.ValidateCreate(context.Background(), obj)
+			Expect(err).NotTo(HaveOccurred())
+
+			obj.Spec.Platforms = []string{shared.PLATFORM_ALL, "linux/amd64"}
+			_, err = validator.ValidateCreate(context.Background(), obj)
+			Expect(fieldErrors(err)).To(ConsistOf("spec.platforms"))
+		})
+
+		It("Should reject the referrers of the image stored with the other digest", func() {
+			obj.Spec.Referrers = true
+			_, err := validator.ValidateCreate(context.Background(), obj)
+			Expect(fieldErrors(err)).To(ConsistOf("spec.referrers"))
+
+			obj.Spec.Format = shared.FORMAT_OCI_LAYOUT
+			obj.Spec.Platforms = []string{shared.PLATFORM_ALL}
+			Expect(validator.ValidateCreate(context.Background(), obj)).To(BeEmpty())
+
+			obj.Spec.Storage = raczylocomv1.ClusterImageStorageSpec{
+				StorageTarget: shared.STORAGE_REGISTRY,
+				Registry:      raczylocomv1.ClusterImageStorageRegistry{Registry: "registry.internal"},
+			}
+			obj.Spec.Platforms = []string{"linux/amd64"}
+			_, err = validator.ValidateCreate(context.Background(), obj)
+			Expect(fieldErrors(err)).To(ConsistOf("spec.referrers"))
+		})
+
+		It("Should reject the backoff of the retry policy out of order", func() {
+			obj.Spec.RetryPolicy = raczylocomv1.RetryPolicy{
+				InitialBackoff: &metav1.Duration{Duration: 5 * time.Minute},
+				MaxBackoff:     &metav1.Duration{Duration: time.Minute},
+			}
+			_, err := validator.ValidateCreate(context.Background(), obj)
+			Expect(fieldErrors(err)).To(ConsistOf("spec.retryPolicy.initialBackoff"))
+
+			obj.Spec.RetryPolicy.MaxBackoff.Duration = 0
+			_, err = validator.ValidateCreate(context.Background(), obj)
+			Expect(fieldErrors(err)).To(ConsistOf("spec.retryPolicy.initialBackoff", "spec.retryPolicy.maxBackoff"))
+		})
+
+		It("Should reject the export being its own baseline", func() {
+			obj.Spec.Baseline = obj.Name
+			_, err := validator.ValidateCreate(context.Background(), obj)
+			Expect(fieldErrors(err)).To(ConsistOf("spec.baseline"))
+		})
+
+		It("Should admit the update of the export rejected by the current rules without the spec change", func() {
+			obj.Spec.Storage.S3 = raczylocomv1.ClusterImageStorageS3{Bucket: "backup", AccessKey: "yyy", SecretKey: "zzz"}
+			validator.ForbidInlineCredentials = true
+			updated := obj.DeepCopy()
+			updated.Finalizers = []string{"finalizer.clusterimageexport.raczylo.com"}
+			Expect(validator.ValidateUpdate(context.Background(), obj, updated)).To(BeEmpty())
+
+			updated.Spec.MaxConcurrentJobs = 4
+			_, err := validator.ValidateUpdate(context.Background(), obj, updated)
+			Expect(fieldErrors(err)).To(ConsistOf("spec.storage.s3.accessKey"))
+
+			deletionTime := metav1.Now()
+			updated.DeletionTimestamp = &deletionTime
+			updated.Finalizers = nil
+			Expect(validator.ValidateUpdate(context.Background(), obj, updated)).To(BeEmpty())
+		})
+	})
+})